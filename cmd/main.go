@@ -2,50 +2,167 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
 
 	"10000hk.com/vip_gift/config"
 	"10000hk.com/vip_gift/internal/handler"
 	"10000hk.com/vip_gift/internal/mq" // 新增: 引入消费者
+	"10000hk.com/vip_gift/internal/proxy"
 	"10000hk.com/vip_gift/internal/repository"
 	"10000hk.com/vip_gift/internal/service"
+	"10000hk.com/vip_gift/internal/types"
 	"10000hk.com/vip_gift/pkg"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var TopicOrderCreate = "vip-order-create"
 var TopicOrderUpdate = "vip-order-update"
 var kafkaUrl = "localhost:9092"
 var consumerId = "order_consumer_group"
+var priceJobInterval = 1 * time.Minute
+var availabilityJobInterval = 1 * time.Minute
+var marginJobInterval = 1 * time.Hour
+var nonceCleanupInterval = 10 * time.Minute
+var gncSyncInterval = 30 * time.Minute
+var gncSyncURL = "https://api0.10000hk.com/api/product/gift/public/list"
+var gncSyncPageSize = 50
+var chargeSyncInterval = 60 * time.Minute
+var chargeSyncURL = "https://gift.10000hk.com/api/charge/product/list"
+var chargeSyncPageSize = 200
+var apiPrefix = "/api/product/gift"
+var healthCheckTimeout = 2 * time.Second
 
 func main() {
 	// 1) 加载环境变量
 	config.LoadEnv()
 
+	// 1.1) 结构化日志: LOG_FORMAT=json(默认)/text, LOG_LEVEL=info(默认)/debug/warn/error;
+	//      设为默认 logger 后, 其余 log.Printf 也经同一 handler 输出并脱敏
+	logger, err := pkg.NewLogger(os.Stdout, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	// 1.2) 链路追踪: OTEL_TRACES_EXPORTER=otlp / stdout, 空为不导出;
+	//      OTLP 地址用标准的 OTEL_EXPORTER_OTLP_ENDPOINT
+	shutdownTracer, err := pkg.InitTracer(context.Background(), os.Getenv("OTEL_TRACES_EXPORTER"), "vip_gift")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracer(context.Background())
+
 	// 2) 初始化DB & ES
 	db := config.InitDB()
 	esClient := config.InitES()
 
-	// 3) Fiber
-	app := config.SetupFiber()
+	// 3) Fiber; LEGACY_ERROR_STATUS=true 时错误响应仍为 HTTP 200, 兼容只看 body.code 的旧客户端
+	legacyErrorStatus, err := types.ParseLegacyErrorStatus(os.Getenv("LEGACY_ERROR_STATUS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler.SetLegacyErrorStatus(legacyErrorStatus)
+	app := config.SetupFiber(handler.ErrorHandler)
+	app.Use(handler.RequestID())
+	app.Use(handler.Tracing())
+	app.Use(handler.RequestMetrics())
+	// Prometheus 抓取入口, 挂在根路径, 不受 JWT/验签影响
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
 
 	// 4) 路由组： /api/product/gift
-	api := app.Group("/api/product/gift")
+	api := app.Group(apiPrefix)
 	// 如果需要JWT保护 => api.Use(handler.JWTMiddleware(jwtSecretKey))
 
 	// 5) 注册 Pub 模块
 	pubRepo := repository.NewPubRepo(db)
 	gncRepo := repository.NewGncRepo(db)
 
-	pubSvc := service.NewPubService(pubRepo, esClient, gncRepo)
+	// 永久删除前回溯订单引用的天数, 默认 90, 0 表示不检查
+	purgeGuardDays, err := types.ParsePurgeGuardDays(os.Getenv("PURGE_ORDER_GUARD_DAYS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pubSvc := service.NewPubService(pubRepo, esClient, gncRepo, purgeGuardDays)
 	pubHdl := handler.NewPubHandler(pubSvc)
-	pubHdl.RegisterRoutes(api)
+
+	// 定时调价: 到期的价格计划自动生效
+	stopPriceJob := pkg.StartTicker("PriceScheduleJob", priceJobInterval, func() error {
+		_, err := pubSvc.ApplyDuePriceChanges()
+		return err
+	})
+	defer stopPriceJob()
+
+	// 上架时间窗: 按窗口自动上/下架
+	stopAvailabilityJob := pkg.StartTicker("AvailabilityJob", availabilityJobInterval, func() error {
+		_, err := pubSvc.EnforceAvailabilityWindows()
+		return err
+	})
+	defer stopAvailabilityJob()
+
+	// 毛利巡检: base 调价后变亏损的 pub 标记复核
+	stopMarginJob := pkg.StartTicker("MarginJob", marginJobInterval, func() error {
+		_, err := pubSvc.RefreshMargins()
+		return err
+	})
+	defer stopMarginJob()
 
 	// 6) 注册 Gnc 模块
 
-	gncSvc := service.NewGncService(gncRepo)
-	gncHdl := handler.NewGncHandler(gncSvc)
-	gncHdl.RegisterRoutes(api)
+	// base 变更联动策略: unshelve / review(默认) / margin
+	propagationPolicy, err := types.ParsePropagationPolicy(os.Getenv("GNC_PROPAGATION_POLICY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// 上游已下架/删除的 base: ignore / unshelve(默认) / delete
+	missingAction, err := types.ParseMissingAction(os.Getenv("GNC_SYNC_MISSING_ACTION"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	gncSvc := service.NewGncService(gncRepo, pubSvc, propagationPolicy, missingAction, purgeGuardDays)
+
+	// 商品目录来源: 字段映射可用 JSON 覆盖默认值, 如 CHARGE_CATALOG_MAPPING='{"productName":"product"}'
+	giftMapping, err := types.ParseFieldMapping(os.Getenv("GIFT_CATALOG_MAPPING"), proxy.GiftCatalogMapping)
+	if err != nil {
+		log.Fatal(err)
+	}
+	chargeMapping, err := types.ParseFieldMapping(os.Getenv("CHARGE_CATALOG_MAPPING"), proxy.ChargeCatalogMapping)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// 来源 token 未配置时, 定时同步直接失败, 手动同步沿用调用方 token
+	giftSource := proxy.NewGiftCatalogSource(gncSyncURL, os.Getenv("GNC_SYNC_TOKEN"), giftMapping)
+	chargeSource := proxy.NewChargeCatalogSource(chargeSyncURL, os.Getenv("CHARGE_SYNC_TOKEN"), chargeMapping)
+
+	// 上游熔断与并发上限, 如 UPSTREAM_GUARD='{"failureThreshold":3,"openSeconds":60,"maxConcurrent":10}'
+	upstreamGuard, err := types.ParseUpstreamGuardConfig(os.Getenv("UPSTREAM_GUARD"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	proxy.ConfigureUpstreamGuards(upstreamGuard, types.CatalogGift, types.CatalogCharge)
+
+	gncHdl := handler.NewGncHandler(gncSvc, map[string]types.CatalogSource{
+		giftSource.Name():   giftSource,
+		chargeSource.Name(): chargeSource,
+	})
+
+	// 定时同步上游 base, 每个来源各自的周期
+	stopGncSyncJob := pkg.StartTicker("GncSyncJob", gncSyncInterval, func() error {
+		_, err := gncSvc.SyncFromRemote(types.SyncTriggerSchedule, giftSource, gncSyncPageSize)
+		return err
+	})
+	defer stopGncSyncJob()
+	stopChargeSyncJob := pkg.StartTicker("ChargeSyncJob", chargeSyncInterval, func() error {
+		_, err := gncSvc.SyncFromRemote(types.SyncTriggerSchedule, chargeSource, chargeSyncPageSize)
+		return err
+	})
+	defer stopChargeSyncJob()
 
 	// 7) 初始化 Kafka & Snowflake
 	//    从 pkg 包中获取初始化函数
@@ -55,12 +172,10 @@ func main() {
 	// 8) Order 模块
 	orderRepo := repository.NewOrderRepo(db)
 	// 这里的 orderSvc 是“只发Kafka” or “先插DB再发Kafka”，取决于order_service.go的模式
-	orderSvc := service.NewOrderService(orderRepo, kafkaWriter, snowflakeFn /*, esClient*/)
-	orderHdl := handler.NewOrderHandler(orderSvc, pubSvc)
-	orderHdl.RegisterRoutes(api) // POST /orders, GET /orders/:orderId
+	orderSvc := service.NewOrderService(orderRepo, kafkaWriter, snowflakeFn, pubSvc /*, esClient*/)
 	notifier := service.NewUpstreamNotifier("https://left.10000hk.com/api/order/upstream/update_order_status")
 	// 2) Create the QueryScheduler
-	scheduler := mq.NewQueryScheduler(100, notifier) // buffer size
+	scheduler := mq.NewQueryScheduler(100, 16, notifier) // buffer size, 同时在途的查单数
 	scheduler.Start()
 
 	// 9) 若要在同进程启动消费端:
@@ -77,8 +192,58 @@ func main() {
 	orderConsumer.Start()
 	defer orderConsumer.Stop()
 
+	// 9.0) 存活 / 就绪探针: GET /healthz 只看进程内的调度循环, GET /readyz 检查各依赖,
+	//      关键依赖(MySQL / ES / Kafka 写入 / 调度)异常时返回 503
+	schedulerCheck := types.HealthCheck{Name: "scheduler", Critical: true, Check: scheduler.Healthy}
+	healthSvc := service.NewHealthService(healthCheckTimeout,
+		[]types.HealthCheck{schedulerCheck},
+		[]types.HealthCheck{
+			{Name: "mysql", Critical: true, Check: service.DBHealthCheck(db)},
+			{Name: "elasticsearch", Critical: true, Check: service.ESHealthCheck(esClient, "vip_pub")},
+			{Name: "kafka_writer", Critical: true, Check: func(ctx context.Context) error {
+				return pkg.PingKafka(ctx, kafkaUrl, TopicOrderCreate, TopicOrderUpdate)
+			}},
+			// 消费端异常时仍可接单(消息留在 Kafka), 只标记 degraded
+			{Name: "kafka_reader", Critical: false, Check: orderConsumer.Healthy},
+			schedulerCheck,
+		},
+	)
+	handler.NewHealthHandler(healthSvc).RegisterRoutes(app)
+
+	// 9.1) 下游渠道账户 & 签名校验
+	partnerRateLimit, err := types.ParsePartnerRateLimit(os.Getenv("PARTNER_RATE_LIMIT"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	partnerSvc := service.NewPartnerService(repository.NewPartnerRepo(db), partnerRateLimit)
+	partnerHdl := handler.NewPartnerHandler(partnerSvc)
+	orderHdl := handler.NewOrderHandler(orderSvc, pubSvc, partnerSvc)
+	stopNonceJob := pkg.StartTicker("PartnerNonceJob", nonceCleanupInterval, func() error {
+		if _, err := partnerSvc.PurgeExpiredNonces(); err != nil {
+			return err
+		}
+		_, err := partnerSvc.PurgeRateWindows()
+		return err
+	})
+	defer stopNonceJob()
+
+	// 9.2) 注册路由
+	//      签名鉴权的下单接口必须最先注册: PubHandler 会在整个分组上挂 JWTMiddleware,
+	//      之后注册的路由(gnc / orders / partner)都要求 JWT; 文档页同理
+	handler.RegisterDocsRoutes(api, apiPrefix)
+	orderHdl.RegisterPartnerRoutes(api, handler.PartnerSignature(partnerSvc), handler.PartnerRateLimit(partnerSvc))
+	pubHdl.RegisterRoutes(api)
+	gncHdl.RegisterRoutes(api)
+	orderHdl.RegisterRoutes(api) // POST /orders/one, /orders/list ...
+	partnerHdl.RegisterRoutes(api)
+
+	// 9.3) 每个路由都必须有 OpenAPI 文档(handler/openapi.go 的 routeDocs), 由 handler/openapi_test.go 在 CI 中保证; 这里只告警
+	if missing := handler.UndocumentedRoutes(app, apiPrefix); len(missing) > 0 {
+		slog.Warn("routes without OpenAPI docs", "routes", strings.Join(missing, ", "))
+	}
+
 	// 10) 启动 Fiber
 	addr := ":3001"
-	fmt.Printf("Server listening on %s\n", addr)
+	slog.Info("server listening", "addr", addr)
 	log.Fatal(app.Listen(addr))
 }
@@ -50,8 +50,34 @@ func InitDB() *gorm.DB {
 		&types.PubEntity{},
 		&types.PubComposeEntity{},
 		&types.OrderEntity{},
+		&types.PubCategoryLogEntity{},
+		&types.PubPriceHistoryEntity{},
+		&types.PubPriceScheduleEntity{},
+		&types.PubAvailabilityEntity{},
+		&types.PubReservationEntity{},
+		&types.PubDailyStockEntity{},
+		&types.OrderItemEntity{},
+		&types.GncSyncRunEntity{},
+		&types.PartnerKeyEntity{},
+		&types.PartnerNonceEntity{},
+		&types.PartnerEntity{},
+		&types.PartnerRateWindowEntity{},
+		&types.PartnerDailyUsageEntity{},
 	)
 
+	// 旧数据: 同步写入的上游原始 JSON 曾放在 origin_data, 迁到 upstream_raw, origin_data 只保留手工配置的福禄商品ID
+	if err := db.Model(&types.GncEntity{}).Unscoped().
+		Where("origin_data LIKE ? AND (upstream_raw IS NULL OR upstream_raw = '')", "{%").
+		Updates(map[string]interface{}{"upstream_raw": gorm.Expr("origin_data"), "origin_data": ""}).Error; err != nil {
+		log.Printf("[InitDB] move raw upstream data to upstream_raw failed: %v\n", err)
+	}
+	// 旧数据: 子单曾以 status=init 表示未发出, 其余状态的都已发出过
+	if err := db.Model(&types.OrderItemEntity{}).
+		Where("sent = ? AND status <> ?", false, types.StatusInit).
+		Update("sent", true).Error; err != nil {
+		log.Printf("[InitDB] backfill order_item_entities.sent failed: %v\n", err)
+	}
+
 	return db
 }
 
@@ -78,14 +104,27 @@ func InitTestDB() *gorm.DB {
 		&types.PubEntity{},
 		&types.PubComposeEntity{},
 		&types.OrderEntity{},
+		&types.PubCategoryLogEntity{},
+		&types.PubPriceHistoryEntity{},
+		&types.PubPriceScheduleEntity{},
+		&types.PubAvailabilityEntity{},
+		&types.PubReservationEntity{},
+		&types.PubDailyStockEntity{},
+		&types.OrderItemEntity{},
+		&types.GncSyncRunEntity{},
+		&types.PartnerKeyEntity{},
+		&types.PartnerNonceEntity{},
+		&types.PartnerEntity{},
+		&types.PartnerRateWindowEntity{},
+		&types.PartnerDailyUsageEntity{},
 	)
 
 	return db
 }
 
-// SetupFiber 启动 Fiber，附带默认中间件
-func SetupFiber() *fiber.App {
-	app := fiber.New()
+// SetupFiber 启动 Fiber，附带默认中间件; errorHandler 负责把 handler 返回的 error 转成统一响应
+func SetupFiber(errorHandler fiber.ErrorHandler) *fiber.App {
+	app := fiber.New(fiber.Config{ErrorHandler: errorHandler})
 	// 使用默认配置
 	app.Use(cors.New())
 
@@ -110,8 +149,9 @@ func InitES() *elasticsearch.Client {
 	indexName := "vip_pub"
 	resp, err := client.Indices.Exists([]string{indexName})
 	if err != nil {
+		// ES 暂时不可达: 仍返回 client, 恢复后可直接使用; 期间就绪探针(/readyz)报告 elasticsearch down
 		log.Printf("failed to check if index exists: %v", err)
-		return nil
+		return client
 	}
 	defer resp.Body.Close()
 
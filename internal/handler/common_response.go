@@ -2,8 +2,12 @@
 package handler
 
 import (
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"10000hk.com/vip_gift/internal/types"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -12,9 +16,22 @@ type ListRequest struct {
 	Size int64 `json:"size"`
 }
 type BatchCategoryRequest struct {
-	Prefix   string `json:"prefix"`
-	Category string `json:"category"`
-	Tag      string `json:"tag"`
+	Prefix    string   `json:"prefix"`
+	Regex     string   `json:"regex,omitempty"`
+	BaseCodes []string `json:"baseCodes,omitempty"`
+	MinPrice  float64  `json:"minPrice,omitempty"`
+	MaxPrice  float64  `json:"maxPrice,omitempty"`
+	Category  string   `json:"category"`
+	Tag       string   `json:"tag"`
+	DryRun    bool     `json:"dryRun,omitempty"` // true 时只预览命中的产品
+}
+type BatchUndoRequest struct {
+	BatchId string `json:"batchId"`
+}
+type DriftRequest struct {
+	PublicCode string `json:"publicCode,omitempty"` // 为空时按页检查全部
+	Page       int64  `json:"page"`
+	Size       int64  `json:"size"` // 不传或超过 500 时按 500
 }
 type SearchRequest struct {
 	Cate       int64    `json:"cate,omitempty"` // 可以加 omitempty
@@ -25,9 +42,10 @@ type SearchRequest struct {
 
 // 定义统一的响应结构
 type BaseResponse struct {
-	Code    int         `json:"code"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
+	Code      int         `json:"code"`
+	ErrorCode string      `json:"errorCode,omitempty"` // 稳定的机器可读错误码, 仅错误响应携带
+	Message   string      `json:"message"`
+	Data      interface{} `json:"data,omitempty"`
 }
 
 // 通用成功响应：默认 code=200
@@ -39,20 +57,57 @@ func SuccessJSON(c *fiber.Ctx, data interface{}) error {
 	})
 }
 
-// 通用错误响应：可带自定义 code
+// 通用错误响应：可带自定义 code, HTTP Status 与 code 一致(兼容模式下为 200, 见 SetLegacyErrorStatus)
 func ErrorJSON(c *fiber.Ctx, code int, msg string) error {
-	// 也可将 Fiber Status Code 与自定义Code统一
-	return c.Status(http.StatusOK).JSON(BaseResponse{
-		Code:    code,
-		Message: msg,
-		Data:    nil,
-	})
+	return writeError(c, code, types.KindOfStatus(code).Code(), msg, nil)
 }
 func Unauthorized(c *fiber.Ctx, msg string) error {
 	// 这里 HTTP Status 用 401，JSON 里 code 也给 401
-	return c.Status(http.StatusUnauthorized).JSON(BaseResponse{
-		Code:    http.StatusUnauthorized, // 401
-		Message: msg,
-		Data:    nil,
+	return writeError(c, http.StatusUnauthorized, types.KindUnauthorized.Code(), msg, nil)
+}
+
+func Forbidden(c *fiber.Ctx, msg string) error {
+	// 已登录但权限不足: HTTP Status 与 code 都用 403
+	return writeError(c, http.StatusForbidden, types.KindForbidden.Code(), msg, nil)
+}
+
+// TooManyRequests 限流/配额耗尽: HTTP 429 + Retry-After(秒, 向上取整)
+func TooManyRequests(c *fiber.Ctx, retryAfter time.Duration, msg string) error {
+	return writeRetryAfter(c, types.KindRateLimited.Code(), retryAfter, msg)
+}
+
+func writeRetryAfter(c *fiber.Ctx, errorCode string, retryAfter time.Duration, msg string) error {
+	secs := int(math.Ceil(retryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(secs))
+	return writeError(c, http.StatusTooManyRequests, errorCode, msg, fiber.Map{"retryAfter": secs})
+}
+
+// legacyErrorStatus 兼容旧客户端: 错误响应统一 HTTP 200, 只看 body.code;
+// 401/403/429 一直是真实状态码, 不受影响
+var legacyErrorStatus bool
+
+// SetLegacyErrorStatus 启动时设置, 见 types.ParseLegacyErrorStatus
+func SetLegacyErrorStatus(on bool) {
+	legacyErrorStatus = on
+}
+
+func writeError(c *fiber.Ctx, code int, errorCode, msg string, data interface{}) error {
+	status := code
+	switch {
+	case code < 400 || code > 599:
+		// 透传的上游业务码等非 HTTP 状态码
+		status = types.KindOfStatus(code).HTTPStatus()
+	case legacyErrorStatus && code != http.StatusUnauthorized &&
+		code != http.StatusForbidden && code != http.StatusTooManyRequests:
+		status = http.StatusOK
+	}
+	return c.Status(status).JSON(BaseResponse{
+		Code:      code,
+		ErrorCode: errorCode,
+		Message:   msg,
+		Data:      data,
 	})
 }
@@ -0,0 +1,42 @@
+// internal/handler/error_handler.go
+package handler
+
+import (
+	"errors"
+	"log/slog"
+
+	"10000hk.com/vip_gift/internal/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrorHandler Fiber 全局错误处理: handler 直接 return err 时按 types.AppError
+// 分类映射 HTTP 状态码与错误码; 内部错误只写日志, 对外统一 "internal server error"
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	// 1) Fiber 自身的错误(路由不存在、请求体过大等), 一直是真实状态码, 不受兼容开关影响
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return c.Status(fe.Code).JSON(BaseResponse{
+			Code:      fe.Code,
+			ErrorCode: types.KindOfStatus(fe.Code).Code(),
+			Message:   fe.Message,
+		})
+	}
+
+	// 2) 领域错误
+	return writeAppError(c, err, nil)
+}
+
+// writeAppError 按 types.AsAppError 分类写错误响应, data 可携带部分结果(如导入中已提交的批次)
+func writeAppError(c *fiber.Ctx, err error, data interface{}) error {
+	appErr := types.AsAppError(err)
+	if appErr.Kind == types.KindInternal || appErr.Kind == types.KindUnavailable {
+		slog.ErrorContext(c.UserContext(), "request failed", "method", c.Method(), "path", c.Path(), "error", err)
+	}
+
+	// 限流/配额带上 Retry-After
+	var ra *types.RetryAfterError
+	if errors.As(err, &ra) {
+		return writeRetryAfter(c, appErr.ErrorCode(), ra.RetryAfter, appErr.Message)
+	}
+	return writeError(c, appErr.Kind.HTTPStatus(), appErr.ErrorCode(), appErr.Message, data)
+}
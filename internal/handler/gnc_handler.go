@@ -11,22 +11,35 @@ import (
 )
 
 type GncHandler struct {
-	svc service.GncService
+	svc     service.GncService
+	sources map[string]types.CatalogSource // 来源名 => 适配器, 供手动同步使用
 }
 
-func NewGncHandler(svc service.GncService) *GncHandler {
-	return &GncHandler{svc: svc}
+func NewGncHandler(svc service.GncService, sources map[string]types.CatalogSource) *GncHandler {
+	return &GncHandler{svc: svc, sources: sources}
 }
 
 // 注册路由
 func (h *GncHandler) RegisterRoutes(r fiber.Router) {
 	// /api/product/gift/gnc
-	r.Post("/base", h.CreateGnc)
-	r.Get("/base/:baseCode", h.GetGnc)
-	r.Put("/base/:baseCode", h.UpdateGnc)
-	r.Delete("/base/:baseCode", h.DeleteGnc)
-	r.Post("/base/list", h.ListGnc) // 用 POST + body 或 GET + querystring 均可
-	r.Post("/base/sync", h.SyncGncRemote)
+	// 依赖 PubHandler.RegisterRoutes 在同一分组上挂好的 JWTMiddleware
+	read := RequirePermission(PermBaseRead)
+	write := RequirePermission(PermBaseWrite)
+	syncing := RequirePermission(PermSync)
+
+	r.Post("/base", write, h.CreateGnc)
+	r.Get("/base/:baseCode", read, h.GetGnc)
+	r.Put("/base/:baseCode", write, h.UpdateGnc)
+	r.Patch("/base/:baseCode", write, h.PatchGnc)
+	r.Delete("/base/:baseCode", write, h.DeleteGnc)
+	r.Post("/base/list", read, h.ListGnc) // 用 POST + body 或 GET + querystring 均可
+	r.Post("/base/sync", syncing, h.SyncGncRemote)
+	r.Post("/base/sync/runs", syncing, h.ListSyncRuns)
+
+	// 回收站
+	r.Post("/base/trash/list", read, h.ListTrash)
+	r.Post("/base/trash/:baseCode/restore", write, h.RestoreGnc)
+	r.Delete("/base/trash/:baseCode", RequirePermission(PermBasePurge), h.PurgeGnc)
 }
 
 func (h *GncHandler) CreateGnc(c *fiber.Ctx) error {
@@ -36,7 +49,7 @@ func (h *GncHandler) CreateGnc(c *fiber.Ctx) error {
 	}
 	created, err := h.svc.Create(&dto)
 	if err != nil {
-		return ErrorJSON(c, 500, err.Error())
+		return err
 	}
 	return SuccessJSON(c, created)
 }
@@ -45,7 +58,7 @@ func (h *GncHandler) GetGnc(c *fiber.Ctx) error {
 	baseCode := c.Params("baseCode")
 	data, err := h.svc.GetByBaseCode(baseCode)
 	if err != nil {
-		return ErrorJSON(c, 404, err.Error())
+		return err
 	}
 	return SuccessJSON(c, data)
 }
@@ -58,7 +71,20 @@ func (h *GncHandler) UpdateGnc(c *fiber.Ctx) error {
 	}
 	updated, err := h.svc.UpdateByBaseCode(baseCode, &dto)
 	if err != nil {
-		return ErrorJSON(c, 404, err.Error())
+		return err
+	}
+	return SuccessJSON(c, updated)
+}
+
+// PatchGnc merge-patch 或 updateMask, 见 types.Patch
+func (h *GncHandler) PatchGnc(c *fiber.Ctx) error {
+	patch, err := types.ParsePatch(c.Body(), c.Query("updateMask"))
+	if err != nil {
+		return ErrorJSON(c, 400, err.Error())
+	}
+	updated, err := h.svc.PatchByBaseCode(c.Params("baseCode"), patch)
+	if err != nil {
+		return err
 	}
 	return SuccessJSON(c, updated)
 }
@@ -66,7 +92,7 @@ func (h *GncHandler) UpdateGnc(c *fiber.Ctx) error {
 func (h *GncHandler) DeleteGnc(c *fiber.Ctx) error {
 	baseCode := c.Params("baseCode")
 	if err := h.svc.DeleteByBaseCode(baseCode); err != nil {
-		return ErrorJSON(c, 404, err.Error())
+		return err
 	}
 	return SuccessJSON(c, "Deleted")
 }
@@ -86,7 +112,7 @@ func (h *GncHandler) ListGnc(c *fiber.Ctx) error {
 
 	dataList, total, err := h.svc.List(req.Page, req.Size)
 	if err != nil {
-		return ErrorJSON(c, 500, err.Error())
+		return err
 	}
 
 	// 统一返回 {code, message, data:{dataList, total}}
@@ -98,15 +124,84 @@ func (h *GncHandler) ListGnc(c *fiber.Ctx) error {
 }
 
 // SyncGncRemote 手动触发同步第三方数据
+// Body(可选): { "source": "gift" | "charge", "pageSize": 10 }
 func (h *GncHandler) SyncGncRemote(c *fiber.Ctx) error {
-	// 也可以让前端传 pageSize
-	pageSize := 10
-	remoteURL := "https://api0.10000hk.com/api/product/gift/public/list"
-	tempToken := strings.TrimSpace(strings.TrimPrefix(c.Get("Authorization"), "Bearer "))
+	var req struct {
+		Source   string `json:"source"`
+		PageSize int    `json:"pageSize"`
+	}
+	_ = c.BodyParser(&req)
+	if req.Source == "" {
+		req.Source = types.CatalogGift
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = 10
+	}
+	src, ok := h.sources[req.Source]
+	if !ok {
+		return ErrorJSON(c, 400, "unknown source: "+req.Source)
+	}
+	// 未配置来源 token 时沿用调用方的 token
+	callerToken := strings.TrimSpace(strings.TrimPrefix(c.Get("Authorization"), "Bearer "))
+	src = src.WithToken(callerToken)
+
+	// 调用 service, 返回本次同步记录
+	run, err := h.svc.SyncFromRemote(types.SyncTriggerManual, src, req.PageSize)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, run)
+}
+
+// ListSyncRuns 同步历史
+// Body: { "page":1, "size":20 }
+func (h *GncHandler) ListSyncRuns(c *fiber.Ctx) error {
+	var req struct {
+		Page int64 `json:"page"`
+		Size int64 `json:"size"`
+	}
+	_ = c.BodyParser(&req)
+	dataList, total, err := h.svc.ListSyncRuns(req.Page, req.Size)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, fiber.Map{
+		"dataList": dataList,
+		"total":    total,
+	})
+}
+
+// ListTrash 回收站中的 base
+// Body: { "page":1, "size":20 }
+func (h *GncHandler) ListTrash(c *fiber.Ctx) error {
+	var req struct {
+		Page int64 `json:"page"`
+		Size int64 `json:"size"`
+	}
+	_ = c.BodyParser(&req)
+	dataList, total, err := h.svc.ListTrash(req.Page, req.Size)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, fiber.Map{
+		"dataList": dataList,
+		"total":    total,
+	})
+}
+
+// RestoreGnc 移出回收站
+func (h *GncHandler) RestoreGnc(c *fiber.Ctx) error {
+	restored, err := h.svc.RestoreByBaseCode(c.Params("baseCode"))
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, restored)
+}
 
-	// 调用 service
-	if err := h.svc.SyncFromRemote(remoteURL, pageSize, tempToken); err != nil {
-		return ErrorJSON(c, 500, err.Error())
+// PurgeGnc 永久删除, 近期订单仍引用时返回 409
+func (h *GncHandler) PurgeGnc(c *fiber.Ctx) error {
+	if err := h.svc.PurgeByBaseCode(c.Params("baseCode")); err != nil {
+		return err
 	}
-	return SuccessJSON(c, "Sync success")
+	return SuccessJSON(c, "Purged")
 }
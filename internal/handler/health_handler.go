@@ -0,0 +1,47 @@
+// internal/handler/health_handler.go
+package handler
+
+import (
+	"net/http"
+
+	"10000hk.com/vip_gift/internal/service"
+	"10000hk.com/vip_gift/internal/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+type HealthHandler struct {
+	svc service.HealthService
+}
+
+func NewHealthHandler(svc service.HealthService) *HealthHandler {
+	return &HealthHandler{svc: svc}
+}
+
+// RegisterRoutes 探针挂在根路径(与 /metrics 相同), 不经过 JWT / 验签
+func (h *HealthHandler) RegisterRoutes(r fiber.Router) {
+	r.Get("/healthz", h.Liveness)
+	r.Get("/readyz", h.Readiness)
+}
+
+// Liveness GET /healthz
+func (h *HealthHandler) Liveness(c *fiber.Ctx) error {
+	return writeHealth(c, h.svc.Liveness(c.UserContext()))
+}
+
+// Readiness GET /readyz, data.checks 中为各依赖的状态
+func (h *HealthHandler) Readiness(c *fiber.Ctx) error {
+	return writeHealth(c, h.svc.Readiness(c.UserContext()))
+}
+
+// writeHealth down => 503, up / degraded => 200; 探针只看状态码, 不受 LEGACY_ERROR_STATUS 影响
+func writeHealth(c *fiber.Ctx, report types.HealthReport) error {
+	code := http.StatusOK
+	if report.Status == types.HealthDown {
+		code = http.StatusServiceUnavailable
+	}
+	return c.Status(code).JSON(BaseResponse{
+		Code:    code,
+		Message: string(report.Status),
+		Data:    report,
+	})
+}
@@ -12,8 +12,9 @@ import (
 // CustomClaims 自定义的 JWT Claims 结构
 type CustomClaims struct {
 	jwt.RegisteredClaims
-	UserSn  string `json:"userSn"`
-	JwtHash string `json:"jwtHash"`
+	UserSn  string   `json:"userSn"`
+	JwtHash string   `json:"jwtHash"`
+	Roles   []string `json:"roles,omitempty"` // admin / operator / finance / crm / partner, 见 rbac.go
 }
 
 // JWTMiddleware 返回一个 Fiber handler，用于验证 JWT
@@ -61,6 +62,7 @@ func jwtSuccess(c *fiber.Ctx) error {
 	// 将自定义数据放到 Context，后续业务可在 c.Locals("userSn") 里取
 	c.Locals("userSn", claims.UserSn)
 	c.Locals("jwtHash", claims.JwtHash)
+	c.Locals("roles", claims.EffectiveRoles())
 
 	// 继续下一步
 	return c.Next()
@@ -0,0 +1,390 @@
+// internal/handler/openapi.go
+package handler
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"10000hk.com/vip_gift/internal/service"
+	"10000hk.com/vip_gift/internal/sink"
+	"10000hk.com/vip_gift/internal/types"
+	"10000hk.com/vip_gift/pkg"
+	"github.com/gofiber/fiber/v2"
+)
+
+// 路由鉴权方式
+const (
+	authNone      = ""
+	authJWT       = "jwt"
+	authSignature = "signature" // 下游 HMAC 签名, 见 types.SignPartnerRequest
+)
+
+// RouteDoc 一条路由的 OpenAPI 文档; Path 为 Fiber 语法(:param), 相对 API 分组前缀
+type RouteDoc struct {
+	Method   string
+	Path     string
+	Tag      string
+	Summary  string
+	Auth     string
+	Perm     Permission
+	Query    []string // query 参数名, 均为可选 string
+	Request  any      // 请求体, nil 表示无
+	Response any      // 成功时 BaseResponse.data, nil 表示无
+}
+
+// listOf 列表响应 { "dataList": [...], "total": n }
+type listOf struct{ elem any }
+
+type pageRequest struct {
+	Page int64 `json:"page"`
+	Size int64 `json:"size"`
+}
+
+// routeDocs 新增/修改路由时同步维护; openapi_test.go 用 UndocumentedRoutes 检查遗漏
+var routeDocs = []RouteDoc{
+	// ----- 下单(签名鉴权) -----
+	{Method: "POST", Path: "/orders/create", Tag: "order", Summary: "创建订单(下游签名 + 限流/配额)", Auth: authSignature,
+		Request: sink.OrderCreateReq{}, Response: sink.OrderCreateResp{}},
+
+	// ----- 商城公开接口 -----
+	{Method: "GET", Path: "/shop/one/:publicCode", Tag: "shop", Summary: "查询单个 pub", Response: types.PubDTO{}},
+	{Method: "POST", Path: "/shop/search", Tag: "shop", Summary: "按分类搜索 pub", Request: SearchRequest{}, Response: searchResponse},
+	{Method: "POST", Path: "/charge/search", Tag: "shop", Summary: "查询话费目录", Request: SearchRequest{}, Response: map[string]any{}},
+	{Method: "POST", Path: "/shop/categories", Tag: "shop", Summary: "全部分类", Response: listOf{categoryItem{}}},
+	{Method: "POST", Path: "/shop/list", Tag: "shop", Summary: "分页查询 pub", Query: []string{"page", "size"}, Request: ListRequest{}, Response: listOf{types.PubDTO{}}},
+
+	// ----- pub 管理 -----
+	{Method: "POST", Path: "/public", Tag: "pub", Summary: "创建 pub", Auth: authJWT, Perm: PermPubWrite, Request: types.PubDTO{}, Response: types.PubDTO{}},
+	{Method: "GET", Path: "/public/one/:publicCode", Tag: "pub", Summary: "查询 pub", Auth: authJWT, Perm: PermPubRead, Response: types.PubDTO{}},
+	{Method: "PUT", Path: "/public/one/:publicCode", Tag: "pub", Summary: "整体更新 pub", Auth: authJWT, Perm: PermPubWrite, Request: types.PubDTO{}, Response: types.PubDTO{}},
+	{Method: "PATCH", Path: "/public/one/:publicCode", Tag: "pub", Summary: "部分更新 pub(merge-patch / updateMask)", Auth: authJWT, Perm: PermPubWrite,
+		Query: []string{"updateMask"}, Request: types.PubDTO{}, Response: types.PubDTO{}},
+	{Method: "DELETE", Path: "/public/one/:publicCode", Tag: "pub", Summary: "删除 pub(移入回收站)", Auth: authJWT, Perm: PermPubWrite, Response: ""},
+	{Method: "POST", Path: "/public/list", Tag: "pub", Summary: "分页查询 pub", Auth: authJWT, Perm: PermPubRead,
+		Query: []string{"page", "size"}, Request: ListRequest{}, Response: listOf{types.PubDTO{}}},
+	{Method: "POST", Path: "/public/search", Tag: "pub", Summary: "按分类搜索 pub", Auth: authJWT, Perm: PermPubRead, Request: SearchRequest{}, Response: searchResponse},
+	{Method: "POST", Path: "/public/categories", Tag: "pub", Summary: "全部分类", Auth: authJWT, Perm: PermPubRead, Response: listOf{categoryItem{}}},
+	{Method: "POST", Path: "/public/batch_category", Tag: "pub", Summary: "按条件批量打分类(dryRun 预览)", Auth: authJWT, Perm: PermPubWrite,
+		Request: BatchCategoryRequest{}, Response: batchResult{}},
+	{Method: "POST", Path: "/public/batch_category/undo", Tag: "pub", Summary: "撤销批量分类", Auth: authJWT, Perm: PermPubWrite,
+		Request: BatchUndoRequest{}, Response: batchUndoResult{}},
+	{Method: "GET", Path: "/public/one/:publicCode/price_history", Tag: "pub", Summary: "价格历史", Auth: authJWT, Perm: PermPubRead,
+		Query: []string{"page", "size"}, Response: listOf{types.PubPriceHistoryEntity{}}},
+	{Method: "GET", Path: "/public/one/:publicCode/price_schedule", Tag: "pub", Summary: "定时调价计划", Auth: authJWT, Perm: PermPubRead,
+		Response: listOf{types.PubPriceScheduleEntity{}}},
+	{Method: "POST", Path: "/public/one/:publicCode/price_schedule", Tag: "pub", Summary: "新增定时调价", Auth: authJWT, Perm: PermPubWrite,
+		Request: types.PriceScheduleDTO{}, Response: types.PubPriceScheduleEntity{}},
+	{Method: "DELETE", Path: "/public/price_schedule/:id", Tag: "pub", Summary: "取消定时调价", Auth: authJWT, Perm: PermPubWrite, Response: ""},
+	{Method: "GET", Path: "/public/one/:publicCode/availability", Tag: "pub", Summary: "上架时间窗", Auth: authJWT, Perm: PermPubRead,
+		Response: listOf{types.PubAvailabilityEntity{}}},
+	{Method: "POST", Path: "/public/one/:publicCode/availability", Tag: "pub", Summary: "新增上架时间窗", Auth: authJWT, Perm: PermPubWrite,
+		Request: types.AvailabilityWindowDTO{}, Response: types.PubAvailabilityEntity{}},
+	{Method: "DELETE", Path: "/public/availability/:id", Tag: "pub", Summary: "删除上架时间窗", Auth: authJWT, Perm: PermPubWrite, Response: ""},
+	{Method: "POST", Path: "/public/drift", Tag: "pub", Summary: "组合快照漂移报告", Auth: authJWT, Perm: PermPubRead,
+		Request: DriftRequest{}, Response: listOf{types.PubDrift{}}},
+	{Method: "POST", Path: "/public/one/:publicCode/snapshot/refresh", Tag: "pub", Summary: "刷新组合快照", Auth: authJWT, Perm: PermPubWrite,
+		Response: struct {
+			Updated int `json:"updated"`
+		}{}},
+	{Method: "POST", Path: "/public/review/list", Tag: "pub", Summary: "待复核 pub", Auth: authJWT, Perm: PermPubRead,
+		Request: pageRequest{}, Response: listOf{types.PubDTO{}}},
+	{Method: "POST", Path: "/public/one/:publicCode/review/clear", Tag: "pub", Summary: "清除复核标记", Auth: authJWT, Perm: PermPubWrite, Response: ""},
+	{Method: "POST", Path: "/public/margin/report", Tag: "pub", Summary: "毛利报表", Auth: authJWT, Perm: PermPubRead, Response: listOf{types.MarginIssue{}}},
+	{Method: "POST", Path: "/public/import", Tag: "pub", Summary: "批量导入(JSON 数组或 CSV, 也可 multipart file)", Auth: authJWT, Perm: PermPubWrite,
+		Query: []string{"format", "dryRun"}, Request: []types.PubDTO{}, Response: types.ImportResult{}},
+	{Method: "GET", Path: "/public/export", Tag: "pub", Summary: "导出(format=csv 时返回 CSV 文件)", Auth: authJWT, Perm: PermPubRead,
+		Query: []string{"format"}, Response: listOf{types.PubDTO{}}},
+	{Method: "POST", Path: "/public/trash/list", Tag: "pub", Summary: "回收站列表", Auth: authJWT, Perm: PermPubRead,
+		Request: pageRequest{}, Response: listOf{types.PubDTO{}}},
+	{Method: "POST", Path: "/public/trash/:publicCode/restore", Tag: "pub", Summary: "移出回收站", Auth: authJWT, Perm: PermPubWrite, Response: types.PubDTO{}},
+	{Method: "DELETE", Path: "/public/trash/:publicCode", Tag: "pub", Summary: "永久删除", Auth: authJWT, Perm: PermPubPurge, Response: ""},
+
+	// ----- base(gnc) 管理 -----
+	{Method: "POST", Path: "/base", Tag: "base", Summary: "创建 base", Auth: authJWT, Perm: PermBaseWrite, Request: types.GncDTO{}, Response: types.GncDTO{}},
+	{Method: "GET", Path: "/base/:baseCode", Tag: "base", Summary: "查询 base", Auth: authJWT, Perm: PermBaseRead, Response: types.GncDTO{}},
+	{Method: "PUT", Path: "/base/:baseCode", Tag: "base", Summary: "整体更新 base", Auth: authJWT, Perm: PermBaseWrite, Request: types.GncDTO{}, Response: types.GncDTO{}},
+	{Method: "PATCH", Path: "/base/:baseCode", Tag: "base", Summary: "部分更新 base(merge-patch / updateMask)", Auth: authJWT, Perm: PermBaseWrite,
+		Query: []string{"updateMask"}, Request: types.GncDTO{}, Response: types.GncDTO{}},
+	{Method: "DELETE", Path: "/base/:baseCode", Tag: "base", Summary: "删除 base(移入回收站)", Auth: authJWT, Perm: PermBaseWrite, Response: ""},
+	{Method: "POST", Path: "/base/list", Tag: "base", Summary: "分页查询 base", Auth: authJWT, Perm: PermBaseRead,
+		Query: []string{"page", "size"}, Request: ListRequest{}, Response: listOf{types.GncDTO{}}},
+	{Method: "POST", Path: "/base/sync", Tag: "base", Summary: "触发远程目录同步", Auth: authJWT, Perm: PermSync,
+		Request: struct {
+			Source   string `json:"source"`
+			PageSize int    `json:"pageSize"`
+		}{}, Response: types.GncSyncRunEntity{}},
+	{Method: "POST", Path: "/base/sync/runs", Tag: "base", Summary: "同步记录", Auth: authJWT, Perm: PermSync,
+		Request: pageRequest{}, Response: listOf{types.GncSyncRunEntity{}}},
+	{Method: "POST", Path: "/base/trash/list", Tag: "base", Summary: "回收站列表", Auth: authJWT, Perm: PermBaseRead,
+		Request: pageRequest{}, Response: listOf{types.GncDTO{}}},
+	{Method: "POST", Path: "/base/trash/:baseCode/restore", Tag: "base", Summary: "移出回收站", Auth: authJWT, Perm: PermBaseWrite, Response: types.GncDTO{}},
+	{Method: "DELETE", Path: "/base/trash/:baseCode", Tag: "base", Summary: "永久删除", Auth: authJWT, Perm: PermBasePurge, Response: ""},
+
+	// ----- 订单 -----
+	{Method: "POST", Path: "/orders/one", Tag: "order", Summary: "查询单个订单", Auth: authJWT, Perm: PermOrderRead,
+		Request: struct {
+			OrderId string `json:"orderId"`
+		}{}, Response: types.ClientOrderDTO{}},
+	{Method: "POST", Path: "/orders/list", Tag: "order", Summary: "分页查询订单", Auth: authJWT, Perm: PermOrderRead,
+		Request: struct {
+			Page               int64    `json:"page"`
+			Size               int64    `json:"size"`
+			OrderIds           []string `json:"orderIds,omitempty"`
+			DownstreamOrderIds []string `json:"downstreamOrderIds,omitempty"`
+		}{}, Response: listOf{types.ClientOrderDTO{}}},
+	{Method: "POST", Path: "/orders/query", Tag: "order", Summary: "向上游查询订单状态", Auth: authJWT, Perm: PermOrderRead,
+		Request: struct {
+			OrderIds []string `json:"orderIds"`
+		}{}, Response: []sink.OrderQueryResp{}},
+	{Method: "POST", Path: "/orders/update_status", Tag: "order", Summary: "回写交易/退款/发货/结算状态", Auth: authJWT, Perm: PermOrderAdmin,
+		Request: orderStatusUpdate{}, Response: orderStatusUpdate{}},
+	{Method: "GET", Path: "/upstreams/guards", Tag: "order", Summary: "上游熔断/并发隔离状态", Auth: authJWT, Perm: PermOrderAdmin,
+		Response: []types.UpstreamGuardSnapshot{}},
+
+	// ----- 渠道 -----
+	{Method: "POST", Path: "/partner", Tag: "partner", Summary: "创建渠道", Auth: authJWT, Perm: PermPartnerAdmin, Request: types.PartnerDTO{}, Response: types.PartnerEntity{}},
+	{Method: "POST", Path: "/partner/list", Tag: "partner", Summary: "渠道列表", Auth: authJWT, Perm: PermPartnerAdmin,
+		Request: struct {
+			ParentSn string `json:"parentSn"`
+			Status   string `json:"status"`
+			Page     int64  `json:"page"`
+			Size     int64  `json:"size"`
+		}{}, Response: listOf{types.PartnerEntity{}}},
+	{Method: "GET", Path: "/partner/one/:userSn", Tag: "partner", Summary: "查询渠道", Auth: authJWT, Perm: PermPartnerAdmin, Response: types.PartnerEntity{}},
+	{Method: "PUT", Path: "/partner/one/:userSn", Tag: "partner", Summary: "更新渠道", Auth: authJWT, Perm: PermPartnerAdmin, Request: types.PartnerDTO{}, Response: types.PartnerEntity{}},
+	{Method: "POST", Path: "/partner/one/:userSn/status", Tag: "partner", Summary: "启用/停用渠道", Auth: authJWT, Perm: PermPartnerAdmin,
+		Request: struct {
+			Status string `json:"status"`
+		}{}, Response: ""},
+	{Method: "DELETE", Path: "/partner/one/:userSn", Tag: "partner", Summary: "删除渠道(有下级或订单时拒绝)", Auth: authJWT, Perm: PermPartnerAdmin, Response: ""},
+	{Method: "POST", Path: "/partner/keys", Tag: "partner", Summary: "签发密钥(secret 只返回这一次)", Auth: authJWT, Perm: PermPartnerAdmin,
+		Request: struct {
+			UserSn string `json:"userSn"`
+			Remark string `json:"remark"`
+		}{}, Response: struct {
+			Key    types.PartnerKeyEntity `json:"key"`
+			Secret string                 `json:"secret"`
+		}{}},
+	{Method: "POST", Path: "/partner/keys/list", Tag: "partner", Summary: "密钥列表", Auth: authJWT, Perm: PermPartnerAdmin,
+		Request: struct {
+			UserSn string `json:"userSn"`
+			Page   int64  `json:"page"`
+			Size   int64  `json:"size"`
+		}{}, Response: listOf{types.PartnerKeyEntity{}}},
+	{Method: "POST", Path: "/partner/keys/:appKey/status", Tag: "partner", Summary: "启用/停用密钥", Auth: authJWT, Perm: PermPartnerAdmin,
+		Request: struct {
+			Status int64 `json:"status"`
+		}{}, Response: ""},
+}
+
+type categoryItem struct {
+	Cate string `json:"cate"`
+	Id   int64  `json:"id"`
+}
+
+type batchResult struct {
+	BatchId string `json:"batchId"`
+	Total   int    `json:"total"`
+	Message string `json:"message,omitempty"`
+}
+
+type batchUndoResult struct {
+	BatchId   string   `json:"batchId"`
+	Total     int      `json:"total"`
+	Conflicts []string `json:"conflicts"`
+}
+
+type orderStatusUpdate struct {
+	OrderId           string `json:"orderId,omitempty"`
+	DownstreamOrderId string `json:"downstreamOrderId,omitempty"`
+	TradeStatus       string `json:"tradeStatus,omitempty"`
+	RefundStatus      string `json:"refundStatus,omitempty"`
+	DeliveryStatus    int64  `json:"deliveryStatus,omitempty"`
+	SettlementStatus  int64  `json:"settlementStatus,omitempty"`
+}
+
+var searchResponse = struct {
+	Total    int64 `json:"total"`
+	DataList struct {
+		Title string                `json:"title"`
+		Items []service.GroupedItem `json:"items"`
+	} `json:"dataList"`
+}{}
+
+var (
+	specOnce sync.Once
+	specDoc  map[string]any
+)
+
+// OpenAPISpec 由 routeDocs 生成 OpenAPI 3 文档, 只生成一次
+func OpenAPISpec(prefix string) map[string]any {
+	specOnce.Do(func() { specDoc = buildOpenAPISpec(prefix) })
+	return specDoc
+}
+
+func buildOpenAPISpec(prefix string) map[string]any {
+	schemas := pkg.NewOpenAPISchemas()
+	envelope := schemas.Of(BaseResponse{})
+	paths := map[string]any{}
+
+	for _, d := range routeDocs {
+		// 1) 路径参数 :publicCode => {publicCode}
+		var params []any
+		segs := strings.Split(d.Path, "/")
+		for i, seg := range segs {
+			if strings.HasPrefix(seg, ":") {
+				name := seg[1:]
+				segs[i] = "{" + name + "}"
+				params = append(params, map[string]any{
+					"name": name, "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+				})
+			}
+		}
+		for _, q := range d.Query {
+			params = append(params, map[string]any{"name": q, "in": "query", "schema": map[string]any{"type": "string"}})
+		}
+
+		// 2) 成功响应: BaseResponse.data 为具体类型
+		okSchema := envelope
+		if d.Response != nil {
+			okSchema = map[string]any{"allOf": []any{envelope, map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"data": dataSchema(schemas, d.Response)},
+			}}}
+		}
+		op := map[string]any{
+			"tags":        []string{d.Tag},
+			"summary":     d.Summary,
+			"operationId": strings.ToLower(d.Method) + strings.NewReplacer("/", "_", ":", "").Replace(d.Path),
+			"responses": map[string]any{
+				"200": jsonContent("成功", okSchema),
+				"default": jsonContent("错误: HTTP 状态码与 body.code 一致(LEGACY_ERROR_STATUS=true 时 HTTP 200), "+
+					"body.errorCode 为稳定错误码, 如 VALIDATION_FAILED / NOT_FOUND / CONFLICT / RATE_LIMITED", envelope),
+			},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if d.Request != nil {
+			op["requestBody"] = map[string]any{"content": map[string]any{
+				"application/json": map[string]any{"schema": schemas.Of(d.Request)},
+			}}
+		}
+		switch d.Auth {
+		case authJWT:
+			op["security"] = []any{map[string]any{"bearerAuth": []string{}}}
+		case authSignature:
+			op["security"] = []any{map[string]any{
+				"appKey": []string{}, "timestamp": []string{}, "nonce": []string{}, "signature": []string{},
+			}}
+		}
+		if d.Perm != "" {
+			op["description"] = "需要权限: " + string(d.Perm)
+		}
+
+		path := strings.Join(segs, "/")
+		item, _ := paths[path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(d.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "vip_gift API",
+			"version": "1.0",
+		},
+		"servers": []any{map[string]any{"url": prefix}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas.Components(),
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"appKey":     headerKey(types.HeaderAppKey, "渠道 appKey"),
+				"timestamp":  headerKey(types.HeaderTimestamp, "Unix 秒"),
+				"nonce":      headerKey(types.HeaderNonce, "每次请求唯一"),
+				"signature": headerKey(types.HeaderSignature,
+					"hex(HMAC-SHA256(secret, METHOD\\npath\\ntimestamp\\nnonce\\nhex(sha256(body))))"),
+			},
+		},
+	}
+}
+
+func dataSchema(schemas *pkg.OpenAPISchemas, v any) map[string]any {
+	if l, ok := v.(listOf); ok {
+		return map[string]any{"type": "object", "properties": map[string]any{
+			"dataList": map[string]any{"type": "array", "items": schemas.Of(l.elem)},
+			"total":    map[string]any{"type": "integer", "format": "int64"},
+		}}
+	}
+	return schemas.Of(v)
+}
+
+func jsonContent(desc string, schema map[string]any) map[string]any {
+	return map[string]any{
+		"description": desc,
+		"content":     map[string]any{"application/json": map[string]any{"schema": schema}},
+	}
+}
+
+func headerKey(name, desc string) map[string]any {
+	return map[string]any{"type": "apiKey", "in": "header", "name": name, "description": desc}
+}
+
+// UndocumentedRoutes prefix 下已注册但 routeDocs 中没有的路由(METHOD path);
+// 文档页本身和 Fiber 自动生成的 HEAD 不计
+func UndocumentedRoutes(app *fiber.App, prefix string) []string {
+	documented := make(map[string]bool, len(routeDocs))
+	for _, d := range routeDocs {
+		documented[d.Method+" "+d.Path] = true
+	}
+	var missing []string
+	for _, r := range app.GetRoutes(true) {
+		if r.Method == fiber.MethodHead || !strings.HasPrefix(r.Path, prefix+"/") {
+			continue
+		}
+		rel := strings.TrimPrefix(r.Path, prefix)
+		if strings.HasPrefix(rel, "/docs") {
+			continue
+		}
+		if !documented[r.Method+" "+rel] {
+			missing = append(missing, r.Method+" "+rel)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// RegisterDocsRoutes 文档无需鉴权, 必须先于 PubHandler.RegisterRoutes 注册
+//
+//	GET /docs              Redoc
+//	GET /docs/swagger      Swagger UI
+//	GET /docs/openapi.json OpenAPI 3 文档
+func RegisterDocsRoutes(r fiber.Router, prefix string) {
+	specURL := prefix + "/docs/openapi.json"
+	r.Get("/docs/openapi.json", func(c *fiber.Ctx) error {
+		return c.JSON(OpenAPISpec(prefix))
+	})
+	r.Get("/docs", func(c *fiber.Ctx) error {
+		c.Type("html")
+		return c.SendString(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>vip_gift API</title></head>
+<body><redoc spec-url="` + specURL + `"></redoc>
+<script src="https://cdn.redoc.ly/redoc/latest/bundles/redoc.standalone.js"></script>
+</body></html>`)
+	})
+	r.Get("/docs/swagger", func(c *fiber.Ctx) error {
+		c.Type("html")
+		return c.SendString(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>vip_gift API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css"></head>
+<body><div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "` + specURL + `", dom_id: "#swagger-ui"});</script>
+</body></html>`)
+	})
+}
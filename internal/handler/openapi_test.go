@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const testApiPrefix = "/api/product/gift"
+
+// 与 cmd/main.go 注册同一组路由; 这里只检查路由表, 不会调用 service
+func TestAllRoutesDocumented(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "test-secret") // PubHandler.RegisterRoutes 挂 JWTMiddleware 时需要
+	app := fiber.New()
+	api := app.Group(testApiPrefix)
+
+	RegisterDocsRoutes(api, testApiPrefix)
+	orders := &OrderHandler{}
+	orders.RegisterPartnerRoutes(api)
+	(&PubHandler{}).RegisterRoutes(api)
+	(&GncHandler{}).RegisterRoutes(api)
+	orders.RegisterRoutes(api)
+	(&PartnerHandler{}).RegisterRoutes(api)
+
+	if missing := UndocumentedRoutes(app, testApiPrefix); len(missing) > 0 {
+		t.Fatalf("routes without OpenAPI docs (add them to routeDocs): %v", missing)
+	}
+}
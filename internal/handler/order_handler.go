@@ -1,87 +1,126 @@
 package handler
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 
+	"10000hk.com/vip_gift/internal/metrics"
 	"10000hk.com/vip_gift/internal/proxy"
 	"10000hk.com/vip_gift/internal/service"
 	"10000hk.com/vip_gift/internal/sink"
 	"10000hk.com/vip_gift/internal/types"
+	"10000hk.com/vip_gift/pkg"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/samber/lo"
 )
 
 type OrderHandler struct {
-	svc service.OrderService
-	pub service.PubService
+	svc      service.OrderService
+	pub      service.PubService
+	partners service.PartnerService
 }
 
 // NewOrderHandler 构造函数
-func NewOrderHandler(svc service.OrderService, pub service.PubService) *OrderHandler {
-	return &OrderHandler{svc: svc, pub: pub}
+func NewOrderHandler(svc service.OrderService, pub service.PubService, partners service.PartnerService) *OrderHandler {
+	return &OrderHandler{svc: svc, pub: pub, partners: partners}
 }
 
 // RegisterRoutes 注册路由
 func (h *OrderHandler) RegisterRoutes(r fiber.Router) {
 
-	// 1) 创建订单: POST /orders
-	r.Post("/orders/create", h.CreateOrder)
+	read := RequirePermission(PermOrderRead)
+
+	// 1) 创建订单: POST /orders/create 走签名鉴权, 见 RegisterPartnerRoutes
 
 	// 2) 获取单条订单 (改为 POST /orders/one)
-	r.Post("/orders/one", h.GetOneOrder)
+	r.Post("/orders/one", read, h.GetOneOrder)
 
 	// 3) 分页查看订单列表: POST /orders/list
-	r.Post("/orders/list", h.ListOrders)
+	r.Post("/orders/list", read, h.ListOrders)
+
+	r.Post("/orders/query", read, h.QueryOrders)
 
-	r.Post("/orders/query", h.QueryOrders)
+	r.Post("/orders/update_status", RequirePermission(PermOrderAdmin), h.UpdateOrderStatus)
+
+	// 上游熔断 / 并发隔离状态
+	r.Get("/upstreams/guards", RequirePermission(PermOrderAdmin), h.UpstreamGuards)
+}
 
-	r.Post("/orders/update_status", h.UpdateOrderStatus)
+// RegisterPartnerRoutes 下游签名鉴权的开放接口;
+// 必须先于 PubHandler.RegisterRoutes 注册, 否则会先经过同一分组上的 JWTMiddleware;
+// middlewares 依次为验签、限流
+func (h *OrderHandler) RegisterPartnerRoutes(r fiber.Router, middlewares ...fiber.Handler) {
+	handlers := append(middlewares, h.CreateOrder)
+	r.Post("/orders/create", handlers...)
 }
 
 // -------------------------------------------------------------------
 // 1) CreateOrder
 // Body: { "downstreamOrderId":"XXX", "dataJSON":"XXX" }
+// Header: X-App-Key / X-Timestamp / X-Nonce / X-Signature
 // -------------------------------------------------------------------
 func (h *OrderHandler) CreateOrder(c *fiber.Ctx) error {
 	var req sink.OrderCreateReq
 	if err := c.BodyParser(&req); err != nil {
 		return ErrorJSON(c, http.StatusBadRequest, err.Error())
 	}
-	// 从 http headers 中获取用户信息
-	userSn := c.Get("partnerId")
-	if userSn == "" {
-		return ErrorJSON(c, http.StatusUnauthorized, "partnerId is required")
-	}
-	parentSn := c.Get("parentSn")
-	if parentSn == "" {
-		return ErrorJSON(c, http.StatusUnauthorized, "parentSn is required")
-	}
-	req.PartnerId = userSn
-	req.ParentSn = parentSn
+	ctx := pkg.WithLogAttrs(c.UserContext(), pkg.LogKeyDownstreamOrderID, req.DownstreamOrderId)
 	// 0)
 	var api types.OrderApi
+	var channel string
 	switch {
 	case strings.Contains(req.DownstreamOrderId, "VV"):
 		api = proxy.NewGiftApi(map[string]string{}, h.pub, h.svc)
+		channel = types.CatalogGift
 	case strings.Contains(req.DownstreamOrderId, "VF"):
-		api = proxy.NewChargeApi(map[string]string{})
+		api = proxy.NewChargeApi(map[string]string{}, h.pub)
+		channel = types.CatalogCharge
 	default:
 		return ErrorJSON(c, http.StatusBadRequest, "downstreamOrderId is invalid")
 	}
 
+	// 0.1) 渠道取自验签通过的密钥, 上级取自渠道层级, 不再信任 partnerId/parentSn 请求头
+	userSn, _ := c.Locals("partnerSn").(string)
+	if userSn == "" {
+		return Unauthorized(c, "partner is not authenticated")
+	}
+	partner, parent, err := h.partners.AuthorizeOrder(userSn, channel)
+	if err != nil {
+		// 渠道停用/未开通该业务 => 403
+		return err
+	}
+	req.PartnerId = partner.UserSn
+	req.ParentSn = partner.ParentSn
+
+	// 0.2) 上游熔断中直接 503, 不占配额与库存
+	if err := proxy.Guard(channel).Allow(); err != nil {
+		return err
+	}
+
 	// 1) 把 req 转成内部的 OrderDTO
 
-	dto, _ := api.ToOrderDto(context.Background(), req)
+	dto, err := api.ToOrderDto(ctx, req)
+	if err != nil {
+		return err
+	}
+	dto.CommissionSelf, dto.CommissionParent = partner.SplitCommission(dto.CommissionMF, parent)
+
+	// 1.1) 预占当日订单数/金额配额, 下单失败时退回; 配额耗尽由 ErrorHandler 返回 429 + Retry-After
+	quotaDay, err := h.partners.ReserveQuota(partner, dto.SalePrice)
+	if err != nil {
+		return err
+	}
 
 	// 2) 调用 service.CreateOrder
-	out, err := h.svc.CreateOrder(context.Background(), &dto)
+	out, err := h.svc.CreateOrder(ctx, &dto)
+	metrics.OrdersCreated.WithLabelValues(channel, metrics.Result(err)).Inc()
 	if err != nil {
-		return ErrorJSON(c, http.StatusInternalServerError, err.Error())
+		h.partners.ReleaseQuota(c.UserContext(), partner.UserSn, quotaDay, dto.SalePrice)
+		return err
 	}
 
 	// 3) 组装响应
@@ -110,9 +149,13 @@ func (h *OrderHandler) GetOneOrder(c *fiber.Ctx) error {
 		return ErrorJSON(c, 400, "orderId is required")
 	}
 
-	out, err := h.svc.GetOrder(context.Background(), req.OrderId)
+	out, err := h.svc.GetOrder(c.UserContext(), req.OrderId)
 	if err != nil {
-		return ErrorJSON(c, 404, err.Error())
+		return err
+	}
+	// partner 读不到其它渠道的订单, 与不存在一样返回 404
+	if userSn, scoped := orderScope(c); scoped && !orderVisible(userSn, out.UserSn, out.ParentSn) {
+		return types.NewNotFoundError("ORDER_NOT_FOUND", "order not found", nil)
 	}
 	clientDto, _ := out.ToClientDTO()
 	return SuccessJSON(c, clientDto)
@@ -140,9 +183,14 @@ func (h *OrderHandler) ListOrders(c *fiber.Ctx) error {
 		req.Size = 10
 	}
 
-	items, total, err := h.svc.ListOrder(context.Background(), req.Page, req.Size, req.OrderIds, req.DownstreamOrderIds)
+	// partner 只列出自己及直属下级渠道的订单
+	userSn, scoped := orderScope(c)
+	if scoped && userSn == "" {
+		return Forbidden(c, "Forbidden: missing userSn")
+	}
+	items, total, err := h.svc.ListOrder(c.UserContext(), req.Page, req.Size, req.OrderIds, req.DownstreamOrderIds, userSn)
 	if err != nil {
-		return ErrorJSON(c, 500, err.Error())
+		return err
 	}
 	// to clientDto
 	orderItems := lo.Map(items, func(item types.OrderDTO, idx int) *types.ClientOrderDTO {
@@ -169,6 +217,13 @@ func (h *OrderHandler) QueryOrders(c *fiber.Ctx) error {
 	if len(req.OrderIds) == 0 {
 		return ErrorJSON(c, 400, "orderIds is required")
 	}
+	// partner 只能查自己及直属下级渠道的订单, 其它订单号忽略
+	if userSn, scoped := orderScope(c); scoped {
+		req.OrderIds = lo.Filter(req.OrderIds, func(oid string, _ int) bool {
+			o, err := h.svc.GetOrderByDownstreamOrderId(c.UserContext(), oid)
+			return err == nil && orderVisible(userSn, o.UserSn, o.ParentSn)
+		})
+	}
 	// 将orderIds按照 VV或者 VC前缀分组
 	// 1) 按前缀 VV 和 VF 分组
 	var vvIds []string
@@ -185,25 +240,26 @@ func (h *OrderHandler) QueryOrders(c *fiber.Ctx) error {
 	// 2) 对两组分别发起查询 (示例) 并合并结果
 	var orderResults []sink.OrderQueryResp
 
-	giftApi := proxy.NewGiftApi(map[string]string{
+	// 只读: 查单接口不会继续故障转移下发新的上游订单
+	giftApi := proxy.NewGiftQueryApi(map[string]string{
 		"QueryOrder": "https://gift.10000hk.com/api/fulu/order/query",
 	}, h.pub, h.svc)
 	chargeApi := proxy.NewChargeApi(map[string]string{
 		"QueryOrder": "https://gift.10000hk.com/api/charge/order/query",
-	})
+	}, h.pub)
 
 	// 2.1) 查询 VV 前缀订单
 	if len(vvIds) > 0 {
-		respVV, err := giftApi.DoQueryOrder(context.Background(), vvIds)
+		respVV, err := giftApi.DoQueryOrder(c.UserContext(), vvIds)
 		if err != nil {
 			// 如果其中一组查询失败，是否要直接返回？还是只返回成功的？
 			// 根据实际需求决定，这里先示例继续处理
 			// return ErrorJSON(c, 500, err.Error())
-			fmt.Println("VV group query error:", err)
+			slog.WarnContext(c.UserContext(), "gift order query failed", "error", err)
 		}
 		if len(respVV) == 0 {
 			for _, vvId := range vvIds {
-				o, _ := h.svc.GetOrderByDownstreamOrderId(context.Background(), vvId)
+				o, _ := h.svc.GetOrderByDownstreamOrderId(c.UserContext(), vvId)
 				if o != nil {
 					respVV = append(respVV, sink.OrderQueryResp{
 						DownstreamOrderId: o.GetDownstreamOrderId(),
@@ -218,7 +274,7 @@ func (h *OrderHandler) QueryOrders(c *fiber.Ctx) error {
 		// 这里刷新一次本地数据库的订单状态
 		for _, o := range respVV {
 			// 从 respVV 中取出订单状态，更新到本地数据库
-			order, err := h.svc.GetOrderByDownstreamOrderId(context.Background(), o.DownstreamOrderId)
+			order, err := h.svc.GetOrderByDownstreamOrderId(c.UserContext(), o.DownstreamOrderId)
 			if err != nil {
 				// 没查到就跳过
 				continue
@@ -230,20 +286,20 @@ func (h *OrderHandler) QueryOrders(c *fiber.Ctx) error {
 				Status:            statusNew,
 				Remark:            statusNew.Remark(),
 			}
-			_ = h.svc.StoreToDB(context.Background(), dto)
+			_ = h.svc.StoreToDB(c.UserContext(), dto)
 		}
 		orderResults = append(orderResults, respVV...)
 	}
 
 	// 2.2) 查询 VF 前缀订单
 	if len(vcIds) > 0 {
-		respVC, err := chargeApi.DoQueryOrder(context.Background(), vcIds)
+		respVC, err := chargeApi.DoQueryOrder(c.UserContext(), vcIds)
 		if err != nil {
-			fmt.Println("VF group query error:", err)
+			slog.WarnContext(c.UserContext(), "charge order query failed", "error", err)
 		}
 		if len(respVC) == 0 {
 			for _, vcId := range vcIds {
-				o, _ := h.svc.GetOrderByDownstreamOrderId(context.Background(), vcId)
+				o, _ := h.svc.GetOrderByDownstreamOrderId(c.UserContext(), vcId)
 				if o != nil {
 					respVC = append(respVC, sink.OrderQueryResp{
 						DownstreamOrderId: o.GetDownstreamOrderId(),
@@ -258,7 +314,7 @@ func (h *OrderHandler) QueryOrders(c *fiber.Ctx) error {
 		// 这里刷新一次本地数据库的订单状态
 		for _, o := range respVC {
 			// 从 respVV 中取出订单状态，更新到本地数据库
-			order, err := h.svc.GetOrderByDownstreamOrderId(context.Background(), o.DownstreamOrderId)
+			order, err := h.svc.GetOrderByDownstreamOrderId(c.UserContext(), o.DownstreamOrderId)
 			if err != nil {
 				// 没查到就跳过
 				continue
@@ -270,7 +326,7 @@ func (h *OrderHandler) QueryOrders(c *fiber.Ctx) error {
 				Status:            statusNew,
 				Remark:            statusNew.Remark(),
 			}
-			_ = h.svc.StoreToDB(context.Background(), dto)
+			_ = h.svc.StoreToDB(c.UserContext(), dto)
 		}
 		orderResults = append(orderResults, respVC...)
 	}
@@ -279,7 +335,7 @@ func (h *OrderHandler) QueryOrders(c *fiber.Ctx) error {
 	// 现在 orderResults 中包含 VV 和 VF 两组的查询结果
 	for i := range orderResults {
 		orderResult := &orderResults[i]
-		order, err := h.svc.GetOrderByDownstreamOrderId(context.Background(), orderResult.DownstreamOrderId)
+		order, err := h.svc.GetOrderByDownstreamOrderId(c.UserContext(), orderResult.DownstreamOrderId)
 		if err != nil {
 			// 没查到就跳过
 			continue
@@ -299,12 +355,7 @@ func (h *OrderHandler) QueryOrders(c *fiber.Ctx) error {
 
 // UpdateOrderStatus 接口：发送 `order-update` 消息到 Kafka
 func (h *OrderHandler) UpdateOrderStatus(c *fiber.Ctx) error {
-	// 验证 userSn="CRM"
-	userSn := c.Locals("userSn")
-	if userSn != "CRM" {
-		return ErrorJSON(c, http.StatusUnauthorized, "Unauthorized: userSn must be 'CRM'")
-	}
-
+	// 权限由路由上的 RequirePermission(PermOrderAdmin) 校验
 	// 解析请求参数
 	var req struct {
 		OrderId           string `json:"orderId,omitempty"`
@@ -321,7 +372,7 @@ func (h *OrderHandler) UpdateOrderStatus(c *fiber.Ctx) error {
 
 	// 如果没有 orderId，就尝试用 downstreamOrderId 查找
 	if req.OrderId == "" && req.DownstreamOrderId != "" {
-		order, err := h.svc.GetOrderByDownstreamOrderId(context.Background(), req.DownstreamOrderId)
+		order, err := h.svc.GetOrderByDownstreamOrderId(c.UserContext(), req.DownstreamOrderId)
 		if err != nil {
 			return ErrorJSON(c, http.StatusNotFound, fmt.Sprintf("Order not found for downstreamOrderId=%s", req.DownstreamOrderId))
 		}
@@ -331,12 +382,22 @@ func (h *OrderHandler) UpdateOrderStatus(c *fiber.Ctx) error {
 	if req.OrderId == "" {
 		return ErrorJSON(c, http.StatusBadRequest, "Either orderId or downstreamOrderId is required")
 	}
+	ctx := pkg.WithLogAttrs(c.UserContext(), pkg.LogKeyOrderID, req.OrderId, pkg.LogKeyDownstreamOrderID, req.DownstreamOrderId)
 
-	// 发送 `order-update` 消息到 Kafka
+	// 发送 `order-update` 消息到 Kafka, 消息头带上关联 ID
 	message, _ := json.Marshal(req)
-	if err := h.svc.PublishOrderUpdate(context.Background(), req.DownstreamOrderId, message); err != nil {
+	if err := h.svc.PublishOrderUpdate(ctx, req.DownstreamOrderId, message); err != nil {
 		return ErrorJSON(c, http.StatusInternalServerError, "Failed to publish order update")
 	}
 
 	return SuccessJSON(c, req)
 }
+
+// -------------------------------------------------------------------
+// UpstreamGuards
+// GET /upstreams/guards
+// 各上游 provider 的熔断状态、在途请求数与累计拒绝数
+// -------------------------------------------------------------------
+func (h *OrderHandler) UpstreamGuards(c *fiber.Ctx) error {
+	return SuccessJSON(c, proxy.UpstreamGuardSnapshots())
+}
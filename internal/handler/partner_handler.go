@@ -0,0 +1,161 @@
+// internal/handler/partner_handler.go
+package handler
+
+import (
+	"10000hk.com/vip_gift/internal/service"
+	"10000hk.com/vip_gift/internal/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+type PartnerHandler struct {
+	svc service.PartnerService
+}
+
+func NewPartnerHandler(svc service.PartnerService) *PartnerHandler {
+	return &PartnerHandler{svc: svc}
+}
+
+// RegisterRoutes 渠道账户与密钥管理, 仅 admin
+func (h *PartnerHandler) RegisterRoutes(r fiber.Router) {
+	admin := RequirePermission(PermPartnerAdmin)
+	r.Post("/partner", admin, h.CreatePartner)
+	r.Post("/partner/list", admin, h.ListPartners)
+	r.Get("/partner/one/:userSn", admin, h.GetPartner)
+	r.Put("/partner/one/:userSn", admin, h.UpdatePartner)
+	r.Post("/partner/one/:userSn/status", admin, h.SetPartnerStatus)
+	r.Delete("/partner/one/:userSn", admin, h.DeletePartner)
+
+	r.Post("/partner/keys", admin, h.IssueKey)
+	r.Post("/partner/keys/list", admin, h.ListKeys)
+	r.Post("/partner/keys/:appKey/status", admin, h.SetKeyStatus)
+}
+
+// CreatePartner Body: types.PartnerDTO
+func (h *PartnerHandler) CreatePartner(c *fiber.Ctx) error {
+	var dto types.PartnerDTO
+	if err := c.BodyParser(&dto); err != nil {
+		return ErrorJSON(c, 400, err.Error())
+	}
+	created, err := h.svc.CreatePartner(&dto)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, created)
+}
+
+// ListPartners Body: { "parentSn":"", "status":"", "page":1, "size":20 }
+func (h *PartnerHandler) ListPartners(c *fiber.Ctx) error {
+	var req struct {
+		ParentSn string `json:"parentSn"`
+		Status   string `json:"status"`
+		Page     int64  `json:"page"`
+		Size     int64  `json:"size"`
+	}
+	_ = c.BodyParser(&req)
+	dataList, total, err := h.svc.ListPartners(req.ParentSn, req.Status, req.Page, req.Size)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, fiber.Map{
+		"dataList": dataList,
+		"total":    total,
+	})
+}
+
+func (h *PartnerHandler) GetPartner(c *fiber.Ctx) error {
+	partner, err := h.svc.GetPartner(c.Params("userSn"))
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, partner)
+}
+
+// UpdatePartner Body: types.PartnerDTO, userSn 以路径为准
+func (h *PartnerHandler) UpdatePartner(c *fiber.Ctx) error {
+	var dto types.PartnerDTO
+	if err := c.BodyParser(&dto); err != nil {
+		return ErrorJSON(c, 400, err.Error())
+	}
+	updated, err := h.svc.UpdatePartner(c.Params("userSn"), &dto)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, updated)
+}
+
+// SetPartnerStatus Body: { "status": "active" | "suspended" }
+func (h *PartnerHandler) SetPartnerStatus(c *fiber.Ctx) error {
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorJSON(c, 400, err.Error())
+	}
+	if err := h.svc.SetPartnerStatus(c.Params("userSn"), req.Status); err != nil {
+		return err
+	}
+	return SuccessJSON(c, req.Status)
+}
+
+// DeletePartner 有下级或订单时返回 409, 请改为停用
+func (h *PartnerHandler) DeletePartner(c *fiber.Ctx) error {
+	if err := h.svc.DeletePartner(c.Params("userSn")); err != nil {
+		return err
+	}
+	return SuccessJSON(c, "Deleted")
+}
+
+// IssueKey 签发密钥, secret 只在本次响应中返回
+// Body: { "userSn":"...", "remark":"..." }
+func (h *PartnerHandler) IssueKey(c *fiber.Ctx) error {
+	var req struct {
+		UserSn string `json:"userSn"`
+		Remark string `json:"remark"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorJSON(c, 400, err.Error())
+	}
+	key, secret, err := h.svc.IssueKey(req.UserSn, req.Remark)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, fiber.Map{
+		"key":    key,
+		"secret": secret,
+	})
+}
+
+// ListKeys Body: { "userSn":"", "page":1, "size":20 }
+func (h *PartnerHandler) ListKeys(c *fiber.Ctx) error {
+	var req struct {
+		UserSn string `json:"userSn"`
+		Page   int64  `json:"page"`
+		Size   int64  `json:"size"`
+	}
+	_ = c.BodyParser(&req)
+	dataList, total, err := h.svc.ListKeys(req.UserSn, req.Page, req.Size)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, fiber.Map{
+		"dataList": dataList,
+		"total":    total,
+	})
+}
+
+// SetKeyStatus 启用/停用, Body: { "status": 0 | 1 }
+func (h *PartnerHandler) SetKeyStatus(c *fiber.Ctx) error {
+	var req struct {
+		Status *int64 `json:"status"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.Status == nil {
+		return ErrorJSON(c, 400, "status is required")
+	}
+	if err := h.svc.SetKeyStatus(c.Params("appKey"), *req.Status); err != nil {
+		return err
+	}
+	if *req.Status == types.PartnerKeyDisabled {
+		return SuccessJSON(c, "Disabled")
+	}
+	return SuccessJSON(c, "Enabled")
+}
@@ -0,0 +1,30 @@
+// internal/handler/partner_rate_limit.go
+package handler
+
+import (
+	"errors"
+	"log/slog"
+
+	"10000hk.com/vip_gift/internal/service"
+	"10000hk.com/vip_gift/internal/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// PartnerRateLimit 按验签得到的渠道限流, 需挂在 PartnerSignature 之后;
+// 计数存储出错时放行, 不因限流组件故障影响下单
+func PartnerRateLimit(svc service.PartnerService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userSn, _ := c.Locals("partnerSn").(string)
+		if userSn == "" {
+			return Unauthorized(c, "partner is not authenticated")
+		}
+		if err := svc.AllowRequest(userSn); err != nil {
+			var ra *types.RetryAfterError
+			if errors.As(err, &ra) {
+				return TooManyRequests(c, ra.RetryAfter, ra.Error())
+			}
+			slog.WarnContext(c.UserContext(), "partner rate limit check failed, request allowed", "userSn", userSn, "error", err)
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,33 @@
+// internal/handler/partner_signature.go
+package handler
+
+import (
+	"10000hk.com/vip_gift/internal/service"
+	"10000hk.com/vip_gift/internal/types"
+	"10000hk.com/vip_gift/pkg"
+	"github.com/gofiber/fiber/v2"
+)
+
+// PartnerSignature 校验下游签名请求(见 types.SignPartnerRequest),
+// 通过后把渠道身份放到 c.Locals("partnerSn"), 上级由 PartnerService.AuthorizeOrder 从层级中取
+func PartnerSignature(svc service.PartnerService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key, err := svc.VerifyRequest(types.SignedRequest{
+			AppKey:    c.Get(types.HeaderAppKey),
+			Timestamp: c.Get(types.HeaderTimestamp),
+			Nonce:     c.Get(types.HeaderNonce),
+			Signature: c.Get(types.HeaderSignature),
+			Method:    c.Method(),
+			Path:      c.OriginalURL(),
+			Body:      c.Body(),
+		})
+		if err != nil {
+			// 验签失败由 ErrorHandler 映射为 401 + INVALID_SIGNATURE 等错误码
+			return err
+		}
+		c.Locals("appKey", key.AppKey)
+		c.Locals("partnerSn", key.UserSn)
+		c.SetUserContext(pkg.WithLogAttrs(c.UserContext(), "partnerSn", key.UserSn))
+		return c.Next()
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"10000hk.com/vip_gift/internal/service"
@@ -48,18 +50,54 @@ func (h *PubHandler) RegisterRoutes(r fiber.Router) {
 		log.Fatal("jwtSecretKey environment variable is not set")
 	}
 	r.Use(JWTMiddleware(jwtSecretKey))
+	read := RequirePermission(PermPubRead)
+	write := RequirePermission(PermPubWrite)
+
 	// Existing endpoints
-	r.Post("/public", h.CreatePub)
-	r.Get("/public/one/:publicCode", h.GetPub)
-	r.Put("/public/one/:publicCode", h.UpdatePub)
-	r.Delete("/public/one/:publicCode", h.DeletePub)
-	r.Post("/public/list", h.ListPub)
+	r.Post("/public", write, h.CreatePub)
+	r.Get("/public/one/:publicCode", read, h.GetPub)
+	r.Put("/public/one/:publicCode", write, h.UpdatePub)
+	r.Patch("/public/one/:publicCode", write, h.PatchPub)
+	r.Delete("/public/one/:publicCode", write, h.DeletePub)
+	r.Post("/public/list", read, h.ListPub)
 
 	// NEW endpoints for search
-	r.Post("/public/search", h.SearchPub)
-	r.Post("/public/categories", h.GetPubCategories)
+	r.Post("/public/search", read, h.SearchPub)
+	r.Post("/public/categories", read, h.GetPubCategories)
+
+	r.Post("/public/batch_category", write, h.BatchAddCategory)
+	r.Post("/public/batch_category/undo", write, h.UndoBatchCategory)
+
+	// 价格历史 & 定时调价
+	r.Get("/public/one/:publicCode/price_history", read, h.ListPriceHistory)
+	r.Get("/public/one/:publicCode/price_schedule", read, h.ListPriceSchedules)
+	r.Post("/public/one/:publicCode/price_schedule", write, h.SchedulePriceChange)
+	r.Delete("/public/price_schedule/:id", write, h.CancelPriceSchedule)
+
+	// 上架时间窗
+	r.Get("/public/one/:publicCode/availability", read, h.ListAvailabilityWindows)
+	r.Post("/public/one/:publicCode/availability", write, h.AddAvailabilityWindow)
+	r.Delete("/public/availability/:id", write, h.DeleteAvailabilityWindow)
+
+	// 组合快照漂移
+	r.Post("/public/drift", read, h.DriftReport)
+	r.Post("/public/one/:publicCode/snapshot/refresh", write, h.RefreshSnapshots)
+
+	// base 变更后待复核
+	r.Post("/public/review/list", read, h.ListNeedsReview)
+	r.Post("/public/one/:publicCode/review/clear", write, h.ClearReview)
 
-	r.Post("/public/batch_category", h.BatchAddCategory)
+	// 毛利报表
+	r.Post("/public/margin/report", read, h.MarginReport)
+
+	// 批量导入导出
+	r.Post("/public/import", write, h.ImportPubs)
+	r.Get("/public/export", read, h.ExportPubs)
+
+	// 回收站
+	r.Post("/public/trash/list", read, h.ListTrash)
+	r.Post("/public/trash/:publicCode/restore", write, h.RestorePub)
+	r.Delete("/public/trash/:publicCode", RequirePermission(PermPubPurge), h.PurgePub)
 
 }
 
@@ -73,7 +111,7 @@ func (h *PubHandler) CreatePub(c *fiber.Ctx) error {
 	}
 	created, err := h.svc.Create(&dto)
 	if err != nil {
-		return ErrorJSON(c, 500, err.Error())
+		return err
 	}
 	return SuccessJSON(c, created)
 }
@@ -85,7 +123,7 @@ func (h *PubHandler) GetPub(c *fiber.Ctx) error {
 	publicCode := c.Params("publicCode")
 	got, err := h.svc.GetByPublicCode(publicCode)
 	if err != nil {
-		return ErrorJSON(c, 404, err.Error())
+		return err
 	}
 	return SuccessJSON(c, got)
 }
@@ -101,7 +139,24 @@ func (h *PubHandler) UpdatePub(c *fiber.Ctx) error {
 	}
 	updated, err := h.svc.UpdateByPublicCode(publicCode, &dto)
 	if err != nil {
-		return ErrorJSON(c, 404, err.Error())
+		return err
+	}
+	return SuccessJSON(c, updated)
+}
+
+// -------------------------------------------------------------------
+// Patch
+// -------------------------------------------------------------------
+// Body: merge-patch {"status":0,"salePrice":null} 或 {"updateMask":["status"],"status":0}
+// 也可用 ?updateMask=status,salePrice
+func (h *PubHandler) PatchPub(c *fiber.Ctx) error {
+	patch, err := types.ParsePatch(c.Body(), c.Query("updateMask"))
+	if err != nil {
+		return ErrorJSON(c, 400, err.Error())
+	}
+	updated, err := h.svc.PatchByPublicCode(c.Params("publicCode"), patch)
+	if err != nil {
+		return err
 	}
 	return SuccessJSON(c, updated)
 }
@@ -112,7 +167,7 @@ func (h *PubHandler) UpdatePub(c *fiber.Ctx) error {
 func (h *PubHandler) DeletePub(c *fiber.Ctx) error {
 	publicCode := c.Params("publicCode")
 	if err := h.svc.DeleteByPublicCode(publicCode); err != nil {
-		return ErrorJSON(c, 404, err.Error())
+		return err
 	}
 	return SuccessJSON(c, "Deleted")
 }
@@ -133,7 +188,7 @@ func (h *PubHandler) ListPub(c *fiber.Ctx) error {
 
 	dataList, total, err := h.svc.List(req.Page, req.Size)
 	if err != nil {
-		return ErrorJSON(c, 500, err.Error())
+		return err
 	}
 	respData := fiber.Map{
 		"dataList": dataList,
@@ -152,6 +207,12 @@ func (h *PubHandler) SearchChargePub(c *fiber.Ctx) error {
 	if req.Size <= 0 {
 		req.Size = 10000
 	}
+	// 已同步到本地的 charge 目录直接返回原始条目; 按分类查询或本地没有时仍走代理
+	if req.Cate == 0 {
+		if data, ok := h.localChargeCatalog(req); ok {
+			return SuccessJSON(c, data)
+		}
+	}
 	// 封装对 https://gift.10000hk.com/api/charge/product/list 的请求
 	// 将请求数据转换为 JSON 格式
 	payload, err := json.Marshal(req)
@@ -236,6 +297,33 @@ func (h *PubHandler) SearchChargePub(c *fiber.Ctx) error {
 	return SuccessJSON(c, apiResp.Data)
 
 }
+
+// localChargeCatalog 本地目录的 UpstreamRaw 就是 product/list 的原始条目, 返回结构与代理一致
+func (h *PubHandler) localChargeCatalog(req SearchRequest) (fiber.Map, bool) {
+	items, err := h.svc.LocalCatalog(types.CatalogCharge, req.ProductIds)
+	if err != nil || len(items) == 0 {
+		return nil, false
+	}
+	dataList := make([]json.RawMessage, 0, len(items))
+	for _, g := range items {
+		if json.Valid([]byte(g.UpstreamRaw)) {
+			dataList = append(dataList, json.RawMessage(g.UpstreamRaw))
+		}
+	}
+	total := len(dataList)
+	start := int((req.Page - 1) * req.Size)
+	if start > total {
+		start = total
+	}
+	end := start + int(req.Size)
+	if end > total {
+		end = total
+	}
+	return fiber.Map{
+		"dataList": dataList[start:end],
+		"total":    total,
+	}, true
+}
 func (h *PubHandler) SearchPub(c *fiber.Ctx) error {
 	var req SearchRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -251,7 +339,7 @@ func (h *PubHandler) SearchPub(c *fiber.Ctx) error {
 	keyword := cateMap[req.Cate]
 	results, total, err := h.svc.SearchByKeyword(keyword, req.Page, req.Size)
 	if err != nil {
-		return ErrorJSON(c, 500, err.Error())
+		return err
 	}
 
 	return SuccessJSON(c, fiber.Map{
@@ -269,7 +357,7 @@ func (h *PubHandler) GetPubCategories(c *fiber.Ctx) error {
 	// 1) 调用 Service 获取 categories 字符串切片
 	cats, err := h.svc.GetAllCategories()
 	if err != nil {
-		return ErrorJSON(c, 500, err.Error())
+		return err
 	}
 
 	// 2) 将 cats 转换成 [{ "cate": string, "id": int64 }, ...]
@@ -294,15 +382,336 @@ func (h *PubHandler) BatchAddCategory(c *fiber.Ctx) error {
 	if err := c.BodyParser(&req); err != nil {
 		return ErrorJSON(c, 400, "invalid request body")
 	}
-	if req.Prefix == "" || req.Category == "" || req.Tag == "" {
-		return ErrorJSON(c, 400, "prefix & category & tag are required")
+	sel := types.PubSelector{
+		NamePrefix: req.Prefix,
+		NameRegex:  req.Regex,
+		BaseCodes:  req.BaseCodes,
+		MinPrice:   req.MinPrice,
+		MaxPrice:   req.MaxPrice,
+	}
+	if sel.IsEmpty() {
+		return ErrorJSON(c, 400, "at least one of prefix/regex/baseCodes/minPrice/maxPrice is required")
+	}
+	if err := sel.Validate(); err != nil {
+		return err
+	}
+
+	// dry-run: 只返回命中列表
+	if req.DryRun {
+		matched, err := h.svc.PreviewBatchCategory(sel)
+		if err != nil {
+			return err
+		}
+		return SuccessJSON(c, fiber.Map{
+			"dataList": matched,
+			"total":    len(matched),
+		})
+	}
+
+	if req.Category == "" || req.Tag == "" {
+		return ErrorJSON(c, 400, "category & tag are required")
 	}
 
 	// 调用 Service
-	err := h.svc.BatchAddCategoryForPrefix(req.Prefix, req.Category, req.Tag)
+	batchId, count, err := h.svc.BatchCategorize(sel, req.Category, req.Tag)
 	if err != nil {
-		return ErrorJSON(c, 500, err.Error())
+		return err
 	}
 
-	return SuccessJSON(c, fmt.Sprintf("已批量为 prefix=%q 的产品添加分类=%q", req.Prefix, req.Category))
+	return SuccessJSON(c, fiber.Map{
+		"batchId": batchId,
+		"total":   count,
+		"message": fmt.Sprintf("已批量为 %d 个产品添加分类=%q", count, req.Category),
+	})
+}
+
+// POST /public/batch_category/undo
+// Body: { "batchId": "xxx" }
+func (h *PubHandler) UndoBatchCategory(c *fiber.Ctx) error {
+	var req BatchUndoRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ErrorJSON(c, 400, "invalid request body")
+	}
+	if req.BatchId == "" {
+		return ErrorJSON(c, 400, "batchId is required")
+	}
+	count, conflicts, err := h.svc.UndoBatchCategory(req.BatchId)
+	if err != nil {
+		return err
+	}
+	// conflicts: 批次之后又被改过的 pub, 未还原
+	return SuccessJSON(c, fiber.Map{
+		"batchId":   req.BatchId,
+		"total":     count,
+		"conflicts": conflicts,
+	})
+}
+
+// GET /public/one/:publicCode/price_history?page=1&size=20
+func (h *PubHandler) ListPriceHistory(c *fiber.Ctx) error {
+	publicCode := c.Params("publicCode")
+	page, _ := strconv.ParseInt(c.Query("page"), 10, 64)
+	size, _ := strconv.ParseInt(c.Query("size"), 10, 64)
+
+	dataList, total, err := h.svc.ListPriceHistory(publicCode, page, size)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, fiber.Map{
+		"dataList": dataList,
+		"total":    total,
+	})
+}
+
+// GET /public/one/:publicCode/price_schedule
+func (h *PubHandler) ListPriceSchedules(c *fiber.Ctx) error {
+	publicCode := c.Params("publicCode")
+	dataList, err := h.svc.ListPriceSchedules(publicCode)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, fiber.Map{
+		"dataList": dataList,
+		"total":    len(dataList),
+	})
+}
+
+// POST /public/one/:publicCode/price_schedule
+// Body: { "salePrice": 9.9, "effectiveAt": "2025-01-01T00:00:00+08:00" }
+func (h *PubHandler) SchedulePriceChange(c *fiber.Ctx) error {
+	publicCode := c.Params("publicCode")
+	var dto types.PriceScheduleDTO
+	if err := c.BodyParser(&dto); err != nil {
+		return ErrorJSON(c, 400, err.Error())
+	}
+	created, err := h.svc.SchedulePriceChange(publicCode, &dto)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, created)
+}
+
+// DELETE /public/price_schedule/:id
+func (h *PubHandler) CancelPriceSchedule(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return ErrorJSON(c, 400, "invalid id")
+	}
+	if err := h.svc.CancelPriceSchedule(id); err != nil {
+		return err
+	}
+	return SuccessJSON(c, "Cancelled")
+}
+
+// GET /public/one/:publicCode/availability
+func (h *PubHandler) ListAvailabilityWindows(c *fiber.Ctx) error {
+	publicCode := c.Params("publicCode")
+	dataList, err := h.svc.ListAvailabilityWindows(publicCode)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, fiber.Map{
+		"dataList": dataList,
+		"total":    len(dataList),
+	})
+}
+
+// POST /public/one/:publicCode/availability
+// Body: { "recurrence":"weekly", "weekdays":[6,0], "dailyStart":"10:00", "dailyEnd":"22:00" }
+func (h *PubHandler) AddAvailabilityWindow(c *fiber.Ctx) error {
+	publicCode := c.Params("publicCode")
+	var dto types.AvailabilityWindowDTO
+	if err := c.BodyParser(&dto); err != nil {
+		return ErrorJSON(c, 400, err.Error())
+	}
+	created, err := h.svc.AddAvailabilityWindow(publicCode, &dto)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, created)
+}
+
+// DELETE /public/availability/:id
+func (h *PubHandler) DeleteAvailabilityWindow(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return ErrorJSON(c, 400, "invalid id")
+	}
+	if err := h.svc.DeleteAvailabilityWindow(id); err != nil {
+		return err
+	}
+	return SuccessJSON(c, "Deleted")
+}
+
+// POST /public/drift
+// Body: { "publicCode": "", "page": 1, "size": 500 } publicCode 为空时按页检查, total 为 pub 总数
+func (h *PubHandler) DriftReport(c *fiber.Ctx) error {
+	var req DriftRequest
+	_ = c.BodyParser(&req)
+	dataList, total, err := h.svc.DriftReport(req.PublicCode, req.Page, req.Size)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, fiber.Map{
+		"dataList": dataList,
+		"total":    total,
+	})
+}
+
+// POST /public/one/:publicCode/snapshot/refresh
+// 确认漂移后按当前 base 重新捕获快照
+func (h *PubHandler) RefreshSnapshots(c *fiber.Ctx) error {
+	count, err := h.svc.RefreshSnapshots(c.Params("publicCode"))
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, fiber.Map{"updated": count})
+}
+
+// POST /public/review/list
+// Body: { "page":1, "size":20 }
+func (h *PubHandler) ListNeedsReview(c *fiber.Ctx) error {
+	var req struct {
+		Page int64 `json:"page"`
+		Size int64 `json:"size"`
+	}
+	_ = c.BodyParser(&req)
+	dataList, total, err := h.svc.ListNeedsReview(req.Page, req.Size)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, fiber.Map{
+		"dataList": dataList,
+		"total":    total,
+	})
+}
+
+// POST /public/one/:publicCode/review/clear
+func (h *PubHandler) ClearReview(c *fiber.Ctx) error {
+	if err := h.svc.ClearReview(c.Params("publicCode")); err != nil {
+		return err
+	}
+	return SuccessJSON(c, "Cleared")
+}
+
+// POST /public/margin/report
+// 按当前 base 售价列出亏损或佣金超过毛利的 pub
+func (h *PubHandler) MarginReport(c *fiber.Ctx) error {
+	dataList, err := h.svc.MarginReport()
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, fiber.Map{
+		"dataList": dataList,
+		"total":    len(dataList),
+	})
+}
+
+// POST /public/import?format=csv|json&dryRun=true
+// Body: JSON 数组([]PubDTO) 或 CSV 文本(列见 types.PubCSVHeader), 也可用 multipart 字段 file 上传
+// dryRun 只返回校验结果和 create/update diff; 有校验错误时整体不提交
+func (h *PubHandler) ImportPubs(c *fiber.Ctx) error {
+	data := c.Body()
+	format := c.Query("format")
+	if fh, err := c.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		if err != nil {
+			return ErrorJSON(c, 400, err.Error())
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(f); err != nil {
+			return ErrorJSON(c, 400, err.Error())
+		}
+		if format == "" && strings.HasSuffix(strings.ToLower(fh.Filename), ".csv") {
+			format = "csv"
+		}
+	}
+	if format == "" && strings.Contains(string(c.Request().Header.ContentType()), "csv") {
+		format = "csv"
+	}
+
+	var dtos []types.PubDTO
+	var parseErrs []types.ImportError
+	if format == "csv" {
+		var err error
+		if dtos, parseErrs, err = types.ReadPubCSV(bytes.NewReader(data)); err != nil {
+			return ErrorJSON(c, 400, "invalid csv: "+err.Error())
+		}
+	} else if err := json.Unmarshal(data, &dtos); err != nil {
+		return ErrorJSON(c, 400, "invalid json: "+err.Error())
+	}
+	if len(dtos) == 0 {
+		return ErrorJSON(c, 400, "no rows to import")
+	}
+
+	dryRun := c.QueryBool("dryRun", false)
+	result, err := h.svc.ImportPubs(dtos, parseErrs, dryRun)
+	if err != nil {
+		if result == nil {
+			return err
+		}
+		// 部分批次已提交, 一并返回 committed
+		return writeAppError(c, err, result)
+	}
+	if !dryRun && len(result.Errors) > 0 {
+		return writeError(c, 400, types.KindValidation.Code(), "import validation failed", result)
+	}
+	return SuccessJSON(c, result)
+}
+
+// GET /public/export?format=csv|json
+func (h *PubHandler) ExportPubs(c *fiber.Ctx) error {
+	dtos, err := h.svc.ExportPubs()
+	if err != nil {
+		return err
+	}
+	if c.Query("format") == "csv" {
+		var buf bytes.Buffer
+		if err := types.WritePubCSV(&buf, dtos); err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="vip_pub.csv"`)
+		return c.Send(buf.Bytes())
+	}
+	return SuccessJSON(c, fiber.Map{
+		"dataList": dtos,
+		"total":    len(dtos),
+	})
+}
+
+// POST /public/trash/list
+// Body: { "page":1, "size":20 }
+func (h *PubHandler) ListTrash(c *fiber.Ctx) error {
+	var req struct {
+		Page int64 `json:"page"`
+		Size int64 `json:"size"`
+	}
+	_ = c.BodyParser(&req)
+	dataList, total, err := h.svc.ListTrash(req.Page, req.Size)
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, fiber.Map{
+		"dataList": dataList,
+		"total":    total,
+	})
+}
+
+// POST /public/trash/:publicCode/restore
+func (h *PubHandler) RestorePub(c *fiber.Ctx) error {
+	restored, err := h.svc.RestoreByPublicCode(c.Params("publicCode"))
+	if err != nil {
+		return err
+	}
+	return SuccessJSON(c, restored)
+}
+
+// DELETE /public/trash/:publicCode
+// 永久删除, 近期订单仍引用时返回 409
+func (h *PubHandler) PurgePub(c *fiber.Ctx) error {
+	if err := h.svc.PurgeByPublicCode(c.Params("publicCode")); err != nil {
+		return err
+	}
+	return SuccessJSON(c, "Purged")
 }
@@ -0,0 +1,108 @@
+// internal/handler/rbac.go
+package handler
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 角色, 由 JWT 的 roles 声明携带
+const (
+	RoleAdmin    = "admin"    // 全部权限
+	RoleOperator = "operator" // 商品运营: 维护 pub/base, 触发同步
+	RoleFinance  = "finance"  // 财务: 只读商品与订单, 看毛利报表
+	RoleCRM      = "crm"      // 客服/CRM: 查单, 回写订单状态
+	RolePartner  = "partner"  // 下游渠道: 查单
+)
+
+// Permission 路由级权限点
+type Permission string
+
+const (
+	PermPubRead   Permission = "pub:read"
+	PermPubWrite  Permission = "pub:write"
+	PermPubPurge  Permission = "pub:purge" // 永久删除
+	PermBaseRead  Permission = "base:read"
+	PermBaseWrite Permission = "base:write"
+	PermBasePurge Permission = "base:purge"
+	PermSync      Permission = "base:sync" // 触发远程同步 / 查看同步记录
+
+	PermOrderRead  Permission = "order:read"
+	PermOrderAdmin Permission = "order:admin" // 回写交易/退款/发货/结算状态
+
+	PermPartnerAdmin Permission = "partner:admin" // 签发/停用渠道密钥
+)
+
+// rolePermissions 各角色拥有的权限; admin 不在表中, 直接放行
+var rolePermissions = map[string][]Permission{
+	RoleOperator: {PermPubRead, PermPubWrite, PermBaseRead, PermBaseWrite, PermSync},
+	RoleFinance:  {PermPubRead, PermBaseRead, PermOrderRead},
+	RoleCRM:      {PermOrderRead, PermOrderAdmin},
+	RolePartner:  {PermOrderRead}, // 下单走签名接口, 见 PartnerSignature; 只能读自己的订单, 见 orderScope
+}
+
+// HasPermission 任一角色拥有 perm 即可
+func HasPermission(roles []string, perm Permission) bool {
+	for _, role := range roles {
+		if role == RoleAdmin {
+			return true
+		}
+		for _, p := range rolePermissions[role] {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// EffectiveRoles 返回 claims 中的角色(统一小写);
+// 未携带 roles 的旧 token: userSn=CRM 视为 crm, 其余视为 partner
+func (c *CustomClaims) EffectiveRoles() []string {
+	roles := make([]string, 0, len(c.Roles))
+	for _, r := range c.Roles {
+		if r = strings.ToLower(strings.TrimSpace(r)); r != "" {
+			roles = append(roles, r)
+		}
+	}
+	if len(roles) > 0 {
+		return roles
+	}
+	if c.UserSn == "CRM" {
+		return []string{RoleCRM}
+	}
+	return []string{RolePartner}
+}
+
+// RequirePermission 路由级鉴权, 需挂在 JWTMiddleware 之后;
+// 传多个权限时需全部满足
+func RequirePermission(perms ...Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		roles, _ := c.Locals("roles").([]string)
+		for _, perm := range perms {
+			if !HasPermission(roles, perm) {
+				return Forbidden(c, "Forbidden: missing permission "+string(perm))
+			}
+		}
+		return c.Next()
+	}
+}
+
+// orderScope partner 只能读自己及直属下级渠道的订单, 返回其 userSn 与 scoped=true;
+// 另有可读订单的角色(admin/crm/finance)时不限定
+func orderScope(c *fiber.Ctx) (string, bool) {
+	roles, _ := c.Locals("roles").([]string)
+	for _, role := range roles {
+		if role != RolePartner && HasPermission([]string{role}, PermOrderRead) {
+			return "", false
+		}
+	}
+	userSn, _ := c.Locals("userSn").(string)
+	return userSn, true
+}
+
+// orderVisible 订单是否属于 userSn 或其直属下级渠道
+func orderVisible(userSn, orderUserSn, orderParentSn string) bool {
+	return userSn != "" && (orderUserSn == userSn || orderParentSn == userSn)
+}
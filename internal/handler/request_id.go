@@ -0,0 +1,28 @@
+// internal/handler/request_id.go
+package handler
+
+import (
+	"regexp"
+
+	"10000hk.com/vip_gift/pkg"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// 调用方自带的 ID 只接受短的安全字符, 防止日志注入
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID 为每个请求分配关联 ID: 优先沿用调用方的 X-Request-ID, 否则生成 UUID;
+// ID 写回响应头, 并放进 c.UserContext() 的日志字段, 之后经 Kafka 消息头传给消费者.
+// 需在 app.Use 中最先注册
+func RequestID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Get(pkg.HeaderRequestID)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		c.Set(pkg.HeaderRequestID, id)
+		c.SetUserContext(pkg.WithLogAttrs(c.UserContext(), pkg.LogKeyRequestID, id))
+		return c.Next()
+	}
+}
@@ -0,0 +1,43 @@
+// internal/handler/request_metrics.go
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"10000hk.com/vip_gift/internal/metrics"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequestMetrics 记录每个请求的次数与耗时, 需在 app.Use 中注册在 RequestID / Tracing 之后;
+// route 取匹配到的路由模板, 未匹配任何路由的请求统一记为 unmatched
+func RequestMetrics() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		self := c.Route()
+
+		// 1) 错误先交给全局 ErrorHandler 写响应, 才能拿到真实状态码
+		if err := c.Next(); err != nil {
+			if hErr := c.App().ErrorHandler(c, err); hErr != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		// 2) 打点
+		path := routeTemplate(c, self)
+		status := strconv.Itoa(c.Response().StatusCode())
+		metrics.HTTPRequests.WithLabelValues(c.Method(), path, status).Inc()
+		metrics.HTTPDuration.WithLabelValues(c.Method(), path, status).Observe(time.Since(start).Seconds())
+		return nil
+	}
+}
+
+// routeTemplate c.Next() 之后匹配到的路由模板; self 为中间件自身的路由,
+// 仍停留在它上面说明没有匹配任何路由, 统一记为 unmatched, 避免按原始路径产生无限多的标签
+func routeTemplate(c *fiber.Ctx, self *fiber.Route) string {
+	route := c.Route()
+	if route == self {
+		return "unmatched"
+	}
+	return route.Path
+}
@@ -0,0 +1,48 @@
+// internal/handler/tracing.go
+package handler
+
+import (
+	"10000hk.com/vip_gift/pkg"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing 为每个请求开一个 server span(沿用调用方的 traceparent), span 放进 c.UserContext(),
+// 下游的 Kafka 消息、上游 HTTP 调用都挂在它下面. 需注册在 RequestID 之后、RequestMetrics 之前
+func Tracing() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		self := c.Route()
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), propagation.HeaderCarrier(c.GetReqHeaders()))
+		ctx, span := pkg.Tracer().Start(ctx, c.Method(),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Method()),
+				semconv.URLPath(c.Path()),
+			),
+		)
+		defer span.End()
+		c.SetUserContext(ctx)
+
+		// 1) 错误先交给全局 ErrorHandler 写响应, 才能拿到真实状态码
+		if err := c.Next(); err != nil {
+			span.RecordError(err)
+			if hErr := c.App().ErrorHandler(c, err); hErr != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		// 2) span 名取路由模板, 如 "POST /api/product/gift/orders/create"
+		route := routeTemplate(c, self)
+		status := c.Response().StatusCode()
+		span.SetName(c.Method() + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+		if status >= fiber.StatusInternalServerError {
+			span.SetStatus(codes.Error, "")
+		}
+		return nil
+	}
+}
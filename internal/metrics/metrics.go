@@ -0,0 +1,99 @@
+// internal/metrics/metrics.go
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// 指标统一注册到 prometheus 默认 Registry, 由 GET /metrics 暴露
+
+// 结果标签取值
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// Result err 为 nil 时返回 success
+func Result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
+
+// 1) HTTP, route 取 Fiber 路由模板(如 /public/one/:publicCode), 避免标签基数爆炸
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vip_http_requests_total",
+		Help: "HTTP requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "vip_http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// 2) 订单
+var OrdersCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "vip_orders_created_total",
+	Help: "Orders accepted by POST /orders/create, by channel and result.",
+}, []string{"channel", "result"})
+
+// 3) 上游调用, provider 为 gift / charge, operation 为 create / query
+var UpstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "vip_upstream_request_duration_seconds",
+	Help:    "Latency of DoCreateOrder / DoQueryOrder by provider, operation and result.",
+	Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30},
+}, []string{"provider", "operation", "result"})
+
+// OrdersParked 上游熔断 / 并发已满时暂存、等待重新下单的订单数
+var OrdersParked = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "vip_orders_parked",
+	Help: "Orders parked by the consumer while the upstream circuit breaker or bulkhead rejects them.",
+})
+
+// 4) Kafka
+var (
+	KafkaProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vip_kafka_messages_produced_total",
+		Help: "Kafka messages written, by topic and result.",
+	}, []string{"topic", "result"})
+
+	// result: success / fetch_error / decode_error / commit_error
+	KafkaConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vip_kafka_messages_consumed_total",
+		Help: "Kafka messages fetched by the order consumer, by topic and result.",
+	}, []string{"topic", "result"})
+
+	KafkaConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vip_kafka_consumer_lag",
+		Help: "Consumer group lag reported by the Kafka reader, by topic.",
+	}, []string{"topic"})
+)
+
+// 5) 延迟查单调度器
+var (
+	SchedulerQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "vip_query_scheduler_queue_length",
+		Help: "Tasks buffered in QueryScheduler.tasksChan.",
+	})
+
+	SchedulerQueueCapacity = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "vip_query_scheduler_queue_capacity",
+		Help: "Buffer size of QueryScheduler.tasksChan.",
+	})
+
+	SchedulerPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "vip_query_scheduler_pending_tasks",
+		Help: "Tasks waiting for their delay or running DoQueryOrder.",
+	})
+)
+
+// 6) 上游状态回调
+var NotifierRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "vip_notifier_requests_total",
+	Help: "Order status notifications sent upstream, by result.",
+}, []string{"result"})
@@ -3,16 +3,23 @@ package mq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 
+	"10000hk.com/vip_gift/internal/metrics"
 	"10000hk.com/vip_gift/internal/proxy"
 	"10000hk.com/vip_gift/internal/service"
 	"10000hk.com/vip_gift/internal/types"
+	"10000hk.com/vip_gift/pkg"
 )
 
 // OrderMessage 用于解析 `vip-order-create` 主题的 Kafka 消息
@@ -25,6 +32,11 @@ type OrderMessage struct {
 	CommissionParent  float64           `json:"commissionParent"`
 	UserSn            string            `json:"userSn"`
 	ParentSn          string            `json:"parentSn"`
+	PublicCode        string            `json:"publicCode"`
+	Channel           string            `json:"channel"`
+	SalePrice         float64           `json:"salePrice"`
+	ParValue          float64           `json:"parValue"`
+	CommissionMF      float64           `json:"commissionMF"`
 }
 
 // OrderUpdateMessage 用于解析 `order-update` 主题的 Kafka 消息
@@ -45,8 +57,18 @@ type OrderConsumer struct {
 	orderService   service.OrderService
 	pub            service.PubService
 	queryScheduler *QueryScheduler
+	lastFetchErr   atomic.Int64 // 最近一次拉取失败的时间(unix 纳秒), 0 表示从未失败
 }
 
+// 上游熔断 / 并发已满时订单以 StatusParked 落库暂存, 由 runParkedRetry 定时从 DB 领取后重新下单;
+// 进程重启后同样从 DB 继续, 不依赖内存
+const (
+	parkRetryInterval = 5 * time.Second  // 多久重试一轮
+	parkRetryBatch    = 100              // 每轮最多重试多少笔
+	parkMaxAge        = 10 * time.Minute // 订单创建超过该时长仍未下单成功则判失败
+	parkClaimLease    = 2 * time.Minute  // 领取后超过该时长仍是 parked(如重试途中进程退出)则可再次领取
+)
+
 // NewOrderConsumer 初始化消费者（支持 `vip-order-create` 和 `order-update`）
 func NewOrderConsumer(brokers []string, createTopic, updateTopic, groupID string, orderSvc service.OrderService, pubSvc service.PubService, qs *QueryScheduler) *OrderConsumer {
 	createReader := kafka.NewReader(kafka.ReaderConfig{
@@ -81,9 +103,10 @@ func NewOrderConsumer(brokers []string, createTopic, updateTopic, groupID string
 func (o *OrderConsumer) Start() {
 	go o.runCreateConsumer() // 处理订单创建
 	go o.runUpdateConsumer() // 处理订单状态更新
+	go o.runParkedRetry()    // 重试熔断期间暂存的订单
 }
 
-// Stop 关闭 Kafka 消费者
+// Stop 关闭 Kafka 消费者; 暂存的订单留在 DB, 重启后继续重试
 func (o *OrderConsumer) Stop() {
 	close(o.stopCh)
 	_ = o.reader.Close()
@@ -93,8 +116,8 @@ func (o *OrderConsumer) Stop() {
 // ========== 1. 处理 `vip-order-create`（创建订单） ==========
 
 func (o *OrderConsumer) runCreateConsumer() {
-	log.Println("[OrderConsumer] Starting vip-order-create consumer loop")
-	defer log.Println("[OrderConsumer] Stopped vip-order-create consumer loop")
+	slog.Info("order consumer started", "topic", o.reader.Config().Topic)
+	defer slog.Info("order consumer stopped", "topic", o.reader.Config().Topic)
 
 	for {
 		select {
@@ -105,30 +128,79 @@ func (o *OrderConsumer) runCreateConsumer() {
 
 		m, err := o.reader.FetchMessage(context.Background())
 		if err != nil {
-			log.Printf("[OrderConsumer] Fetch message error: %v\n", err)
+			o.lastFetchErr.Store(time.Now().UnixNano())
+			metrics.KafkaConsumed.WithLabelValues(o.reader.Config().Topic, "fetch_error").Inc()
+			slog.Error("fetch kafka message failed", "topic", o.reader.Config().Topic, "error", err)
 			time.Sleep(1 * time.Second)
 			continue
 		}
 
 		var msg OrderMessage
+		observeLag(o.reader, m.Topic)
+		// 关联 ID 取自消息头, 与下单请求的日志串联
+		ctx := pkg.KafkaContext(context.Background(), m.Headers)
 		if err := json.Unmarshal(m.Value, &msg); err != nil {
-			log.Printf("[OrderConsumer] Unmarshal error: %v\n", err)
+			slog.ErrorContext(ctx, "decode kafka message failed", "topic", m.Topic, "offset", m.Offset, "error", err)
+			metrics.KafkaConsumed.WithLabelValues(m.Topic, "decode_error").Inc()
 			_ = o.reader.CommitMessages(context.Background(), m)
 			continue
 		}
 
-		o.handleCreateOrder(msg)
+		ctx = pkg.WithOrderIDs(ctx, msg.OrderId, msg.DownstreamOrderId)
+		ctx, span := startProcessSpan(ctx, m)
+		o.handleCreateOrder(ctx, msg)
+		span.End()
 
 		if err := o.reader.CommitMessages(context.Background(), m); err != nil {
-			log.Printf("[OrderConsumer] Commit error: %v\n", err)
+			slog.ErrorContext(ctx, "commit kafka message failed", "topic", m.Topic, "offset", m.Offset, "error", err)
+			metrics.KafkaConsumed.WithLabelValues(m.Topic, "commit_error").Inc()
+		} else {
+			metrics.KafkaConsumed.WithLabelValues(m.Topic, metrics.ResultSuccess).Inc()
 		}
 	}
 }
 
+// fetchErrWindow 拉取失败后每秒重试一次, 该窗口内仍有失败说明 reader 还没恢复
+const fetchErrWindow = 10 * time.Second
+
+// Healthy 两个 reader 的 broker 可连、topic 存在, 且最近没有拉取失败
+func (o *OrderConsumer) Healthy(ctx context.Context) error {
+	for _, r := range []*kafka.Reader{o.reader, o.updateReader} {
+		cfg := r.Config()
+		if len(cfg.Brokers) == 0 {
+			return fmt.Errorf("reader %s has no brokers", cfg.Topic)
+		}
+		if err := pkg.PingKafka(ctx, cfg.Brokers[0], cfg.Topic); err != nil {
+			return fmt.Errorf("reader %s: %w", cfg.Topic, err)
+		}
+	}
+	if last := o.lastFetchErr.Load(); last != 0 && time.Since(time.Unix(0, last)) < fetchErrWindow {
+		return fmt.Errorf("fetch failed at %s", time.Unix(0, last).Format(time.RFC3339))
+	}
+	return nil
+}
+
+// observeLag 消费组模式下 Reader.Lag() 恒为 -1, 取 Stats 中的 Lag
+func observeLag(r *kafka.Reader, topic string) {
+	metrics.KafkaConsumerLag.WithLabelValues(topic).Set(float64(r.Stats().Lag))
+}
+
+// startProcessSpan 消费 span, 父 span 取自消息头里的 traceparent(即下单请求的 producer span)
+func startProcessSpan(ctx context.Context, m kafka.Message) (context.Context, trace.Span) {
+	return pkg.Tracer().Start(ctx, m.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(m.Topic),
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(m.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(m.Offset)),
+		),
+	)
+}
+
 // 处理订单创建
-func (o *OrderConsumer) handleCreateOrder(msg OrderMessage) {
-	log.Printf("[OrderConsumer] got order: orderId=%s downstreamId=%s status=%d\n",
-		msg.OrderId, msg.DownstreamOrderId, msg.Status)
+func (o *OrderConsumer) handleCreateOrder(ctx context.Context, msg OrderMessage) {
+	slog.InfoContext(ctx, "order message received", "status", int64(msg.Status))
 
 	// 1) 转成 OrderDTO
 	dto := &types.OrderDTO{
@@ -142,52 +214,174 @@ func (o *OrderConsumer) handleCreateOrder(msg OrderMessage) {
 		CommissionRule:    "MF", // 权益业务通通默认秒返
 		CommissionSelf:    msg.CommissionSelf,
 		CommissionParent:  msg.CommissionParent,
+		PublicCode:        msg.PublicCode,
+		Channel:           msg.Channel,
+		SalePrice:         msg.SalePrice,
+		ParValue:          msg.ParValue,
+		CommissionMF:      msg.CommissionMF,
 	}
 
 	// 2) 写DB
-	if err := o.orderService.StoreToDB(context.Background(), dto); err != nil {
-		log.Printf("[OrderConsumer] store to DB error: %v\n", err)
+	if err := o.orderService.StoreToDB(ctx, dto); err != nil {
+		slog.ErrorContext(ctx, "store order failed", "error", err)
+		pkg.RecordSpanError(ctx, err)
 		return
 	}
 	// 3) 进一步逻辑: e.g. 通知, 回调, 更新状态...
-	fmt.Printf("[OrderConsumer] order %s has been inserted into DB.\n", msg.OrderId)
-	var orderApi types.OrderApi
+	orderApi := o.orderApiFor(msg.DownstreamOrderId)
+	if orderApi == nil {
+		slog.WarnContext(ctx, "unknown downstreamOrderId prefix, order skipped")
+		return
+	}
+
+	// 4) 下单; 上游熔断或并发已满时不等超时, 暂存后重试
+	if err := o.submitOrder(ctx, dto, orderApi); types.IsUpstreamRejected(err) {
+		o.park(ctx, dto, err)
+	}
+}
+
+// orderApiFor 按 downstreamOrderId 前缀选上游, 未知前缀返回 nil
+func (o *OrderConsumer) orderApiFor(downstreamOrderId string) types.OrderApi {
 	switch {
-	case strings.Contains(msg.DownstreamOrderId, "VV"):
-		orderApi = proxy.NewGiftApi(map[string]string{
+	case strings.Contains(downstreamOrderId, "VV"):
+		return proxy.NewGiftApi(map[string]string{
 			"CreateOrder": "https://gift.10000hk.com/api/fulu/order/recharge", //"https://api0.10000hk.com/api/product/gift/customer/orders/create",
 			"QueryOrder":  "https://gift.10000hk.com/api/fulu/order/query",    //"https://api0.10000hk.com/api/product/gift/orders/query",
 		}, o.pub, o.orderService)
-	case strings.Contains(msg.DownstreamOrderId, "VF"):
-		orderApi = proxy.NewChargeApi(map[string]string{
+	case strings.Contains(downstreamOrderId, "VF"):
+		return proxy.NewChargeApi(map[string]string{
 			"CreateOrder": "https://gift.10000hk.com/api/charge/order/recharge",
 			"QueryOrder":  "https://gift.10000hk.com/api/charge/order/query",
-		})
+		}, o.pub)
 	default:
-		log.Printf("[OrderConsumer] unknown downstreamOrderId: %s\n", msg.DownstreamOrderId)
-		return
+		return nil
 	}
+}
 
-	orderCreateResp, err := orderApi.DoCreateOrder(context.Background(), dto)
+// submitOrder 调上游下单, 成功后排查单; 失败(熔断拒绝除外)判上游失败
+func (o *OrderConsumer) submitOrder(ctx context.Context, dto *types.OrderDTO, orderApi types.OrderApi) error {
+	orderCreateResp, err := orderApi.DoCreateOrder(ctx, dto)
+	if types.IsUpstreamRejected(err) {
+		return err
+	}
 	if err != nil {
-		log.Printf("[OrderConsumer] DoCreateOrder error: %v\n", err)
-		dto.Status = 500
-		dto.Remark = fmt.Sprintf("DoCreateOrder error: %v", err)
-		_ = o.orderService.StoreToDB(context.Background(), dto)
-		return
+		o.failOrder(ctx, dto, err)
+		return err
 	}
-	log.Printf("[OrderConsumer] DoCreateOrder resp: %+v\n", orderCreateResp)
+	slog.InfoContext(ctx, "DoCreateOrder succeeded", "resp", orderCreateResp)
 
 	// If creation succeeded, we schedule queries at 3s, 7s, 11s
 	// so we do not block the consumer
-	o.scheduleQueryAttempts(dto, orderApi)
+	o.scheduleQueryAttempts(ctx, dto, orderApi)
+	return nil
+}
+
+func (o *OrderConsumer) failOrder(ctx context.Context, dto *types.OrderDTO, err error) {
+	slog.ErrorContext(ctx, "DoCreateOrder failed", "error", err)
+	pkg.RecordSpanError(ctx, err)
+	dto.Status = types.StatusUpstreamFail
+	dto.Remark = fmt.Sprintf("DoCreateOrder error: %v", err)
+	_ = o.orderService.StoreToDB(ctx, dto)
+}
+
+// park 被熔断 / 并发隔离拒绝的订单以 StatusParked 落库, 由 runParkedRetry 领取重试
+func (o *OrderConsumer) park(ctx context.Context, dto *types.OrderDTO, cause error) {
+	dto.Status = types.StatusParked
+	dto.Remark = "parked: upstream unavailable, will retry"
+	if err := o.orderService.StoreToDB(ctx, dto); err != nil {
+		slog.ErrorContext(ctx, "park order failed", "error", err, "cause", cause)
+		pkg.RecordSpanError(ctx, err)
+		return
+	}
+	slog.WarnContext(ctx, "upstream unavailable, order parked for retry", "error", cause)
+}
+
+// runParkedRetry 启动时先重试一轮(接上重启前暂存的), 之后每 parkRetryInterval 一轮
+func (o *OrderConsumer) runParkedRetry() {
+	ticker := time.NewTicker(parkRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		o.retryParkedOrders()
+		select {
+		case <-o.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// retryParkedOrders 领取一批暂存订单重新下单; 仍被拒绝的再次暂存, 创建超过 parkMaxAge 的判失败
+func (o *OrderConsumer) retryParkedOrders() {
+	list, total, err := o.orderService.ListParkedOrders(context.Background(), parkRetryBatch, parkClaimLease)
+	if err != nil {
+		slog.Error("list parked orders failed", "error", err)
+		return
+	}
+	metrics.OrdersParked.Set(float64(total))
+
+	for i := range list {
+		select {
+		case <-o.stopCh:
+			return
+		default:
+		}
+		o.retryParked(&list[i])
+	}
+}
+
+func (o *OrderConsumer) retryParked(ent *types.OrderEntity) {
+	ctx := pkg.WithOrderIDs(context.Background(), ent.OrderId, ent.DownstreamOrderId)
+	ctx, span := pkg.Tracer().Start(ctx, "order retry parked")
+	defer span.End()
+
+	// 1) 领取; 其它实例已领走的跳过. 领取后订单仍是 parked, 写入新状态前退出的由租约过期后重新领取
+	claimed, err := o.orderService.ClaimParkedOrder(ctx, ent.OrderId, parkClaimLease)
+	if err != nil {
+		slog.ErrorContext(ctx, "claim parked order failed", "error", err)
+		return
+	}
+	if !claimed {
+		return
+	}
+	var dto types.OrderDTO
+	if err := dto.FromEntity(ent); err != nil {
+		o.failOrder(ctx, &dto, err)
+		return
+	}
+	dto.Status = types.StatusInit
+
+	// 2) 超时 / 无法识别上游 => 判失败
+	if age := time.Since(ent.CreatedAt); age >= parkMaxAge {
+		o.failOrder(ctx, &dto, fmt.Errorf("upstream unavailable for %s", age.Truncate(time.Second)))
+		return
+	}
+	orderApi := o.orderApiFor(ent.DownstreamOrderId)
+	if orderApi == nil {
+		o.failOrder(ctx, &dto, errors.New("unknown downstreamOrderId prefix"))
+		return
+	}
+
+	// 3) 重新下单
+	err = o.submitOrder(ctx, &dto, orderApi)
+	switch {
+	case types.IsUpstreamRejected(err):
+		o.park(ctx, &dto, err)
+	case err == nil:
+		dto.Status = types.StatusPending
+		dto.Remark = dto.Status.Remark()
+		if err := o.orderService.StoreToDB(ctx, &dto); err != nil {
+			slog.ErrorContext(ctx, "store resubmitted order failed", "error", err)
+		}
+		slog.InfoContext(ctx, "parked order submitted", "orderAge", time.Since(ent.CreatedAt).Truncate(time.Second).String())
+	}
 }
 
 // ========== 2. 处理 `order-update`（更新订单状态） ==========
 
 func (o *OrderConsumer) runUpdateConsumer() {
-	log.Println("[OrderConsumer] Starting order-update consumer loop")
-	defer log.Println("[OrderConsumer] Stopped order-update consumer loop")
+	slog.Info("order consumer started", "topic", o.updateReader.Config().Topic)
+	defer slog.Info("order consumer stopped", "topic", o.updateReader.Config().Topic)
 
 	for {
 		select {
@@ -198,37 +392,48 @@ func (o *OrderConsumer) runUpdateConsumer() {
 
 		m, err := o.updateReader.FetchMessage(context.Background())
 		if err != nil {
-			log.Printf("[OrderConsumer] Fetch order-update message error: %v\n", err)
+			o.lastFetchErr.Store(time.Now().UnixNano())
+			metrics.KafkaConsumed.WithLabelValues(o.updateReader.Config().Topic, "fetch_error").Inc()
+			slog.Error("fetch kafka message failed", "topic", o.updateReader.Config().Topic, "error", err)
 			time.Sleep(1 * time.Second)
 			continue
 		}
 
 		var msg OrderUpdateMessage
+		observeLag(o.updateReader, m.Topic)
+		ctx := pkg.KafkaContext(context.Background(), m.Headers)
 		if err := json.Unmarshal(m.Value, &msg); err != nil {
-			log.Printf("[OrderConsumer] Unmarshal error: %v\n", err)
+			slog.ErrorContext(ctx, "decode kafka message failed", "topic", m.Topic, "offset", m.Offset, "error", err)
+			metrics.KafkaConsumed.WithLabelValues(m.Topic, "decode_error").Inc()
 			_ = o.updateReader.CommitMessages(context.Background(), m)
 			continue
 		}
 
-		o.handleUpdateOrder(msg)
+		ctx = pkg.WithOrderIDs(ctx, msg.OrderId, msg.DownstreamOrderId)
+		ctx, span := startProcessSpan(ctx, m)
+		o.handleUpdateOrder(ctx, msg)
+		span.End()
 
 		if err := o.updateReader.CommitMessages(context.Background(), m); err != nil {
-			log.Printf("[OrderConsumer] Commit error: %v\n", err)
+			slog.ErrorContext(ctx, "commit kafka message failed", "topic", m.Topic, "offset", m.Offset, "error", err)
+			metrics.KafkaConsumed.WithLabelValues(m.Topic, "commit_error").Inc()
+		} else {
+			metrics.KafkaConsumed.WithLabelValues(m.Topic, metrics.ResultSuccess).Inc()
 		}
 	}
 }
 
 // 处理订单更新
-func (o *OrderConsumer) handleUpdateOrder(msg OrderUpdateMessage) {
+func (o *OrderConsumer) handleUpdateOrder(ctx context.Context, msg OrderUpdateMessage) {
 	var order *types.OrderEntity
 	var err error
 
 	if msg.DownstreamOrderId != "" {
-		order, err = o.orderService.GetOrderByDownstreamOrderId(context.Background(), msg.DownstreamOrderId)
+		order, err = o.orderService.GetOrderByDownstreamOrderId(ctx, msg.DownstreamOrderId)
 	}
 
 	if err != nil {
-		log.Printf("[OrderConsumer] Order not found: orderId=%s, downstreamOrderId=%s\n", msg.OrderId, msg.DownstreamOrderId)
+		slog.WarnContext(ctx, "order to update not found", "error", err)
 		return
 	}
 
@@ -245,22 +450,25 @@ func (o *OrderConsumer) handleUpdateOrder(msg OrderUpdateMessage) {
 		order.SettlementStatus = msg.SettlementStatus
 	}
 
-	if err := o.orderService.UpdateOrder(context.Background(), order); err != nil {
-		log.Printf("[OrderConsumer] Failed to update order: %v\n", err)
+	if err := o.orderService.UpdateOrder(ctx, order); err != nil {
+		slog.ErrorContext(ctx, "update order failed", "error", err)
+		pkg.RecordSpanError(ctx, err)
 	}
 }
 
 // ========== 3. 订单查询调度 ==========
-func (o *OrderConsumer) scheduleQueryAttempts(dto *types.OrderDTO, orderApi types.OrderApi) {
+func (o *OrderConsumer) scheduleQueryAttempts(ctx context.Context, dto *types.OrderDTO, orderApi types.OrderApi) {
 	delays := []time.Duration{3 * time.Second, 7 * time.Second, 13 * time.Second, 31 * time.Second, 61 * time.Second, 121 * time.Second}
-	for _, d := range delays {
+	for i, d := range delays {
 		task := QueryTask{
-			OrderDTO: dto,
-			Delay:    d,
-			OrderApi: orderApi,
-			OrderSvc: o.orderService,
+			OrderDTO:        dto,
+			Delay:           d,
+			OrderApi:        orderApi,
+			OrderSvc:        o.orderService,
+			Ctx:             ctx,
+			RetryUntilFinal: i == len(delays)-1,
 		}
 		o.queryScheduler.ScheduleQuery(task)
 	}
-	log.Printf("[OrderConsumer] scheduled queries for order=%s\n", dto.OrderId)
+	slog.InfoContext(ctx, "order queries scheduled", "attempts", len(delays))
 }
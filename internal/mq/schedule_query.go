@@ -1,13 +1,21 @@
 package mq
 
 import (
+	"container/heap"
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"10000hk.com/vip_gift/internal/metrics"
 	"10000hk.com/vip_gift/internal/service"
 	"10000hk.com/vip_gift/internal/types"
+	"10000hk.com/vip_gift/pkg"
 )
 
 // QueryTask represents a request to query an order after a delay
@@ -16,71 +24,165 @@ type QueryTask struct {
 	Delay    time.Duration   // How long to wait before querying
 	OrderApi types.OrderApi  // The API to call for DoQueryOrder
 	OrderSvc service.OrderService
+	Ctx      context.Context // 携带 requestId / orderId 等日志关联字段, 为空时用 context.Background()
+
+	// RetryUntilFinal 查单被熔断拒绝、出错或仍未终态(如故障转移刚下发了下一个 base)时按退避重新排队;
+	// 只有最后一次查单设置, 否则订单会停在处理中且没有后续查单
+	RetryUntilFinal bool
+	Retries         int // 已重新排队的次数, 决定下次退避时长
 }
 
+const (
+	schedulerHeartbeat = 5 * time.Second  // 调度循环空闲时的心跳间隔, 超过 3 个间隔没有心跳视为卡死
+	queryRetryBase     = 30 * time.Second // 查单重试的首次退避, 之后每次翻倍
+	queryRetryMax      = 10 * time.Minute // 查单重试退避上限
+)
+
 // QueryScheduler runs in the background, processing scheduled queries.
+// 等待中的任务放在 loop 内的最小堆里, 到期后交给固定数量的 worker 查单,
+// 同时在途的查单数不超过 workers, 也不会每个任务各占一个 goroutine
 type QueryScheduler struct {
 	tasksChan chan QueryTask
+	readyChan chan QueryTask // 已到期、等待 worker 的任务
+	freed     chan struct{}  // worker 处理完一个任务后通知 loop 继续派发
+	workers   int
 	stopChan  chan struct{}
 	notifier  service.UpstreamNotifier // <--- 新增
+	lastBeat  atomic.Int64             // loop 最近一次心跳(unix 纳秒), 0 表示未启动
+	stopped   atomic.Bool
 }
 
-// NewQueryScheduler creates a QueryScheduler with a buffered channel
-func NewQueryScheduler(bufferSize int, notifier service.UpstreamNotifier) *QueryScheduler {
+// NewQueryScheduler creates a QueryScheduler with a buffered channel and a pool of workers
+func NewQueryScheduler(bufferSize, workers int, notifier service.UpstreamNotifier) *QueryScheduler {
+	if workers <= 0 {
+		workers = 1
+	}
+	metrics.SchedulerQueueCapacity.Set(float64(bufferSize))
 	return &QueryScheduler{
 		tasksChan: make(chan QueryTask, bufferSize),
+		readyChan: make(chan QueryTask, workers),
+		freed:     make(chan struct{}, 1),
+		workers:   workers,
 		stopChan:  make(chan struct{}),
 		notifier:  notifier,
 	}
 }
 
-// Start launches the background loop
+// Start launches the background loop and the workers
 func (qs *QueryScheduler) Start() {
+	for i := 0; i < qs.workers; i++ {
+		go qs.worker()
+	}
 	go qs.loop()
 }
 
-// Stop signals the scheduler to stop
+// Stop signals the scheduler to stop; 尚未到期的查单随之丢弃
 func (qs *QueryScheduler) Stop() {
+	qs.stopped.Store(true)
 	close(qs.stopChan)
-	close(qs.tasksChan)
 }
 
-// loop waits for incoming tasks and schedules them
+// loop 收任务放入等待堆, 到期的交给 worker; worker 都在忙时留在堆里, 稍后再派发
 func (qs *QueryScheduler) loop() {
+	beat := time.NewTicker(schedulerHeartbeat)
+	defer beat.Stop()
+	qs.lastBeat.Store(time.Now().UnixNano())
+
+	waiting := &queryHeap{}
+	busy := false // worker 都在忙, 等 freed 再派发
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		// 堆顶任务到期时唤醒; Go 1.23 起 Reset 会丢弃未读的旧到期
+		if waiting.Len() > 0 && !busy {
+			timer.Reset(time.Until((*waiting)[0].due))
+		} else {
+			timer.Stop()
+		}
+
+		select {
+		case <-beat.C:
+			qs.lastBeat.Store(time.Now().UnixNano())
+		case <-qs.stopChan:
+			metrics.SchedulerPending.Sub(float64(waiting.Len()))
+			slog.Info("query scheduler stopped", "dropped", waiting.Len())
+			return
+		case task := <-qs.tasksChan:
+			qs.lastBeat.Store(time.Now().UnixNano())
+			metrics.SchedulerQueueLength.Set(float64(len(qs.tasksChan)))
+			metrics.SchedulerPending.Inc()
+			heap.Push(waiting, scheduledQuery{task: task, due: time.Now().Add(task.Delay)})
+		case <-timer.C:
+			busy = !qs.dispatchDue(waiting)
+		case <-qs.freed:
+			busy = !qs.dispatchDue(waiting)
+		}
+	}
+}
+
+// dispatchDue 把到期任务交给 worker; readyChan 已满说明 worker 都在忙, 返回 false 等有 worker 空闲再派发
+func (qs *QueryScheduler) dispatchDue(waiting *queryHeap) bool {
+	now := time.Now()
+	for waiting.Len() > 0 && !(*waiting)[0].due.After(now) {
+		select {
+		case qs.readyChan <- (*waiting)[0].task:
+			heap.Pop(waiting)
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// worker 依次执行到期的查单
+func (qs *QueryScheduler) worker() {
 	for {
 		select {
 		case <-qs.stopChan:
-			log.Println("[QueryScheduler] stopping scheduler loop")
 			return
-		case task, ok := <-qs.tasksChan:
-			if !ok {
-				return
+		case task := <-qs.readyChan:
+			qs.handleTask(task)
+			metrics.SchedulerPending.Dec()
+			select {
+			case qs.freed <- struct{}{}:
+			default:
 			}
-			// For each incoming task, launch a separate goroutine that
-			// waits the specified delay and then calls DoQueryOrder
-			go qs.handleTask(task)
 		}
 	}
 }
 
-// handleTask sleeps for 'Delay' then calls DoQueryOrder
+// handleTask calls DoQueryOrder for a task whose delay has elapsed
 func (qs *QueryScheduler) handleTask(task QueryTask) {
-	timer := time.NewTimer(task.Delay)
-	defer timer.Stop()
-
-	<-timer.C // Wait for the delay
+	ctx := task.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = pkg.WithOrderIDs(ctx, task.OrderDTO.OrderId, task.OrderDTO.DownstreamOrderId)
+	// 每次查单一个 span, 与下单链路同属一条 trace; 等待时长记在属性里
+	ctx, span := pkg.Tracer().Start(ctx, "QueryScheduler query",
+		trace.WithAttributes(attribute.String("vip.delay", task.Delay.String())))
+	defer span.End()
 
 	// Attempt the query
 	// For demonstration, we pass a slice of 1 ID.
-	fmt.Printf("[QueryScheduler] querying order=%s\n", task.OrderDTO.DownstreamOrderId)
+	slog.InfoContext(ctx, "querying order", "delay", task.Delay.String())
 	orderIds := []string{task.OrderDTO.DownstreamOrderId}
-	resp, err := task.OrderApi.DoQueryOrder(context.Background(), orderIds)
+	resp, err := task.OrderApi.DoQueryOrder(ctx, orderIds)
+	if types.IsUpstreamRejected(err) {
+		// 熔断 / 并发已满时没有真正查到上游, 订单状态不变, 留给后续的查单
+		slog.WarnContext(ctx, "DoQueryOrder skipped, upstream unavailable", "error", err)
+		pkg.RecordSpanError(ctx, err)
+		qs.retryLater(ctx, task)
+		return
+	}
 	if err != nil {
-		// handle error: update DB to reflect error status or log it
-		log.Printf("[QueryScheduler] DoQueryOrder error: %v\n", err)
-		task.OrderDTO.Status = 500
+		// 查单出错不代表订单失败(上游可能仍在处理或已成功), 状态与库存预占保持不变, 只记备注, 由后续查单确认
+		slog.ErrorContext(ctx, "DoQueryOrder failed", "error", err)
+		pkg.RecordSpanError(ctx, err)
 		task.OrderDTO.Remark = fmt.Sprintf("query error: %v", err)
-		_ = task.OrderSvc.StoreToDB(context.Background(), task.OrderDTO)
+		_ = task.OrderSvc.StoreToDB(ctx, task.OrderDTO)
+		qs.retryLater(ctx, task)
 		return
 	}
 
@@ -99,17 +201,75 @@ func (qs *QueryScheduler) handleTask(task QueryTask) {
 		}
 		task.OrderDTO.Status = parsed
 		task.OrderDTO.Remark = parsed.Remark()
-		_ = task.OrderSvc.StoreToDB(context.Background(), task.OrderDTO)
-		log.Printf("[QueryScheduler] updated order=%s to status=%d\n",
-			task.OrderDTO.OrderId, task.OrderDTO.Status)
-		if err := qs.notifier.NotifyOrderStatus(context.Background(), task.OrderDTO); err != nil {
+		_ = task.OrderSvc.StoreToDB(ctx, task.OrderDTO)
+		slog.InfoContext(ctx, "order status refreshed", "status", int64(task.OrderDTO.Status))
+		span.SetAttributes(attribute.Int64("vip.order_status", int64(task.OrderDTO.Status)))
+		if err := qs.notifier.NotifyOrderStatus(ctx, task.OrderDTO); err != nil {
 			// 可以根据实际需求重试或忽略
-			log.Printf("[QueryScheduler] notify upstream error: %v\n", err)
+			slog.ErrorContext(ctx, "notify upstream failed", "error", err)
 		}
+		if !parsed.IsFinal() {
+			qs.retryLater(ctx, task)
+		}
+	}
+}
+
+// retryLater 最后一次查单没能拿到终态时按指数退避重新排队, 直到查到为止
+func (qs *QueryScheduler) retryLater(ctx context.Context, task QueryTask) {
+	if !task.RetryUntilFinal {
+		return
+	}
+	task.Delay = queryRetryMax
+	if task.Retries < 5 { // 30s << 5 已超过上限
+		task.Delay = min(queryRetryBase<<task.Retries, queryRetryMax)
 	}
+	task.Retries++
+	slog.InfoContext(ctx, "order query rescheduled", "retries", task.Retries, "delay", task.Delay.String())
+	qs.ScheduleQuery(task)
 }
 
-// ScheduleQuery enqueues a QueryTask
+// Healthy 调度循环是否仍在运行, 供存活/就绪探针使用
+func (qs *QueryScheduler) Healthy(ctx context.Context) error {
+	last := qs.lastBeat.Load()
+	switch {
+	case qs.stopped.Load():
+		return errors.New("query scheduler is stopped")
+	case last == 0:
+		return errors.New("query scheduler is not started")
+	}
+	if since := time.Since(time.Unix(0, last)); since > 3*schedulerHeartbeat {
+		return fmt.Errorf("query scheduler loop stalled for %s", since.Truncate(time.Second))
+	}
+	return nil
+}
+
+// ScheduleQuery enqueues a QueryTask; 调度器已停止时丢弃
 func (qs *QueryScheduler) ScheduleQuery(task QueryTask) {
-	qs.tasksChan <- task
+	select {
+	case qs.tasksChan <- task:
+	case <-qs.stopChan:
+		return
+	}
+	metrics.SchedulerQueueLength.Set(float64(len(qs.tasksChan)))
+}
+
+// scheduledQuery 等待堆中的任务及其到期时间
+type scheduledQuery struct {
+	task QueryTask
+	due  time.Time
+}
+
+// queryHeap 按到期时间排序的最小堆, 只在 loop 内访问
+type queryHeap []scheduledQuery
+
+func (h queryHeap) Len() int           { return len(h) }
+func (h queryHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h queryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *queryHeap) Push(x any)        { *h = append(*h, x.(scheduledQuery)) }
+func (h *queryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
 }
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"10000hk.com/vip_gift/internal/metrics"
+	"10000hk.com/vip_gift/internal/sink"
+	"10000hk.com/vip_gift/internal/types"
+	"10000hk.com/vip_gift/pkg"
+)
+
+// instrumentedApi 为 DoCreateOrder / DoQueryOrder 记录按 provider 区分的耗时与结果, 并各开一个 span;
+// 其中的上游 HTTP 请求由 upstreamClient 再记子 span. 熔断打开时不发请求直接返回 ErrCircuitOpen
+type instrumentedApi struct {
+	types.OrderApi
+	provider string
+}
+
+func instrument(provider string, api types.OrderApi) types.OrderApi {
+	return &instrumentedApi{OrderApi: api, provider: provider}
+}
+
+func (m *instrumentedApi) DoCreateOrder(ctx context.Context, dto *types.OrderDTO) (*sink.OrderCreateResp, error) {
+	start := time.Now()
+	ctx, span := m.startSpan(ctx, "create", attribute.String("vip.order_id", dto.OrderId))
+	defer span.End()
+
+	if err := Guard(m.provider).Allow(); err != nil {
+		m.observe(ctx, "create", start, err)
+		return nil, err
+	}
+	resp, err := m.OrderApi.DoCreateOrder(ctx, dto)
+	m.observe(ctx, "create", start, err)
+	return resp, err
+}
+
+func (m *instrumentedApi) DoQueryOrder(ctx context.Context, ids []string) ([]sink.OrderQueryResp, error) {
+	start := time.Now()
+	ctx, span := m.startSpan(ctx, "query", attribute.StringSlice("vip.downstream_order_ids", ids))
+	defer span.End()
+
+	if err := Guard(m.provider).Allow(); err != nil {
+		m.observe(ctx, "query", start, err)
+		return nil, err
+	}
+	resp, err := m.OrderApi.DoQueryOrder(ctx, ids)
+	m.observe(ctx, "query", start, err)
+	return resp, err
+}
+
+func (m *instrumentedApi) startSpan(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("vip.provider", m.provider))
+	return pkg.Tracer().Start(ctx, m.provider+" "+operation, trace.WithAttributes(attrs...))
+}
+
+func (m *instrumentedApi) observe(ctx context.Context, operation string, start time.Time, err error) {
+	pkg.RecordSpanError(ctx, err)
+	metrics.UpstreamDuration.
+		WithLabelValues(m.provider, operation, metrics.Result(err)).
+		Observe(time.Since(start).Seconds())
+}
+
+// upstreamClient 上游 HTTP 客户端: 每个请求记一个 client span, 并透传 traceparent;
+// 请求经 provider 的 UpstreamGuard 做熔断与并发隔离
+func upstreamClient(provider string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: otelhttp.NewTransport(&guardedTransport{guard: Guard(provider), next: http.DefaultTransport}),
+	}
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+// gift public/list 默认字段映射
+var GiftCatalogMapping = types.FieldMapping{
+	"baseCode":     "publicCode",
+	"productName":  "productName",
+	"productType":  "productType",
+	"parValue":     "parValue",
+	"salePrice":    "salePrice",
+	"isShelve":     "status",
+	"productDesc":  "desc",
+	"productCover": "cover",
+	"productPics":  "pics",
+}
+
+// charge product/list 默认字段映射; 列表里的商品都在售, 不映射 isShelve
+var ChargeCatalogMapping = types.FieldMapping{
+	"baseCode":    "productId",
+	"productName": "product",
+	"salePrice":   "salePrice",
+}
+
+// jsonCatalogSource 两个来源的接口形态一致:
+// 请求 { "page", "size" }, 响应 { "code", "message", "data": { "dataList", "total" } }
+type jsonCatalogSource struct {
+	name       string
+	url        string
+	token      string
+	mapping    types.FieldMapping
+	httpClient *http.Client
+}
+
+// NewGiftCatalogSource mapping 为 nil 时使用 GiftCatalogMapping
+func NewGiftCatalogSource(url, token string, mapping types.FieldMapping) types.CatalogSource {
+	if mapping == nil {
+		mapping = GiftCatalogMapping
+	}
+	return newJSONCatalogSource(types.CatalogGift, url, token, mapping)
+}
+
+// NewChargeCatalogSource mapping 为 nil 时使用 ChargeCatalogMapping
+func NewChargeCatalogSource(url, token string, mapping types.FieldMapping) types.CatalogSource {
+	if mapping == nil {
+		mapping = ChargeCatalogMapping
+	}
+	return newJSONCatalogSource(types.CatalogCharge, url, token, mapping)
+}
+
+func newJSONCatalogSource(name, url, token string, mapping types.FieldMapping) *jsonCatalogSource {
+	return &jsonCatalogSource{
+		name:    name,
+		url:     url,
+		token:   token,
+		mapping: mapping,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (src *jsonCatalogSource) Name() string { return src.name }
+
+func (src *jsonCatalogSource) Map(raw json.RawMessage) (types.CatalogItem, error) {
+	return src.mapping.Apply(raw)
+}
+
+func (src *jsonCatalogSource) WithToken(token string) types.CatalogSource {
+	if src.token != "" || token == "" {
+		return src
+	}
+	cp := *src
+	cp.token = token
+	return &cp
+}
+
+func (src *jsonCatalogSource) FetchPage(ctx context.Context, page, size int) ([]json.RawMessage, error) {
+	// 上游目录需要鉴权, 没有 token 时不发请求
+	if src.token == "" {
+		return nil, fmt.Errorf("no token configured for catalog source %s", src.name)
+	}
+
+	// 1) 构造请求体： { "page": X, "size": Y }
+	bodyBytes, _ := json.Marshal(map[string]int{
+		"page": page,
+		"size": size,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, src.url, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", src.token))
+
+	// 2) 发送 POST 请求, 每页单独关闭 Body
+	resp, err := src.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to do request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote returned non-200 status: %d", resp.StatusCode)
+	}
+
+	// 3) 解析返回 JSON, 条目保留原始内容
+	var remoteResp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			DataList []json.RawMessage `json:"dataList"`
+			Total    int               `json:"total"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&remoteResp); err != nil {
+		return nil, fmt.Errorf("failed to decode remote response: %w", err)
+	}
+	if remoteResp.Code != 200 {
+		return nil, errors.New("remote response not success: " + remoteResp.Message)
+	}
+	return remoteResp.Data.DataList, nil
+}
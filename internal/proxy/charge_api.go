@@ -6,26 +6,28 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 
+	"10000hk.com/vip_gift/internal/service"
 	"10000hk.com/vip_gift/internal/sink"
 	"10000hk.com/vip_gift/internal/types"
 )
 
 type chargeApiImpl struct {
 	upstreamURL map[string]string
+	pub         service.PubService // 可为 nil: 不查本地目录
 	httpClient  *http.Client
 }
 
-func NewChargeApi(upstreamURL map[string]string) types.OrderApi {
-	return &chargeApiImpl{
+func NewChargeApi(upstreamURL map[string]string, pubSvc service.PubService) types.OrderApi {
+	return instrument(types.CatalogCharge, &chargeApiImpl{
 		upstreamURL: upstreamURL,
-		httpClient: &http.Client{
-			Timeout: 5 * time.Second,
-		},
-	}
+		pub:         pubSvc,
+		httpClient:  upstreamClient(types.CatalogCharge, 5*time.Second),
+	})
 }
 func (api *chargeApiImpl) DoSendSms(ctx context.Context, req sink.SmsReq) (*sink.OrderCreateResp, error) {
 	return &sink.OrderCreateResp{}, nil
@@ -33,7 +35,7 @@ func (api *chargeApiImpl) DoSendSms(ctx context.Context, req sink.SmsReq) (*sink
 func (api *chargeApiImpl) ToOrderDto(ctx context.Context, req sink.OrderCreateReq) (types.OrderDTO, error) {
 	var downstreamOrderId string = req.DownstreamOrderId
 	if downstreamOrderId == "" {
-		return types.OrderDTO{}, nil
+		return types.OrderDTO{}, types.NewValidationError("downstreamOrderId is required")
 	}
 	packReq := sink.BizDataJSON[sink.OrderChargeReq]{
 		Body: sink.OrderChargeReq{
@@ -46,21 +48,62 @@ func (api *chargeApiImpl) ToOrderDto(ctx context.Context, req sink.OrderCreateRe
 	}
 	bizReqJSON, _ := json.Marshal(packReq)
 
-	// 根据 publicCode 查找产品，获取 CommissionMF
+	// 根据 publicCode 查找产品，获取 CommissionMF 与价格快照; 优先用已同步到本地的 charge 目录
+	price, ok := api.lookupLocalProduct(ctx, req.PublicCode)
+	if !ok {
+		price = api.lookupRemoteProduct(ctx, req.PublicCode)
+	}
+	commissionMF := price.CommissionMF
+
+	// 组装订单 DTO，同时设置佣金比例
+	dto := types.OrderDTO{
+		DownstreamOrderId: downstreamOrderId,
+		PublicCode:        req.PublicCode,
+		DataJSON:          string(bizReqJSON),
+		Status:            0,
+		Remark:            "",
+		CommissionRule:    "MF", // 权益业务通通默认秒返
+		UserSn:            req.PartnerId,
+		ParentSn:          req.ParentSn,
+		// 根据查找到的 CommissionMF 计算下级/上级分佣
+		CommissionSelf:   commissionMF * 0.80,
+		CommissionParent: commissionMF * 0.20,
+		Channel:          types.GetChannel(req.PublicCode),
+		// 价格快照取自下单时解析到的商品, 而不是请求里的充值金额
+		SalePrice:    price.SalePrice,
+		ParValue:     price.ParValue,
+		CommissionMF: commissionMF,
+		Phone:        req.Phone,
+	}
+	return dto, nil
+}
+
+// chargePrice 下单时解析到的 charge 商品价格
+type chargePrice struct {
+	CommissionMF float64
+	SalePrice    float64
+	ParValue     float64
+}
+
+// lookupRemoteProduct 每次下单实时查询 charge product/list, 查不到时各项为 0;
+// product/list 条目没有面值字段, ParValue 为 0
+func (api *chargeApiImpl) lookupRemoteProduct(ctx context.Context, productId string) chargePrice {
 	var commissionMF float64 = 0.0
+	var salePrice float64 = 0.0
 	productLookupURL := "https://gift.10000hk.com/api/charge/product/list"
 
 	// 构造请求payload，假设查询条件为 productId（publicCode）
 	searchPayload, err := json.Marshal(map[string]any{
-		"productId": req.PublicCode,
+		"productId": productId,
 	})
 	if err != nil {
 		// 若payload构造失败，则记录日志后继续，commissionMF默认为0
-		fmt.Printf("[ToOrderDto] marshal search payload error: %v\n", err)
+		slog.WarnContext(ctx, "marshal product lookup payload failed", "error", err)
 	} else {
 		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, productLookupURL, bytes.NewBuffer(searchPayload))
 		if err == nil {
 			httpReq.Header.Set("Content-Type", "application/json")
+			setRequestID(ctx, httpReq)
 			resp, err := api.httpClient.Do(httpReq)
 			if err == nil {
 				defer resp.Body.Close()
@@ -85,53 +128,65 @@ func (api *chargeApiImpl) ToOrderDto(ctx context.Context, req sink.OrderCreateRe
 					var apiResp APIResponse
 					body, err := io.ReadAll(resp.Body)
 					if err != nil {
-						fmt.Printf("[ToOrderDto] read product lookup resp error: %v\n", err)
+						slog.WarnContext(ctx, "read product lookup response failed", "error", err)
 					} else if err = json.Unmarshal(body, &apiResp); err != nil {
-						fmt.Printf("[ToOrderDto] unmarshal product lookup resp error: %v\n", err)
+						slog.WarnContext(ctx, "decode product lookup response failed", "error", err)
 					} else if apiResp.Code == 200 {
 						// 在返回结果中查找匹配的产品（以 productId 比较）
 						for _, item := range apiResp.Data.DataList {
-							if item.ProductId == req.PublicCode {
+							if item.ProductId == productId {
 								// 将 CommissionValue 从 string 转换为 float64
 								commissionValue, errConv := strconv.ParseFloat(item.CommissionValue, 64)
 								if errConv != nil {
-									fmt.Printf("[ToOrderDto] convert CommissionValue error: %v\n", errConv)
+									slog.WarnContext(ctx, "invalid commissionValue", "productId", productId, "error", errConv)
 								} else {
 									commissionMF = commissionValue
 								}
+								// 记录下单时售价快照
+								if price, errConv := strconv.ParseFloat(item.SalePrice, 64); errConv == nil {
+									salePrice = price
+								}
 								break
 							}
 						}
 					} else {
-						fmt.Printf("[ToOrderDto] product lookup response code not 200, code=%d, msg=%s\n", apiResp.Code, apiResp.Message)
+						slog.WarnContext(ctx, "product lookup rejected", "code", apiResp.Code, "message", apiResp.Message)
 					}
 				} else {
-					fmt.Printf("[ToOrderDto] product lookup http status: %d\n", resp.StatusCode)
+					slog.WarnContext(ctx, "product lookup failed", "httpStatus", resp.StatusCode)
 				}
 			} else {
-				fmt.Printf("[ToOrderDto] product lookup request error: %v\n", err)
+				slog.WarnContext(ctx, "product lookup request failed", "error", err)
 			}
 		} else {
-			fmt.Printf("[ToOrderDto] create product lookup request error: %v\n", err)
+			slog.WarnContext(ctx, "create product lookup request failed", "error", err)
 		}
 	}
+	return chargePrice{CommissionMF: commissionMF, SalePrice: salePrice}
+}
 
-	// 组装订单 DTO，同时设置佣金比例
-	dto := types.OrderDTO{
-		DownstreamOrderId: downstreamOrderId,
-		PublicCode:        req.PublicCode,
-		DataJSON:          string(bizReqJSON),
-		Status:            0,
-		Remark:            "",
-		CommissionRule:    "MF", // 权益业务通通默认秒返
-		UserSn:            req.PartnerId,
-		ParentSn:          req.ParentSn,
-		// 根据查找到的 CommissionMF 计算下级/上级分佣
-		CommissionSelf:   commissionMF * 0.80,
-		CommissionParent: commissionMF * 0.20,
-		Channel:          types.GetChannel(req.PublicCode),
+// lookupLocalProduct 从本地 charge 目录(GncEntity, Source=charge)取售价与面值, 佣金在原始条目的 commissionValue 里
+func (api *chargeApiImpl) lookupLocalProduct(ctx context.Context, productId string) (chargePrice, bool) {
+	if api.pub == nil {
+		return chargePrice{}, false
 	}
-	return dto, nil
+	items, err := api.pub.LocalCatalog(types.CatalogCharge, []string{productId})
+	if err != nil || len(items) == 0 {
+		return chargePrice{}, false
+	}
+	var raw struct {
+		CommissionValue string `json:"commissionValue"`
+	}
+	if err := json.Unmarshal([]byte(items[0].UpstreamRaw), &raw); err != nil {
+		slog.WarnContext(ctx, "invalid upstream payload in local catalog", "productId", productId, "error", err)
+		return chargePrice{}, false
+	}
+	commissionMF, err := strconv.ParseFloat(raw.CommissionValue, 64)
+	if err != nil {
+		slog.WarnContext(ctx, "invalid commissionValue in local catalog", "productId", productId, "error", err)
+		return chargePrice{}, false
+	}
+	return chargePrice{CommissionMF: commissionMF, SalePrice: items[0].SalePrice, ParValue: items[0].ParValue}, true
 }
 func (api *chargeApiImpl) DoCreateOrder(ctx context.Context, dto *types.OrderDTO) (*sink.OrderCreateResp, error) {
 	var bizReq sink.BizDataJSON[sink.OrderChargeReq]
@@ -144,6 +199,7 @@ func (api *chargeApiImpl) DoCreateOrder(ctx context.Context, dto *types.OrderDTO
 		return nil, fmt.Errorf("chargeApi.DoCreateOrder: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, req)
 	resp, err := api.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("chargeApi.DoCreateOrder: %w", err)
@@ -193,6 +249,7 @@ func (api *chargeApiImpl) DoQueryOrder(ctx context.Context, ids []string) ([]sin
 		return nil, fmt.Errorf("create query httpReq fail: %w", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, httpReq)
 
 	resp, err := api.httpClient.Do(httpReq)
 	if err != nil {
@@ -209,7 +266,7 @@ func (api *chargeApiImpl) DoQueryOrder(ctx context.Context, ids []string) ([]sin
 	if err := json.NewDecoder(resp.Body).Decode(&chargeResp); err != nil {
 		return nil, fmt.Errorf("decode charge query resp fail: %w", err)
 	}
-	fmt.Printf("[DoQueryOrder] query upstream resp: %+v\n", chargeResp)
+	slog.DebugContext(ctx, "charge query response", "resp", chargeResp)
 
 	// 5) 根据第三方返回的字段设置订单状态 / 数据
 	if chargeResp.Code != 200 {
@@ -7,13 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"10000hk.com/vip_gift/internal/service"
 	"10000hk.com/vip_gift/internal/sink"
 	"10000hk.com/vip_gift/internal/types"
+	"10000hk.com/vip_gift/pkg"
 )
 
 type giftApiImpl struct {
@@ -21,16 +22,27 @@ type giftApiImpl struct {
 	pub         service.PubService
 	order       service.OrderService
 	httpClient  *http.Client
+	queryOnly   bool // 只刷新状态, 查单时不继续故障转移下发
 }
 
 func NewGiftApi(upstreamURL map[string]string, pubSvc service.PubService, orderSvc service.OrderService) types.OrderApi {
+	return instrument(types.CatalogGift, newGiftApi(upstreamURL, pubSvc, orderSvc))
+}
+
+// NewGiftQueryApi 供 HTTP 查单接口使用: 只查询并刷新状态, 不会向上游下发新的订单;
+// 故障转移的后续下发只在消费者 / 查单调度里进行
+func NewGiftQueryApi(upstreamURL map[string]string, pubSvc service.PubService, orderSvc service.OrderService) types.OrderApi {
+	api := newGiftApi(upstreamURL, pubSvc, orderSvc)
+	api.queryOnly = true
+	return instrument(types.CatalogGift, api)
+}
+
+func newGiftApi(upstreamURL map[string]string, pubSvc service.PubService, orderSvc service.OrderService) *giftApiImpl {
 	return &giftApiImpl{
 		upstreamURL: upstreamURL,
-		httpClient: &http.Client{
-			Timeout: 5 * time.Second,
-		},
-		pub:   pubSvc,
-		order: orderSvc,
+		httpClient:  upstreamClient(types.CatalogGift, 5*time.Second),
+		pub:         pubSvc,
+		order:       orderSvc,
 	}
 }
 
@@ -43,13 +55,17 @@ func (api *giftApiImpl) ToOrderDto(ctx context.Context, ent sink.OrderCreateReq)
 	// 正式版本应该是转变成公钥加密的数据
 	var downstreamOrderId string = ent.DownstreamOrderId
 	if downstreamOrderId == "" {
-		return types.OrderDTO{}, fmt.Errorf("ToOrderDto: downstreamOrderId is required")
+		return types.OrderDTO{}, types.NewValidationError("downstreamOrderId is required")
 	}
 	// 检查publicCode 对应的产品有没有
 	pubCode := ent.PublicCode
 	pub, err := api.pub.GetByPublicCode(pubCode)
 	if err != nil {
-		log.Printf("[ToOrderDto] GetByPublicCode error: %v\n", err)
+		slog.WarnContext(ctx, "GetByPublicCode failed", "publicCode", pubCode, "error", err)
+		return types.OrderDTO{}, err
+	}
+	// 不在上架时间窗内的产品拒绝下单
+	if err := api.pub.CheckAvailable(pubCode, time.Now()); err != nil {
 		return types.OrderDTO{}, err
 	}
 
@@ -69,6 +85,10 @@ func (api *giftApiImpl) ToOrderDto(ctx context.Context, ent sink.OrderCreateReq)
 		PublicCode:        pubCode,
 		CommissionSelf:    pub.CommissionMF * 0.80,
 		CommissionParent:  pub.CommissionMF * 0.20,
+		SalePrice:         pub.SalePrice,
+		ParValue:          pub.ParValue,
+		CommissionMF:      pub.CommissionMF,
+		Phone:             ent.Phone,
 	}
 	return dto, nil
 }
@@ -81,39 +101,164 @@ func (api *giftApiImpl) DoCreateOrder(ctx context.Context, dto *types.OrderDTO)
 		return nil, err
 	}
 	pubCode := bizReq.Body.PublicCode
-
-	bizReqMap := map[string]any{}
-
 	if pubCode == "" {
 		return nil, errors.New("publicCode is required")
 	}
-	productId, err := api.pub.GetGncOriginDataByPublicCode(pubCode)
+
+	// 按组合策略得到下发顺序
+	plan, err := api.pub.PlanFulfillment(ctx, pubCode)
 	if err != nil {
-		// 没有gncOriginData, 尝试原来的逻辑
-		log.Printf("[DoCreateOrder] GetGncOriginDataByPublicCode error: %v\n", err)
-		baseCodes, err := api.pub.GetBaseCodesByPublicCode(pubCode)
-		if err != nil {
+		slog.ErrorContext(ctx, "PlanFulfillment failed", "publicCode", pubCode, "error", err)
+		return nil, err
+	}
+	slog.InfoContext(ctx, "fulfillment planned", "publicCode", pubCode, "strategy", plan.Strategy, "targets", len(plan.Targets))
+
+	// all: 组合订单, 每个组合一条子单
+	if plan.Strategy == types.StrategyAll {
+		return api.createBundleOrder(ctx, dto, bizReq.Body, plan)
+	}
+
+	// failover / cheapest / weighted: 依次尝试; 暂存后重试时接着已有的尝试继续
+	attempts, err := api.order.ListOrderItems(ctx, dto.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	return api.runFailover(ctx, dto.OrderId, dto.DownstreamOrderId, bizReq.Body, plan.Targets, attempts)
+}
+
+// errAttemptFailed 上游明确拒绝(业务失败 / 4xx), 可以换下一个 base;
+// 网络错误、超时、5xx 时上游可能已受理, 不能据此换 base, 由查单确认
+var errAttemptFailed = errors.New("upstream confirmed failure")
+
+// runFailover 逐个 base 下发, 每次尝试一个新的上游订单号(downstreamOrderId-序号)并先落库;
+// 只有上一次尝试确认失败才换下一个, 结果未知的保持进行中, 由 queryFailoverOrder 查单后继续
+func (api *giftApiImpl) runFailover(ctx context.Context, orderId, downstreamOrderId string, body sink.OrderCreateReq, targets []types.FulfillmentTarget, attempts []types.OrderItemEntity) (*sink.OrderCreateResp, error) {
+	// 1) 已有尝试: 已发出且未确认失败的不再换; 未发出的(熔断拒绝)复用订单号
+	failed := make(map[string]bool, len(attempts))
+	var unsent *types.OrderItemEntity
+	for i := range attempts {
+		a := &attempts[i]
+		switch {
+		case !a.Sent && a.Status == types.StatusInit:
+			unsent = a
+		case a.Status == types.StatusSuccess, !a.Status.IsFinal():
+			return failoverResp(orderId, attempts), nil
+		default:
+			failed[a.BaseCode] = true
+		}
+	}
+
+	// 2) 依次下发尚未失败的 base
+	var lastErr error
+	for _, target := range targets {
+		if failed[target.BaseCode] {
+			continue
+		}
+		item := unsent
+		if item == nil || item.BaseCode != target.BaseCode {
+			attempts = append(attempts, types.OrderItemEntity{
+				OrderId:         orderId,
+				CustomerOrderNo: fmt.Sprintf("%s-%d", downstreamOrderId, len(attempts)+1),
+				BaseCode:        target.BaseCode,
+				ProductId:       target.ProductId,
+				Source:          target.Source,
+				Status:          types.StatusInit,
+				Failover:        true,
+			})
+			item = &attempts[len(attempts)-1]
+			if err := api.order.CreateOrderItems(ctx, attempts[len(attempts)-1:]); err != nil {
+				return nil, err
+			}
+		}
+		unsent = nil
+
+		if err := api.markSent(ctx, item); err != nil {
 			return nil, err
 		}
-		if len(baseCodes) == 0 {
-			log.Printf("[DoCreateOrder] no baseCode found for publicCode=%s\n", pubCode)
-			return nil, fmt.Errorf("publicCode=%s not found", pubCode)
+		resp, err := api.submitTarget(ctx, body, target, item.CustomerOrderNo)
+		switch {
+		case types.IsUpstreamRejected(err):
+			// 没有发出, 尝试回到 init; 同一上游换 base 也会被熔断 / 并发上限挡住
+			api.markUnsent(ctx, item, err)
+			return nil, err
+		case errors.Is(err, errAttemptFailed):
+			slog.WarnContext(ctx, "base failed, try next", "baseCode", target.BaseCode, "customerOrderNo", item.CustomerOrderNo, "error", err)
+			item.Status = types.StatusUpstreamFail
+			item.Remark = err.Error()
+			lastErr = err
+		case err != nil:
+			slog.WarnContext(ctx, "submit result unknown, wait for query", "baseCode", target.BaseCode, "customerOrderNo", item.CustomerOrderNo, "error", err)
+			item.Status = types.StatusPending
+			item.Remark = fmt.Sprintf("submit result unknown: %v", err)
+		default:
+			slog.InfoContext(ctx, "order submitted", "baseCode", target.BaseCode, "customerOrderNo", item.CustomerOrderNo)
+			item.Status = types.StatusPending
+			item.Remark = item.Status.Remark()
 		}
-		bizReqMap["publicCode"] = baseCodes[0]
-		bizReqMap["source"] = "VIP_GIFT"
-		log.Printf("[DoCreateOrder] got baseCode=%s for publicCode=%s\n", baseCodes[0], pubCode)
-	} else {
-		// 有gncOriginData, 直接用
-		log.Printf("[DoCreateOrder] GetGncOriginDataByPublicCode success: %s\n", productId)
-		bizReq.Body.ProductId = productId
-		bizReq.Body.CustomerOrderNo = dto.DownstreamOrderId
-		bizReqMap["publicCode"] = productId
+		if err := api.order.UpdateOrderItem(ctx, item); err != nil {
+			slog.ErrorContext(ctx, "update failover attempt failed", "customerOrderNo", item.CustomerOrderNo, "error", err)
+		}
+		if item.Status == types.StatusPending {
+			if resp == nil {
+				resp = failoverResp(orderId, attempts)
+			}
+			return resp, nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no base left to try")
+	}
+	return nil, fmt.Errorf("all %d compositions failed, last error: %w", len(targets), lastErr)
+}
+
+// markSent 下发前先落库为已发出: 请求途中进程退出时该尝试按结果未知处理,
+// 不会用同一订单号重发, 也不会在上游可能已受理时换下一个 base
+func (api *giftApiImpl) markSent(ctx context.Context, item *types.OrderItemEntity) error {
+	item.Sent = true
+	item.Status = types.StatusPending
+	item.Remark = "submitting"
+	return api.order.UpdateOrderItem(ctx, item)
+}
+
+// markUnsent 熔断 / 并发隔离拒绝时请求没有发出, 恢复为未发出, 重试时复用订单号
+func (api *giftApiImpl) markUnsent(ctx context.Context, item *types.OrderItemEntity, cause error) {
+	item.Sent = false
+	item.Status = types.StatusInit
+	item.Remark = cause.Error()
+	if err := api.order.UpdateOrderItem(ctx, item); err != nil {
+		slog.ErrorContext(ctx, "update order item failed", "customerOrderNo", item.CustomerOrderNo, "error", err)
+	}
+}
+
+func failoverResp(orderId string, attempts []types.OrderItemEntity) *sink.OrderCreateResp {
+	status := types.FailoverStatus(attempts)
+	return &sink.OrderCreateResp{
+		OrderId:    orderId,
+		Status:     int64(status),
+		StatusText: status.String(),
+		Message:    status.Remark(),
+	}
+}
 
-		bizReqMap["source"] = "VIP_FULU"
+// submitTarget 把订单下发到某个具体的 base; 上游明确拒绝时返回的 error 包含 errAttemptFailed
+func (api *giftApiImpl) submitTarget(ctx context.Context, body sink.OrderCreateReq, target types.FulfillmentTarget, customerOrderNo string) (*sink.OrderCreateResp, error) {
+	bizReqMap := map[string]any{}
+	if target.ProductId != "" {
+		// 有gncOriginData, 直接用
+		body.ProductId = target.ProductId
 	}
-	log.Printf("[DoCreateOrder] bizReqMap: %+v\n", bizReqMap)
-	bodyBytes, _ := json.Marshal(bizReq.Body)
+	// 每次下发(组合子单 / 故障转移的每次尝试)都是上游的一笔新订单, 订单号不能重复
+	body.CustomerOrderNo = customerOrderNo
+	body.DownstreamOrderId = customerOrderNo
+	bodyBytes, _ := json.Marshal(body)
 	json.Unmarshal(bodyBytes, &bizReqMap)
+	if target.ProductId != "" {
+		bizReqMap["publicCode"] = target.ProductId
+	} else {
+		bizReqMap["publicCode"] = target.BaseCode
+	}
+	bizReqMap["source"] = target.Source
+	slog.DebugContext(ctx, "submit order upstream", "baseCode", target.BaseCode, "body", bizReqMap)
 
 	// 最终请求体
 	reqBytes, _ := json.Marshal(bizReqMap)
@@ -125,6 +270,7 @@ func (api *giftApiImpl) DoCreateOrder(ctx context.Context, dto *types.OrderDTO)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, req)
 	resp, err := api.httpClient.Do(req)
 	if err != nil {
 		return nil, err
@@ -133,6 +279,9 @@ func (api *giftApiImpl) DoCreateOrder(ctx context.Context, dto *types.OrderDTO)
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode < http.StatusInternalServerError {
+			return nil, fmt.Errorf("%w: upstream status=%d body=%s", errAttemptFailed, resp.StatusCode, string(b))
+		}
 		return nil, fmt.Errorf("upstream status=%d body=%s", resp.StatusCode, string(b))
 	}
 
@@ -140,8 +289,88 @@ func (api *giftApiImpl) DoCreateOrder(ctx context.Context, dto *types.OrderDTO)
 	if err := json.NewDecoder(resp.Body).Decode(&createResp); err != nil {
 		return nil, err
 	}
+	status := types.OrderStatus(createResp.Status)
+	if status == types.StatusUpstreamFail || status == types.StatusDownstreamFail {
+		return nil, fmt.Errorf("%w: upstream rejected base %s: %s", errAttemptFailed, target.BaseCode, createResp.Message)
+	}
 	return &createResp, nil
 }
+
+// createBundleOrder 组合订单: 先落子单, 再逐个下发, 返回汇总后的状态;
+// 不可下发的 base 直接落失败子单. 只有全部子单下发失败才返回 error;
+// 熔断 / 并发隔离拒绝时子单保持未发出并返回该错误, 整单暂存, 重试时接着已有子单继续
+func (api *giftApiImpl) createBundleOrder(ctx context.Context, dto *types.OrderDTO, body sink.OrderCreateReq, plan *types.FulfillmentPlan) (*sink.OrderCreateResp, error) {
+	items, err := api.order.ListOrderItems(ctx, dto.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		items = make([]types.OrderItemEntity, len(plan.Targets))
+		for i, target := range plan.Targets {
+			items[i] = types.OrderItemEntity{
+				OrderId:         dto.OrderId,
+				CustomerOrderNo: fmt.Sprintf("%s-%d", dto.DownstreamOrderId, i+1),
+				BaseCode:        target.BaseCode,
+				ProductId:       target.ProductId,
+				Source:          target.Source,
+				Status:          types.StatusInit,
+			}
+			if target.Unavailable != "" {
+				items[i].Status = types.StatusUpstreamFail
+				items[i].Remark = target.Unavailable
+			}
+		}
+		if err := api.order.CreateOrderItems(ctx, items); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range items {
+		item := &items[i]
+		if item.Sent || item.Status != types.StatusInit {
+			continue
+		}
+		target := types.FulfillmentTarget{BaseCode: item.BaseCode, ProductId: item.ProductId, Source: item.Source}
+		if err := api.markSent(ctx, item); err != nil {
+			return nil, err
+		}
+		_, err := api.submitTarget(ctx, body, target, item.CustomerOrderNo)
+		if types.IsUpstreamRejected(err) {
+			// 没有发出, 子单回到 init; 其余子单走同一上游也会被拒绝
+			api.markUnsent(ctx, item, err)
+			return nil, err
+		}
+		switch {
+		case err == nil:
+			item.Status = types.StatusPending
+			item.Remark = item.Status.Remark()
+		case errors.Is(err, errAttemptFailed):
+			slog.WarnContext(ctx, "bundle item failed", "customerOrderNo", item.CustomerOrderNo, "error", err)
+			item.Status = types.StatusUpstreamFail
+			item.Remark = err.Error()
+		default:
+			// 结果未知, 上游可能已受理, 由查单确认
+			slog.WarnContext(ctx, "bundle item result unknown, wait for query", "customerOrderNo", item.CustomerOrderNo, "error", err)
+			item.Status = types.StatusPending
+			item.Remark = fmt.Sprintf("submit result unknown: %v", err)
+		}
+		if err := api.order.UpdateOrderItem(ctx, item); err != nil {
+			slog.ErrorContext(ctx, "update bundle item failed", "customerOrderNo", item.CustomerOrderNo, "error", err)
+		}
+	}
+
+	status := types.RollupStatus(items)
+	if status == types.StatusUpstreamFail {
+		return nil, fmt.Errorf("all %d bundle items failed", len(items))
+	}
+	return &sink.OrderCreateResp{
+		OrderId:    dto.OrderId,
+		Status:     int64(status),
+		StatusText: status.String(),
+		Message:    status.Remark(),
+	}, nil
+}
+
 func (api *giftApiImpl) DoQueryOrder(ctx context.Context, ids []string) ([]sink.OrderQueryResp, error) {
 
 	var orderResults []sink.OrderQueryResp
@@ -149,14 +378,32 @@ func (api *giftApiImpl) DoQueryOrder(ctx context.Context, ids []string) ([]sink.
 		if downloadOrderId == "" {
 			continue
 		}
-		resp, err := api.queryOrderByDownstreamOrderId(ctx, downloadOrderId)
+		ctx := pkg.WithOrderIDs(ctx, "", downloadOrderId)
+		order, err := api.order.GetOrderByDownstreamOrderId(ctx, downloadOrderId)
 		if err != nil {
-			log.Printf("[DoQueryOrder] queryOrderByDownstreamOrderId error: %v\n", err)
+			slog.WarnContext(ctx, "order not found, skipped", "error", err)
 			continue
 		}
-		order, err := api.order.GetOrderByDownstreamOrderId(ctx, downloadOrderId)
+		ctx = pkg.WithOrderIDs(ctx, order.OrderId, "")
+
+		// 组合订单: 逐个查询子单后汇总
+		items, err := api.order.ListOrderItems(ctx, order.OrderId)
 		if err != nil {
-			log.Printf("[DoQueryOrder] GetOrderByDownstreamOrderId error, 订单没有找到. 跳过这个订单: %v\n", err)
+			slog.ErrorContext(ctx, "ListOrderItems failed", "error", err)
+			continue
+		}
+		if len(items) > 0 && items[0].Failover {
+			orderResults = append(orderResults, api.queryFailoverOrder(ctx, order, items))
+			continue
+		}
+		if len(items) > 0 {
+			orderResults = append(orderResults, api.queryBundleOrder(ctx, order, items))
+			continue
+		}
+
+		resp, err := api.queryUpstream(ctx, downloadOrderId)
+		if err != nil {
+			slog.ErrorContext(ctx, "query upstream failed", "error", err)
 			continue
 		}
 		resp.OrderId = order.OrderId
@@ -165,14 +412,131 @@ func (api *giftApiImpl) DoQueryOrder(ctx context.Context, ids []string) ([]sink.
 	return orderResults, nil
 }
 
-// queryOrderByDownstreamOrderId 查询订单
-func (api *giftApiImpl) queryOrderByDownstreamOrderId(ctx context.Context, id string) (*sink.OrderQueryResp, error) {
+// queryBundleOrder 查询尚未终态的子单并更新, 返回汇总后的父订单结果
+func (api *giftApiImpl) queryBundleOrder(ctx context.Context, order *types.OrderEntity, items []types.OrderItemEntity) sink.OrderQueryResp {
+	for i := range items {
+		item := &items[i]
+		if !item.Sent || item.Status.IsFinal() {
+			continue
+		}
+		resp, err := api.queryUpstream(ctx, item.CustomerOrderNo)
+		if err != nil {
+			slog.ErrorContext(ctx, "query bundle item failed", "customerOrderNo", item.CustomerOrderNo, "error", err)
+			continue
+		}
+		item.Status = types.OrderStatus(resp.Status)
+		item.Remark = resp.Remark
+		item.DataJSON = resp.DataJSON
+		if err := api.order.UpdateOrderItem(ctx, item); err != nil {
+			slog.ErrorContext(ctx, "update bundle item failed", "customerOrderNo", item.CustomerOrderNo, "error", err)
+		}
+	}
+
+	status := types.RollupStatus(items)
+	itemDTOs := make([]types.OrderItemDTO, len(items))
+	for i := range items {
+		itemDTOs[i].FromEntity(&items[i])
+	}
+	dataJSON, _ := json.Marshal(itemDTOs)
+	return sink.OrderQueryResp{
+		OrderId:           order.OrderId,
+		DownstreamOrderId: order.DownstreamOrderId,
+		DataJSON:          string(dataJSON),
+		Status:            int64(status),
+		StatusText:        status.String(),
+		Remark:            status.Remark(),
+	}
+}
+
+// queryFailoverOrder 查询最后一次尝试; 确认失败后接着向下一个 base 下发, 父订单状态取最后一次尝试
+func (api *giftApiImpl) queryFailoverOrder(ctx context.Context, order *types.OrderEntity, attempts []types.OrderItemEntity) sink.OrderQueryResp {
+	// 1) 查询最后一次已发出、未终态的尝试
+	last := &attempts[len(attempts)-1]
+	if last.Sent && !last.Status.IsFinal() {
+		resp, err := api.queryUpstream(ctx, last.CustomerOrderNo)
+		if err != nil {
+			slog.ErrorContext(ctx, "query failover attempt failed", "customerOrderNo", last.CustomerOrderNo, "error", err)
+		} else {
+			last.Status = types.OrderStatus(resp.Status)
+			last.Remark = resp.Remark
+			last.DataJSON = resp.DataJSON
+			if err := api.order.UpdateOrderItem(ctx, last); err != nil {
+				slog.ErrorContext(ctx, "update failover attempt failed", "customerOrderNo", last.CustomerOrderNo, "error", err)
+			}
+		}
+	}
+
+	// 2) 确认失败 / 尚未发出 => 继续下发; 全部失败时最后一次尝试即为失败
+	status := types.FailoverStatus(attempts)
+	if !last.Sent || (last.Status.IsFinal() && last.Status != types.StatusSuccess) {
+		switch {
+		case api.queryOnly:
+			// 只读查询不下发; 还有可换的 base 时由查单调度继续, 此时仍是处理中
+			if api.hasUntriedBase(ctx, order, attempts) {
+				status = types.StatusPending
+			}
+		default:
+			if err := api.continueFailover(ctx, order, attempts); err != nil {
+				slog.WarnContext(ctx, "failover not continued", "error", err)
+			}
+			if reloaded, err := api.order.ListOrderItems(ctx, order.OrderId); err == nil && len(reloaded) > 0 {
+				attempts = reloaded
+			}
+			status = types.FailoverStatus(attempts)
+		}
+	}
+
+	itemDTOs := make([]types.OrderItemDTO, len(attempts))
+	for i := range attempts {
+		itemDTOs[i].FromEntity(&attempts[i])
+	}
+	dataJSON, _ := json.Marshal(itemDTOs)
+	return sink.OrderQueryResp{
+		OrderId:           order.OrderId,
+		DownstreamOrderId: order.DownstreamOrderId,
+		DataJSON:          string(dataJSON),
+		Status:            int64(status),
+		StatusText:        status.String(),
+		Remark:            status.Remark(),
+	}
+}
 
-	order, err := api.order.GetOrderByDownstreamOrderId(ctx, id)
+// hasUntriedBase 重新规划后是否还有没失败过的 base; 规划失败时按没有处理
+func (api *giftApiImpl) hasUntriedBase(ctx context.Context, order *types.OrderEntity, attempts []types.OrderItemEntity) bool {
+	plan, err := api.pub.PlanFulfillment(ctx, order.PublicCode)
 	if err != nil {
-		return nil, err
+		return false
+	}
+	failed := make(map[string]bool, len(attempts))
+	for _, a := range attempts {
+		if a.Sent && a.Status.IsFinal() {
+			failed[a.BaseCode] = true
+		}
+	}
+	for _, target := range plan.Targets {
+		if !failed[target.BaseCode] {
+			return true
+		}
 	}
+	return false
+}
 
+// continueFailover 按下单时的请求体重新规划, 跳过已失败的 base 后继续下发
+func (api *giftApiImpl) continueFailover(ctx context.Context, order *types.OrderEntity, attempts []types.OrderItemEntity) error {
+	var bizReq sink.BizDataJSON[sink.OrderCreateReq]
+	if err := json.Unmarshal([]byte(order.DataJSON), &bizReq); err != nil {
+		return err
+	}
+	plan, err := api.pub.PlanFulfillment(ctx, order.PublicCode)
+	if err != nil {
+		return err
+	}
+	_, err = api.runFailover(ctx, order.OrderId, order.DownstreamOrderId, bizReq.Body, plan.Targets, attempts)
+	return err
+}
+
+// queryUpstream 按下发给上游的订单号查询状态
+func (api *giftApiImpl) queryUpstream(ctx context.Context, id string) (*sink.OrderQueryResp, error) {
 	queryParam := map[string]any{
 		"orderId": id,
 	}
@@ -188,6 +552,7 @@ func (api *giftApiImpl) queryOrderByDownstreamOrderId(ctx context.Context, id st
 		return nil, fmt.Errorf("create query httpReq fail: %w", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, httpReq)
 
 	resp, err := api.httpClient.Do(httpReq)
 	if err != nil {
@@ -210,12 +575,12 @@ func (api *giftApiImpl) queryOrderByDownstreamOrderId(ctx context.Context, id st
 	if err := json.NewDecoder(resp.Body).Decode(&fuluResp); err != nil {
 		return nil, fmt.Errorf("decode fulu query resp fail: %w", err)
 	}
-	fmt.Printf("[fulu_api_DoQuery] fuluResp: %+v", fuluResp)
+	slog.DebugContext(ctx, "fulu query response", "resp", fuluResp)
 
 	// 1. 将 fuluResp.Data 断言成 map[string]interface{}
 	dataMap, ok := fuluResp.Data.(map[string]interface{})
 	if !ok {
-		log.Printf("[fulu_api_DoQuery] fuluResp.Data is not a map, got %T", fuluResp.Data)
+		slog.WarnContext(ctx, "fulu query data is not a map", "type", fmt.Sprintf("%T", fuluResp.Data))
 		dataMap = make(map[string]interface{})
 		dataMap["orderStatus"] = 0 // 默认状态
 	}
@@ -254,8 +619,8 @@ func (api *giftApiImpl) queryOrderByDownstreamOrderId(ctx context.Context, id st
 	}
 
 	// 准备返回给上层的查询结果（这里仅示范把整个 data 原样塞进去）
+	// OrderId 由调用方回填
 	return &sink.OrderQueryResp{
-		OrderId:           order.OrderId,
 		DownstreamOrderId: id,
 		DataJSON:          string(dataJSON),
 		Status:            int64(fuluStatus.ToOrderStatus()),
@@ -322,7 +687,8 @@ func (f *FuluOrderStatus) UnmarshalJSON(data []byte) error {
 func (f FuluOrderStatus) ToOrderStatus() types.OrderStatus {
 	switch f {
 	case FuluOrderStatusWaitOrder:
-		return types.StatusInit // 10 -> 0 (init)
+		// 查得到说明上游已受理, 按处理中继续查单; init 只表示尚未发出
+		return types.StatusPending // 10 -> 100
 	case FuluOrderStatusOrdering:
 		return types.StatusPending // 20 -> 100 (init)，也可以考虑单独状态
 	case FuluOrderStatusOrderSuccess:
@@ -334,7 +700,7 @@ func (f FuluOrderStatus) ToOrderStatus() types.OrderStatus {
 		// 或者你要再单独定义一个 OrderStatus = 600 "Suspicious" 也可以
 		return types.StatusDownstreamFail // 50 -> 400 (示例)
 	default:
-		// 未知状态时，可考虑给默认值或报错，这里先给 init
-		return types.StatusInit
+		// 未知状态 / 无法解析时不能判定结果, 按处理中继续查单
+		return types.StatusPending
 	}
 }
@@ -0,0 +1,15 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"10000hk.com/vip_gift/pkg"
+)
+
+// setRequestID 把关联 ID 透传给上游, 便于对账时按同一个 ID 查两边日志
+func setRequestID(ctx context.Context, req *http.Request) {
+	if id := pkg.RequestID(ctx); id != "" {
+		req.Header.Set(pkg.HeaderRequestID, id)
+	}
+}
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+// UpstreamGuard 单个上游 provider 的熔断器 + 并发隔离(bulkhead).
+// 连续失败 FailureThreshold 次后熔断, OpenSeconds 后进入半开, 只放一个探测请求, 成功即恢复;
+// 同时在途请求超过 MaxConcurrent 时最多排队 MaxWait, 仍拿不到名额直接拒绝, 不占用上游的超时时间
+type UpstreamGuard struct {
+	provider string
+	cfg      types.UpstreamGuardConfig
+	slots    chan struct{}
+
+	mu               sync.Mutex
+	state            types.BreakerState
+	failures         int
+	openedAt         time.Time
+	probing          bool // 半开状态下探测请求是否在途
+	rejectedOpen     int64
+	rejectedBulkhead int64
+}
+
+func newUpstreamGuard(provider string, cfg types.UpstreamGuardConfig) *UpstreamGuard {
+	return &UpstreamGuard{
+		provider: provider,
+		cfg:      cfg,
+		slots:    make(chan struct{}, cfg.MaxConcurrent),
+		state:    types.BreakerClosed,
+	}
+}
+
+// Allow 只检查熔断状态、不占名额, 用于下单入口/调用前快速失败
+func (g *UpstreamGuard) Allow() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.allowLocked()
+}
+
+// allowLocked 熔断打开、或半开时探测请求在途 => 拒绝; 熔断拒绝只在这里计数
+func (g *UpstreamGuard) allowLocked() error {
+	g.refreshLocked(time.Now())
+	if g.state == types.BreakerOpen || (g.state == types.BreakerHalfOpen && g.probing) {
+		g.rejectedOpen++
+		return g.openErrLocked()
+	}
+	return nil
+}
+
+// acquire 占一个并发名额并通过熔断检查; 返回的 done 必须调用, 传入本次请求是否成功
+func (g *UpstreamGuard) acquire(req *http.Request) (func(success bool), error) {
+	// 1) 熔断
+	g.mu.Lock()
+	if err := g.allowLocked(); err != nil {
+		g.mu.Unlock()
+		return nil, err
+	}
+	probe := g.state == types.BreakerHalfOpen
+	if probe {
+		g.probing = true
+	}
+	g.mu.Unlock()
+
+	// 2) 并发名额
+	if err := g.takeSlot(req); err != nil {
+		g.mu.Lock()
+		g.rejectedBulkhead++
+		if probe {
+			g.probing = false
+		}
+		g.mu.Unlock()
+		return nil, err
+	}
+
+	return func(success bool) {
+		<-g.slots
+		g.record(success, probe)
+	}, nil
+}
+
+func (g *UpstreamGuard) takeSlot(req *http.Request) error {
+	select {
+	case g.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(g.cfg.MaxWait())
+	defer timer.Stop()
+	select {
+	case g.slots <- struct{}{}:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-timer.C:
+		return fmt.Errorf("%w: %s has %d requests in flight", types.ErrBulkheadFull, g.provider, g.cfg.MaxConcurrent)
+	}
+}
+
+// record 请求结束后更新熔断状态
+func (g *UpstreamGuard) record(success, probe bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if probe {
+		g.probing = false
+	}
+	if success {
+		g.failures = 0
+		g.state = types.BreakerClosed
+		return
+	}
+	g.failures++
+	if probe || g.failures >= g.cfg.FailureThreshold {
+		g.state = types.BreakerOpen
+		g.openedAt = time.Now()
+	}
+}
+
+// refreshLocked 熔断到期 => 半开
+func (g *UpstreamGuard) refreshLocked(now time.Time) {
+	if g.state == types.BreakerOpen && now.Sub(g.openedAt) >= g.cfg.OpenDuration() {
+		g.state = types.BreakerHalfOpen
+		g.probing = false
+	}
+}
+
+func (g *UpstreamGuard) openErrLocked() error {
+	retryAt := g.openedAt.Add(g.cfg.OpenDuration())
+	return fmt.Errorf("%w: %s, retry after %s", types.ErrCircuitOpen, g.provider, retryAt.Format(time.RFC3339))
+}
+
+// Snapshot 当前状态, 供管理接口展示
+func (g *UpstreamGuard) Snapshot() types.UpstreamGuardSnapshot {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.refreshLocked(time.Now())
+	snap := types.UpstreamGuardSnapshot{
+		Provider:            g.provider,
+		State:               g.state,
+		ConsecutiveFailures: g.failures,
+		InFlight:            len(g.slots),
+		RejectedOpen:        g.rejectedOpen,
+		RejectedBulkhead:    g.rejectedBulkhead,
+		Config:              g.cfg,
+	}
+	if g.state != types.BreakerClosed {
+		openedAt := g.openedAt
+		retryAt := openedAt.Add(g.cfg.OpenDuration())
+		snap.OpenedAt, snap.RetryAt = &openedAt, &retryAt
+	}
+	return snap
+}
+
+// guardedTransport 经 UpstreamGuard 发请求: 网络错误与 5xx 计为失败, 4xx 是请求本身的问题, 不计入
+type guardedTransport struct {
+	guard *UpstreamGuard
+	next  http.RoundTripper
+}
+
+func (t *guardedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	done, err := t.guard.acquire(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	done(err == nil && resp.StatusCode < http.StatusInternalServerError)
+	return resp, err
+}
+
+// ========== 按 provider 的注册表 ==========
+
+var (
+	guardsMu    sync.Mutex
+	guards      = map[string]*UpstreamGuard{}
+	guardConfig = types.DefaultUpstreamGuardConfig
+)
+
+// ConfigureUpstreamGuards 启动时设置熔断/并发参数, 需在第一次调用上游之前;
+// providers 预先创建, 尚未调用过上游时管理接口也能看到
+func ConfigureUpstreamGuards(cfg types.UpstreamGuardConfig, providers ...string) {
+	guardsMu.Lock()
+	defer guardsMu.Unlock()
+	guardConfig = cfg
+	guards = map[string]*UpstreamGuard{}
+	for _, p := range providers {
+		guards[p] = newUpstreamGuard(p, cfg)
+	}
+}
+
+// Guard provider(gift / charge) 对应的 UpstreamGuard; 各 NewXxxApi 每次新建,
+// 熔断状态与并发名额必须进程内共享, 所以放在注册表里
+func Guard(provider string) *UpstreamGuard {
+	guardsMu.Lock()
+	defer guardsMu.Unlock()
+	g, ok := guards[provider]
+	if !ok {
+		g = newUpstreamGuard(provider, guardConfig)
+		guards[provider] = g
+	}
+	return g
+}
+
+// UpstreamGuardSnapshots 全部 provider 的状态, 按 provider 排序
+func UpstreamGuardSnapshots() []types.UpstreamGuardSnapshot {
+	guardsMu.Lock()
+	list := make([]*UpstreamGuard, 0, len(guards))
+	for _, g := range guards {
+		list = append(list, g)
+	}
+	guardsMu.Unlock()
+
+	snaps := make([]types.UpstreamGuardSnapshot, len(list))
+	for i, g := range list {
+		snaps[i] = g.Snapshot()
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Provider < snaps[j].Provider })
+	return snaps
+}
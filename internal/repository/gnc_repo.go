@@ -2,6 +2,8 @@
 package repository
 
 import (
+	"time"
+
 	"10000hk.com/vip_gift/internal/types"
 	"gorm.io/gorm"
 )
@@ -12,6 +14,23 @@ type GncRepo interface {
 	UpdateGnc(ent *types.GncEntity) error
 	DeleteGncByBaseCode(baseCode string) error
 	ListGnc(page, size int64) ([]types.GncEntity, int64, error) // 分页需求
+	ListGncByBaseCodes(baseCodes []string) ([]types.GncEntity, error)
+
+	// 远程同步 (gnc_sync_repo.go)
+	CreateSyncRun(run *types.GncSyncRunEntity) error
+	SaveSyncRun(run *types.GncSyncRunEntity) error
+	ListSyncRuns(page, size int64) ([]types.GncSyncRunEntity, int64, error)
+	ListSyncedGnc(source string) ([]types.GncEntity, error)
+	MarkGncSynced(baseCode, source string, at time.Time) error
+	ListGncBySource(source string, baseCodes []string) ([]types.GncEntity, error)
+
+	// 回收站 (gnc_trash_repo.go)
+	ListTrashedGnc(page, size int64) ([]types.GncEntity, int64, error)
+	GetTrashedGnc(baseCode string) (*types.GncEntity, error)
+	RestoreGnc(baseCode string) error
+	PurgeGnc(baseCode string) error
+	CountOrdersSinceByBaseCode(baseCode string, since time.Time) (int64, error)
+	CountPubsByBaseCode(baseCode string) (int64, error)
 }
 
 type gncRepoImpl struct {
@@ -39,6 +58,7 @@ func (r *gncRepoImpl) UpdateGnc(ent *types.GncEntity) error {
 	return r.db.Save(ent).Error
 }
 
+// DeleteGncByBaseCode 软删除, 永久删除见 PurgeGnc
 func (r *gncRepoImpl) DeleteGncByBaseCode(baseCode string) error {
 	return r.db.Where("base_code = ?", baseCode).Delete(&types.GncEntity{}).Error
 }
@@ -65,3 +85,13 @@ func (r *gncRepoImpl) ListGnc(page, size int64) ([]types.GncEntity, int64, error
 
 	return list, total, nil
 }
+
+// ListGncByBaseCodes 按 baseCode 批量查询, 不存在的 baseCode 直接缺省
+func (r *gncRepoImpl) ListGncByBaseCodes(baseCodes []string) ([]types.GncEntity, error) {
+	var list []types.GncEntity
+	if len(baseCodes) == 0 {
+		return list, nil
+	}
+	err := r.db.Where("base_code IN ?", baseCodes).Find(&list).Error
+	return list, err
+}
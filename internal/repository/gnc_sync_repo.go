@@ -0,0 +1,59 @@
+// internal/repository/gnc_sync_repo.go
+package repository
+
+import (
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+func (r *gncRepoImpl) CreateSyncRun(run *types.GncSyncRunEntity) error {
+	return r.db.Create(run).Error
+}
+
+func (r *gncRepoImpl) SaveSyncRun(run *types.GncSyncRunEntity) error {
+	return r.db.Save(run).Error
+}
+
+// ListSyncRuns 按时间倒序分页
+func (r *gncRepoImpl) ListSyncRuns(page, size int64) ([]types.GncSyncRunEntity, int64, error) {
+	var list []types.GncSyncRunEntity
+	var total int64
+	tx := r.db.Model(&types.GncSyncRunEntity{})
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if page > 0 && size > 0 {
+		tx = tx.Offset(int((page - 1) * size)).Limit(int(size))
+	}
+	err := tx.Order("id DESC").Find(&list).Error
+	return list, total, err
+}
+
+// ListSyncedGnc 曾经由该来源同步写入过的 base, 用于检测上游删除; 手工录入的不在其中
+func (r *gncRepoImpl) ListSyncedGnc(source string) ([]types.GncEntity, error) {
+	var list []types.GncEntity
+	err := r.db.Where("last_synced_at IS NOT NULL AND source = ?", source).Find(&list).Error
+	return list, err
+}
+
+// MarkGncSynced 只改 source/last_synced_at, 内容未变的 base 不必整行重写
+func (r *gncRepoImpl) MarkGncSynced(baseCode, source string, at time.Time) error {
+	return r.db.Model(&types.GncEntity{}).
+		Where("base_code = ?", baseCode).
+		UpdateColumns(map[string]interface{}{
+			"source":         source,
+			"last_synced_at": at,
+		}).Error
+}
+
+// ListGncBySource 本地化的商品目录; baseCodes 为空时返回该来源全部在架商品
+func (r *gncRepoImpl) ListGncBySource(source string, baseCodes []string) ([]types.GncEntity, error) {
+	var list []types.GncEntity
+	tx := r.db.Where("source = ? AND is_shelve = 1", source)
+	if len(baseCodes) > 0 {
+		tx = tx.Where("base_code IN ?", baseCodes)
+	}
+	err := tx.Order("id ASC").Find(&list).Error
+	return list, err
+}
@@ -0,0 +1,100 @@
+// internal/repository/gnc_trash_repo.go
+package repository
+
+import (
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+	"gorm.io/gorm"
+)
+
+// ListTrashedGnc 回收站分页查询, 按删除时间倒序
+func (r *gncRepoImpl) ListTrashedGnc(page, size int64) ([]types.GncEntity, int64, error) {
+	var list []types.GncEntity
+	var total int64
+	tx := r.db.Unscoped().Model(&types.GncEntity{}).Where("deleted_at IS NOT NULL")
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if page > 0 && size > 0 {
+		tx = tx.Offset(int((page - 1) * size)).Limit(int(size))
+	}
+	err := tx.Order("deleted_at DESC").Find(&list).Error
+	return list, total, err
+}
+
+// GetTrashedGnc 只查回收站中的 base, 不在回收站返回 gorm.ErrRecordNotFound
+func (r *gncRepoImpl) GetTrashedGnc(baseCode string) (*types.GncEntity, error) {
+	var entity types.GncEntity
+	err := r.db.Unscoped().
+		Where("base_code = ? AND deleted_at IS NOT NULL", baseCode).
+		Order("deleted_at DESC").
+		First(&entity).Error
+	if err != nil {
+		return nil, err
+	}
+	return &entity, nil
+}
+
+// RestoreGnc 清空 deleted_at
+func (r *gncRepoImpl) RestoreGnc(baseCode string) error {
+	res := r.db.Unscoped().Model(&types.GncEntity{}).
+		Where("base_code = ? AND deleted_at IS NOT NULL", baseCode).
+		UpdateColumn("deleted_at", nil)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// PurgeGnc 永久删除回收站中的 base
+func (r *gncRepoImpl) PurgeGnc(baseCode string) error {
+	res := r.db.Unscoped().
+		Where("base_code = ? AND deleted_at IS NOT NULL", baseCode).
+		Delete(&types.GncEntity{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CountPubsByBaseCode 统计组合了 baseCode 的 pub 数(含回收站中的)
+func (r *gncRepoImpl) CountPubsByBaseCode(baseCode string) (int64, error) {
+	var n int64
+	err := r.db.Model(&types.PubComposeEntity{}).
+		Where("base_code = ?", baseCode).
+		Distinct("gift_public_id").
+		Count(&n).Error
+	return n, err
+}
+
+// CountOrdersSinceByBaseCode 统计 since 之后引用了 baseCode 的订单数:
+// 直接下发过该 base 的子单, 加上所购 pub(含回收站中的)组合了该 base 的订单
+func (r *gncRepoImpl) CountOrdersSinceByBaseCode(baseCode string, since time.Time) (int64, error) {
+	var items int64
+	if err := r.db.Model(&types.OrderItemEntity{}).
+		Where("base_code = ? AND created_at >= ?", baseCode, since).
+		Count(&items).Error; err != nil {
+		return 0, err
+	}
+
+	pubIDs := r.db.Model(&types.PubComposeEntity{}).
+		Select("gift_public_id").
+		Where("base_code = ?", baseCode)
+	publicCodes := r.db.Unscoped().Model(&types.PubEntity{}).
+		Select("public_code").
+		Where("id IN (?)", pubIDs)
+	var orders int64
+	if err := r.db.Model(&types.OrderEntity{}).
+		Where("public_code IN (?) AND created_at >= ?", publicCodes, since).
+		Count(&orders).Error; err != nil {
+		return 0, err
+	}
+	return items + orders, nil
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"gorm.io/gorm"
 
@@ -27,7 +28,18 @@ type OrderRepo interface {
 
 	// ListOrder 分页列出订单
 	// ListOrder(page, size int64) ([]types.OrderEntity, int64, error)
-	ListOrder(page, size int64, orderIds, downstreamIds []string) ([]types.OrderEntity, int64, error)
+	// userSn 非空时只列出该渠道及其直属下级渠道的订单
+	ListOrder(page, size int64, orderIds, downstreamIds []string, userSn string) ([]types.OrderEntity, int64, error)
+
+	// ListParkedOrders 熔断期间暂存、未被领取或领取已过期(claimed_at 早于 leaseBefore)的订单, 先暂存的在前; total 为全部暂存数
+	ListParkedOrders(limit int, leaseBefore time.Time) ([]types.OrderEntity, int64, error)
+	// ClaimParkedOrder 领取暂存订单(记录 claimed_at, 状态仍为 parked)并返回 true; 已被其它实例领取且未过期时返回 false
+	ClaimParkedOrder(orderId string, leaseBefore time.Time) (bool, error)
+
+	// 组合订单子单
+	CreateOrderItems(items []types.OrderItemEntity) error
+	ListOrderItems(orderId string) ([]types.OrderItemEntity, error)
+	UpdateOrderItem(item *types.OrderItemEntity) error
 }
 
 // orderRepoImpl 实现 OrderRepo 接口
@@ -55,7 +67,7 @@ func (r *orderRepoImpl) GetOrderByOrderId(orderId string) (*types.OrderEntity, e
 	var order types.OrderEntity
 	if err := r.db.Where("order_id = ?", orderId).First(&order).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, fmt.Errorf("订单不存在, orderId=%s", orderId)
+			return nil, fmt.Errorf("order %s not found: %w", orderId, err)
 		}
 		return nil, errors.Join(err, errors.New("GetOrderByOrderId db error"))
 	}
@@ -67,7 +79,7 @@ func (r *orderRepoImpl) GetOrderByDownstreamOrderId(downstreamOrderId string) (*
 	var order types.OrderEntity
 	if err := r.db.Where("downstream_order_id = ?", downstreamOrderId).First(&order).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, fmt.Errorf("订单不存在, downstreamOrderId=%s", downstreamOrderId)
+			return nil, fmt.Errorf("order with downstreamOrderId %s not found: %w", downstreamOrderId, err)
 		}
 		return nil, errors.Join(err, errors.New("GetOrderByDownstreamOrderId db error"))
 	}
@@ -96,7 +108,7 @@ func (r *orderRepoImpl) DeleteOrderByOrderId(orderId string) error {
 	return nil
 }
 
-func (r *orderRepoImpl) ListOrder(page, size int64, orderIds, downstreamIds []string) ([]types.OrderEntity, int64, error) {
+func (r *orderRepoImpl) ListOrder(page, size int64, orderIds, downstreamIds []string, userSn string) ([]types.OrderEntity, int64, error) {
 	var list []types.OrderEntity
 	var total int64
 
@@ -123,6 +135,9 @@ func (r *orderRepoImpl) ListOrder(page, size int64, orderIds, downstreamIds []st
 		tx = tx.Where("downstream_order_id IN ?", downstreamIds)
 	}
 	// else => no filter
+	if userSn != "" {
+		tx = tx.Where("(user_sn = ? OR parent_sn = ?)", userSn, userSn)
+	}
 
 	// count
 	if err := tx.Count(&total).Error; err != nil {
@@ -138,3 +153,57 @@ func (r *orderRepoImpl) ListOrder(page, size int64, orderIds, downstreamIds []st
 
 	return list, total, nil
 }
+
+// ListParkedOrders 按 updated_at 升序取可领取的暂存订单
+func (r *orderRepoImpl) ListParkedOrders(limit int, leaseBefore time.Time) ([]types.OrderEntity, int64, error) {
+	var total int64
+	if err := r.db.Model(&types.OrderEntity{}).Where("status = ?", types.StatusParked).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("ListParkedOrders count error: %w", err)
+	}
+	var list []types.OrderEntity
+	if err := r.db.Where("status = ? AND (claimed_at IS NULL OR claimed_at < ?)", types.StatusParked, leaseBefore).
+		Order("updated_at ASC").Limit(limit).Find(&list).Error; err != nil {
+		return nil, 0, fmt.Errorf("ListParkedOrders find error: %w", err)
+	}
+	return list, total, nil
+}
+
+// ClaimParkedOrder 条件更新 claimed_at, 多实例同时重试时只有一个能领到;
+// 领取后进程退出的订单仍是 parked, 租约过期后由下一轮重试重新领取
+func (r *orderRepoImpl) ClaimParkedOrder(orderId string, leaseBefore time.Time) (bool, error) {
+	res := r.db.Model(&types.OrderEntity{}).
+		Where("order_id = ? AND status = ? AND (claimed_at IS NULL OR claimed_at < ?)", orderId, types.StatusParked, leaseBefore).
+		Update("claimed_at", time.Now())
+	if res.Error != nil {
+		return false, errors.Join(res.Error, errors.New("ClaimParkedOrder db error"))
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// CreateOrderItems 批量插入子单
+func (r *orderRepoImpl) CreateOrderItems(items []types.OrderItemEntity) error {
+	if len(items) == 0 {
+		return nil
+	}
+	if err := r.db.Create(&items).Error; err != nil {
+		return errors.Join(err, errors.New("CreateOrderItems db error"))
+	}
+	return nil
+}
+
+// ListOrderItems 查询父订单下的全部子单, 按下发顺序
+func (r *orderRepoImpl) ListOrderItems(orderId string) ([]types.OrderItemEntity, error) {
+	var items []types.OrderItemEntity
+	if err := r.db.Where("order_id = ?", orderId).Order("id ASC").Find(&items).Error; err != nil {
+		return nil, fmt.Errorf("ListOrderItems find error: %w", err)
+	}
+	return items, nil
+}
+
+// UpdateOrderItem 更新子单状态
+func (r *orderRepoImpl) UpdateOrderItem(item *types.OrderItemEntity) error {
+	if err := r.db.Save(item).Error; err != nil {
+		return errors.Join(err, errors.New("UpdateOrderItem db error"))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// internal/repository/partner_limit_repo.go
+package repository
+
+import (
+	"fmt"
+
+	"10000hk.com/vip_gift/internal/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HitRateWindow 给渠道在 windowAt 这一秒的计数 +1, 返回加后的值;
+// 用 INSERT ... ON DUPLICATE KEY UPDATE, 多副本共享同一计数
+func (r *partnerRepoImpl) HitRateWindow(userSn string, windowAt int64) (int64, error) {
+	seed := types.PartnerRateWindowEntity{UserSn: userSn, WindowAt: windowAt, Count: 1}
+	if err := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_sn"}, {Name: "window_at"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("count + 1")}),
+	}).Create(&seed).Error; err != nil {
+		return 0, err
+	}
+	var win types.PartnerRateWindowEntity
+	err := r.db.Where("user_sn = ? AND window_at = ?", userSn, windowAt).First(&win).Error
+	return win.Count, err
+}
+
+// PurgeRateWindows 清理 before(unix 秒) 之前的计数
+func (r *partnerRepoImpl) PurgeRateWindows(before int64) (int64, error) {
+	res := r.db.Where("window_at < ?", before).Delete(&types.PartnerRateWindowEntity{})
+	return res.RowsAffected, res.Error
+}
+
+// ReserveDailyUsage 在事务里锁住当日用量行, 校验订单数/金额配额后预占;
+// maxOrders/maxAmount 为 0 表示不限
+func (r *partnerRepoImpl) ReserveDailyUsage(userSn, day string, amount float64, maxOrders int64, maxAmount float64) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		seed := types.PartnerDailyUsageEntity{UserSn: userSn, Day: day}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&seed).Error; err != nil {
+			return err
+		}
+		var usage types.PartnerDailyUsageEntity
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_sn = ? AND day = ?", userSn, day).
+			First(&usage).Error; err != nil {
+			return err
+		}
+
+		if maxOrders > 0 && usage.Orders+1 > maxOrders {
+			return fmt.Errorf("%w: %d orders per day", types.ErrQuotaExceeded, maxOrders)
+		}
+		if maxAmount > 0 && usage.Amount+amount > maxAmount {
+			return fmt.Errorf("%w: amount %.2f per day", types.ErrQuotaExceeded, maxAmount)
+		}
+
+		return tx.Model(&types.PartnerDailyUsageEntity{}).
+			Where("id = ?", usage.ID).
+			UpdateColumns(map[string]interface{}{
+				"orders": gorm.Expr("orders + 1"),
+				"amount": gorm.Expr("amount + ?", amount),
+			}).Error
+	})
+}
+
+// ReleaseDailyUsage 下单失败时退回预占的用量
+func (r *partnerRepoImpl) ReleaseDailyUsage(userSn, day string, amount float64) error {
+	return r.db.Model(&types.PartnerDailyUsageEntity{}).
+		Where("user_sn = ? AND day = ?", userSn, day).
+		UpdateColumns(map[string]interface{}{
+			"orders": gorm.Expr("GREATEST(orders - 1, 0)"),
+			"amount": gorm.Expr("GREATEST(amount - ?, 0)", amount),
+		}).Error
+}
@@ -0,0 +1,203 @@
+// internal/repository/partner_repo.go
+package repository
+
+import (
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PartnerRepo interface {
+	// 渠道账户
+	CreatePartner(ent *types.PartnerEntity) error
+	GetPartner(userSn string) (*types.PartnerEntity, error)
+	UpdatePartner(ent *types.PartnerEntity) error
+	DeletePartner(userSn string) error
+	ListPartners(parentSn, status string, page, size int64) ([]types.PartnerEntity, int64, error)
+	CountChildPartners(userSn string) (int64, error)
+	CountPartnerOrders(userSn string) (int64, error)
+	UpdateChildLevels(parentSn string, level int64) error
+
+	// 签名密钥
+	CreatePartnerKey(ent *types.PartnerKeyEntity) error
+	GetPartnerKey(appKey string) (*types.PartnerKeyEntity, error)
+	ListPartnerKeys(userSn string, page, size int64) ([]types.PartnerKeyEntity, int64, error)
+	UpdatePartnerKeyStatus(appKey string, status int64) error
+	TouchPartnerKey(appKey string, at time.Time) error
+
+	// 防重放
+	UseNonce(appKey, nonce string) (bool, error)
+	PurgeNonces(before time.Time) (int64, error)
+
+	// 限流 & 配额 (partner_limit_repo.go)
+	HitRateWindow(userSn string, windowAt int64) (int64, error)
+	PurgeRateWindows(before int64) (int64, error)
+	ReserveDailyUsage(userSn, day string, amount float64, maxOrders int64, maxAmount float64) error
+	ReleaseDailyUsage(userSn, day string, amount float64) error
+}
+
+type partnerRepoImpl struct {
+	db *gorm.DB
+}
+
+func NewPartnerRepo(db *gorm.DB) PartnerRepo {
+	return &partnerRepoImpl{db: db}
+}
+
+func (r *partnerRepoImpl) CreatePartner(ent *types.PartnerEntity) error {
+	return r.db.Create(ent).Error
+}
+
+func (r *partnerRepoImpl) GetPartner(userSn string) (*types.PartnerEntity, error) {
+	var ent types.PartnerEntity
+	if err := r.db.Where("user_sn = ?", userSn).First(&ent).Error; err != nil {
+		return nil, err
+	}
+	return &ent, nil
+}
+
+func (r *partnerRepoImpl) UpdatePartner(ent *types.PartnerEntity) error {
+	return r.db.Save(ent).Error
+}
+
+// DeletePartner 删除渠道及其签名密钥
+func (r *partnerRepoImpl) DeletePartner(userSn string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_sn = ?", userSn).Delete(&types.PartnerKeyEntity{}).Error; err != nil {
+			return err
+		}
+		res := tx.Where("user_sn = ?", userSn).Delete(&types.PartnerEntity{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// ListPartners parentSn/status 为空时不过滤
+func (r *partnerRepoImpl) ListPartners(parentSn, status string, page, size int64) ([]types.PartnerEntity, int64, error) {
+	var list []types.PartnerEntity
+	var total int64
+	tx := r.db.Model(&types.PartnerEntity{})
+	if parentSn != "" {
+		tx = tx.Where("parent_sn = ?", parentSn)
+	}
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if page > 0 && size > 0 {
+		tx = tx.Offset(int((page - 1) * size)).Limit(int(size))
+	}
+	err := tx.Order("id ASC").Find(&list).Error
+	return list, total, err
+}
+
+func (r *partnerRepoImpl) CountChildPartners(userSn string) (int64, error) {
+	var n int64
+	err := r.db.Model(&types.PartnerEntity{}).Where("parent_sn = ?", userSn).Count(&n).Error
+	return n, err
+}
+
+// CountPartnerOrders 以该渠道为下单人或上级的订单数
+func (r *partnerRepoImpl) CountPartnerOrders(userSn string) (int64, error) {
+	var n int64
+	err := r.db.Model(&types.OrderEntity{}).
+		Where("user_sn = ? OR parent_sn = ?", userSn, userSn).
+		Count(&n).Error
+	return n, err
+}
+
+// UpdateChildLevels 上级层级变化后, 逐层刷新下级的 level
+func (r *partnerRepoImpl) UpdateChildLevels(parentSn string, level int64) error {
+	var children []string
+	if err := r.db.Model(&types.PartnerEntity{}).
+		Where("parent_sn = ?", parentSn).
+		Pluck("user_sn", &children).Error; err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := r.db.Model(&types.PartnerEntity{}).
+			Where("user_sn = ?", child).
+			UpdateColumn("level", level+1).Error; err != nil {
+			return err
+		}
+		if err := r.UpdateChildLevels(child, level+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *partnerRepoImpl) CreatePartnerKey(ent *types.PartnerKeyEntity) error {
+	return r.db.Create(ent).Error
+}
+
+func (r *partnerRepoImpl) GetPartnerKey(appKey string) (*types.PartnerKeyEntity, error) {
+	var ent types.PartnerKeyEntity
+	if err := r.db.Where("app_key = ?", appKey).First(&ent).Error; err != nil {
+		return nil, err
+	}
+	return &ent, nil
+}
+
+// ListPartnerKeys userSn 为空时返回全部
+func (r *partnerRepoImpl) ListPartnerKeys(userSn string, page, size int64) ([]types.PartnerKeyEntity, int64, error) {
+	var list []types.PartnerKeyEntity
+	var total int64
+	tx := r.db.Model(&types.PartnerKeyEntity{})
+	if userSn != "" {
+		tx = tx.Where("user_sn = ?", userSn)
+	}
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if page > 0 && size > 0 {
+		tx = tx.Offset(int((page - 1) * size)).Limit(int(size))
+	}
+	err := tx.Order("id DESC").Find(&list).Error
+	return list, total, err
+}
+
+func (r *partnerRepoImpl) UpdatePartnerKeyStatus(appKey string, status int64) error {
+	res := r.db.Model(&types.PartnerKeyEntity{}).
+		Where("app_key = ?", appKey).
+		UpdateColumn("status", status)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// TouchPartnerKey 记录最近一次验签通过的时间
+func (r *partnerRepoImpl) TouchPartnerKey(appKey string, at time.Time) error {
+	return r.db.Model(&types.PartnerKeyEntity{}).
+		Where("app_key = ?", appKey).
+		UpdateColumn("last_used_at", at).Error
+}
+
+// UseNonce 写入 nonce; 唯一索引已存在说明用过, 返回 false
+func (r *partnerRepoImpl) UseNonce(appKey, nonce string) (bool, error) {
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&types.PartnerNonceEntity{AppKey: appKey, Nonce: nonce})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// PurgeNonces 清理 before 之前的 nonce, 它们的时间戳已不可能通过校验
+func (r *partnerRepoImpl) PurgeNonces(before time.Time) (int64, error) {
+	res := r.db.Where("created_at < ?", before).Delete(&types.PartnerNonceEntity{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,56 @@
+// internal/repository/pub_availability_repo.go
+package repository
+
+import (
+	"fmt"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+func (r *pubRepoImpl) CreateAvailabilityWindow(ent *types.PubAvailabilityEntity) error {
+	return r.db.Create(ent).Error
+}
+
+func (r *pubRepoImpl) ListAvailabilityWindows(publicCode string) ([]types.PubAvailabilityEntity, error) {
+	var list []types.PubAvailabilityEntity
+	err := r.db.Where("public_code = ?", publicCode).Order("id ASC").Find(&list).Error
+	return list, err
+}
+
+// ListAllAvailabilityWindows 调度器使用: 一次性取出全部时间窗
+func (r *pubRepoImpl) ListAllAvailabilityWindows() ([]types.PubAvailabilityEntity, error) {
+	var list []types.PubAvailabilityEntity
+	err := r.db.Order("public_code ASC, id ASC").Find(&list).Error
+	return list, err
+}
+
+func (r *pubRepoImpl) DeleteAvailabilityWindow(id uint64) error {
+	res := r.db.Where("id = ?", id).Delete(&types.PubAvailabilityEntity{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return types.NewNotFoundError("WINDOW_NOT_FOUND", fmt.Sprintf("availability window %d not found", id), nil)
+	}
+	return nil
+}
+
+// ListPubByPublicCodes 批量查询主记录(不含 Compositions)
+func (r *pubRepoImpl) ListPubByPublicCodes(publicCodes []string) ([]types.PubEntity, error) {
+	var list []types.PubEntity
+	if len(publicCodes) == 0 {
+		return list, nil
+	}
+	err := r.db.Where("public_code IN ?", publicCodes).Find(&list).Error
+	return list, err
+}
+
+// UpdatePubStatus 时间窗调度使用: 只改 status / schedule_off 列, 不触发 BeforeSave, 也不动 Compositions
+func (r *pubRepoImpl) UpdatePubStatus(publicCode string, status int64, scheduleOff bool) error {
+	return r.db.Model(&types.PubEntity{}).
+		Where("public_code = ?", publicCode).
+		UpdateColumns(map[string]interface{}{
+			"status":       status,
+			"schedule_off": scheduleOff,
+		}).Error
+}
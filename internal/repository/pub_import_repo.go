@@ -0,0 +1,60 @@
+// internal/repository/pub_import_repo.go
+package repository
+
+import (
+	"10000hk.com/vip_gift/internal/types"
+	"gorm.io/gorm"
+)
+
+// FindPubsWithCompositions 按 publicCode 批量查询主记录并回填 Compositions
+func (r *pubRepoImpl) FindPubsWithCompositions(publicCodes []string) ([]types.PubEntity, error) {
+	var list []types.PubEntity
+	if len(publicCodes) == 0 {
+		return list, nil
+	}
+	if err := r.db.Where("public_code IN ?", publicCodes).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return list, nil
+	}
+	pubIDs := make([]uint64, 0, len(list))
+	for _, pub := range list {
+		pubIDs = append(pubIDs, pub.ID)
+	}
+	if err := r.attachCompositions(list, pubIDs); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// SavePubBatch 一个事务内写入一批 pub: ID=0 新建, 否则整行覆盖(stock_used 除外), 组合整体替换
+func (r *pubRepoImpl) SavePubBatch(ents []*types.PubEntity) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, ent := range ents {
+			if ent.ID == 0 {
+				if err := tx.Create(ent).Error; err != nil {
+					return err
+				}
+			} else {
+				if err := tx.Omit("stock_used").Save(ent).Error; err != nil {
+					return err
+				}
+				if err := tx.Where("gift_public_id = ?", ent.ID).Delete(&types.PubComposeEntity{}).Error; err != nil {
+					return err
+				}
+			}
+			if len(ent.Compositions) == 0 {
+				continue
+			}
+			for i := range ent.Compositions {
+				ent.Compositions[i].ID = 0
+				ent.Compositions[i].GiftPublicID = ent.ID
+			}
+			if err := tx.Create(&ent.Compositions).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,149 @@
+// internal/repository/pub_price_repo.go
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+	"gorm.io/gorm"
+)
+
+func (r *pubRepoImpl) CreatePriceHistory(h *types.PubPriceHistoryEntity) error {
+	return r.db.Create(h).Error
+}
+
+// ListPriceHistory 按变更时间倒序分页, page/size 若=0 则返回全部
+func (r *pubRepoImpl) ListPriceHistory(publicCode string, page, size int64) ([]types.PubPriceHistoryEntity, int64, error) {
+	var list []types.PubPriceHistoryEntity
+	var total int64
+
+	tx := r.db.Model(&types.PubPriceHistoryEntity{}).Where("public_code = ?", publicCode)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if page > 0 && size > 0 {
+		offset := (page - 1) * size
+		tx = tx.Offset(int(offset)).Limit(int(size))
+	}
+	if err := tx.Order("changed_at DESC, id DESC").Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+func (r *pubRepoImpl) CreatePriceSchedule(ent *types.PubPriceScheduleEntity) error {
+	return r.db.Create(ent).Error
+}
+
+func (r *pubRepoImpl) ListPriceSchedules(publicCode string) ([]types.PubPriceScheduleEntity, error) {
+	var list []types.PubPriceScheduleEntity
+	err := r.db.Where("public_code = ?", publicCode).
+		Order("effective_at DESC").
+		Find(&list).Error
+	return list, err
+}
+
+// CancelPriceSchedule 只能取消尚未生效的计划
+func (r *pubRepoImpl) CancelPriceSchedule(id uint64) error {
+	res := r.db.Model(&types.PubPriceScheduleEntity{}).
+		Where("id = ? AND status = ?", id, types.PriceSchedulePending).
+		UpdateColumn("status", types.PriceScheduleCancelled)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("price schedule %d not found or not pending", id)
+	}
+	return nil
+}
+
+// ListDuePriceSchedules 找出已到生效时间且仍待生效的计划, 按生效时间先后排序
+func (r *pubRepoImpl) ListDuePriceSchedules(now time.Time, limit int) ([]types.PubPriceScheduleEntity, error) {
+	var list []types.PubPriceScheduleEntity
+	tx := r.db.Where("status = ? AND effective_at <= ?", types.PriceSchedulePending, now).
+		Order("effective_at ASC, id ASC")
+	if limit > 0 {
+		tx = tx.Limit(limit)
+	}
+	err := tx.Find(&list).Error
+	return list, err
+}
+
+// ApplyPriceSchedule 在一个事务里:
+// 1) 抢占计划(pending -> applied), 多副本同时运行时只有一个能成功
+// 2) 更新 pub 价格列
+// 3) 写价格历史
+// 若计划已被其他副本处理, 返回 (nil, nil)
+func (r *pubRepoImpl) ApplyPriceSchedule(sched *types.PubPriceScheduleEntity) (*types.PubEntity, error) {
+	var applied *types.PubEntity
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		res := tx.Model(&types.PubPriceScheduleEntity{}).
+			Where("id = ? AND status = ?", sched.ID, types.PriceSchedulePending).
+			UpdateColumns(map[string]interface{}{
+				"status":     types.PriceScheduleApplied,
+				"applied_at": now,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+
+		var ent types.PubEntity
+		if err := tx.Where("public_code = ?", sched.PublicCode).First(&ent).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// 产品已不存在: 标记失败, 不回滚
+				return tx.Model(&types.PubPriceScheduleEntity{}).
+					Where("id = ?", sched.ID).
+					UpdateColumns(map[string]interface{}{
+						"status": types.PriceScheduleFailed,
+						"remark": "pub not found",
+					}).Error
+			}
+			return err
+		}
+
+		hist := types.PubPriceHistoryEntity{
+			PublicCode:      ent.PublicCode,
+			OldSalePrice:    ent.SalePrice,
+			OldParValue:     ent.ParValue,
+			OldCommissionMF: ent.CommissionMF,
+			Source:          types.PriceSourceSchedule,
+			ScheduleID:      sched.ID,
+		}
+		if sched.SalePrice != nil {
+			ent.SalePrice = *sched.SalePrice
+		}
+		if sched.ParValue != nil {
+			ent.ParValue = *sched.ParValue
+		}
+		if sched.CommissionMF != nil {
+			ent.CommissionMF = *sched.CommissionMF
+		}
+		hist.SalePrice = ent.SalePrice
+		hist.ParValue = ent.ParValue
+		hist.CommissionMF = ent.CommissionMF
+
+		if err := tx.Model(&types.PubEntity{}).Where("id = ?", ent.ID).
+			UpdateColumns(map[string]interface{}{
+				"sale_price":    ent.SalePrice,
+				"par_value":     ent.ParValue,
+				"commission_mf": ent.CommissionMF,
+			}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&hist).Error; err != nil {
+			return err
+		}
+		applied = &ent
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
@@ -0,0 +1,50 @@
+// internal/repository/pub_propagation_repo.go
+package repository
+
+import (
+	"10000hk.com/vip_gift/internal/types"
+)
+
+// ListPubByBaseCode 通过 pub_compose_entities 反查引用了 baseCode 的 pub (含 Compositions)
+func (r *pubRepoImpl) ListPubByBaseCode(baseCode string) ([]types.PubEntity, error) {
+	var list []types.PubEntity
+	sub := r.db.Model(&types.PubComposeEntity{}).
+		Select("gift_public_id").
+		Where("base_code = ?", baseCode)
+	if err := r.db.Where("id IN (?)", sub).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return list, nil
+	}
+	pubIDs := make([]uint64, 0, len(list))
+	for _, pub := range list {
+		pubIDs = append(pubIDs, pub.ID)
+	}
+	if err := r.attachCompositions(list, pubIDs); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// ListPubNeedsReview 分页查询待复核的 pub
+func (r *pubRepoImpl) ListPubNeedsReview(page, size int64) ([]types.PubEntity, int64, error) {
+	var list []types.PubEntity
+	var total int64
+	tx := r.db.Model(&types.PubEntity{}).Where("needs_review = 1")
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if page > 0 && size > 0 {
+		tx = tx.Offset(int((page - 1) * size)).Limit(int(size))
+	}
+	err := tx.Order("id ASC").Find(&list).Error
+	return list, total, err
+}
+
+// UpdatePubColumns 只改写指定列, 不触发 BeforeSave
+func (r *pubRepoImpl) UpdatePubColumns(publicCode string, cols map[string]interface{}) error {
+	return r.db.Model(&types.PubEntity{}).
+		Where("public_code = ?", publicCode).
+		UpdateColumns(cols).Error
+}
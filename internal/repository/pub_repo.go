@@ -2,6 +2,11 @@
 package repository
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
 	"10000hk.com/vip_gift/internal/types"
 	"gorm.io/gorm"
 )
@@ -13,6 +18,52 @@ type PubRepo interface {
 	DeletePubByPublicCode(publicCode string) error
 	FindPubByNamePrefix(prefix string, pubs *[]types.PubEntity) error
 	ListPub(page, size int64) ([]types.PubEntity, int64, error) // 分页需求
+
+	// 批量分类
+	FindPubBySelector(sel types.PubSelector) ([]types.PubEntity, error)
+	SavePubCategories(pubs []types.PubEntity, logs []types.PubCategoryLogEntity) error
+	RevertCategoryBatch(batchId string) ([]types.PubEntity, []string, error)
+
+	// 价格历史 & 定时调价 (pub_price_repo.go)
+	CreatePriceHistory(h *types.PubPriceHistoryEntity) error
+	ListPriceHistory(publicCode string, page, size int64) ([]types.PubPriceHistoryEntity, int64, error)
+	CreatePriceSchedule(ent *types.PubPriceScheduleEntity) error
+	ListPriceSchedules(publicCode string) ([]types.PubPriceScheduleEntity, error)
+	CancelPriceSchedule(id uint64) error
+	ListDuePriceSchedules(now time.Time, limit int) ([]types.PubPriceScheduleEntity, error)
+	ApplyPriceSchedule(sched *types.PubPriceScheduleEntity) (*types.PubEntity, error)
+
+	// 上架时间窗 (pub_availability_repo.go)
+	CreateAvailabilityWindow(ent *types.PubAvailabilityEntity) error
+	ListAvailabilityWindows(publicCode string) ([]types.PubAvailabilityEntity, error)
+	ListAllAvailabilityWindows() ([]types.PubAvailabilityEntity, error)
+	DeleteAvailabilityWindow(id uint64) error
+	ListPubByPublicCodes(publicCodes []string) ([]types.PubEntity, error)
+	UpdatePubStatus(publicCode string, status int64, scheduleOff bool) error
+
+	// 库存 & 限购 (pub_stock_repo.go)
+	ReserveStock(res types.StockReservation, day string) error
+	ReleaseStock(orderId string) (bool, error)
+
+	// 组合快照 (pub_snapshot_repo.go)
+	UpdateComposeSnapshot(id uint64, snapshot string, at time.Time) error
+
+	// base 变更联动 (pub_propagation_repo.go)
+	ListPubByBaseCode(baseCode string) ([]types.PubEntity, error)
+	ListPubNeedsReview(page, size int64) ([]types.PubEntity, int64, error)
+	UpdatePubColumns(publicCode string, cols map[string]interface{}) error
+
+	// 批量导入 (pub_import_repo.go)
+	FindPubsWithCompositions(publicCodes []string) ([]types.PubEntity, error)
+	SavePubBatch(ents []*types.PubEntity) error
+
+	// 回收站 (pub_trash_repo.go)
+	ListTrashedPub(page, size int64) ([]types.PubEntity, int64, error)
+	GetTrashedPub(publicCode string) (*types.PubEntity, error)
+	ListTrashedPublicCodes(publicCodes []string) ([]string, error)
+	RestorePub(publicCode string) error
+	PurgePub(publicCode string) error
+	CountOrdersSince(publicCode string, since time.Time) (int64, error)
 }
 
 type pubRepoImpl struct {
@@ -62,7 +113,8 @@ func (r *pubRepoImpl) GetPubByPublicCode(publicCode string) (*types.PubEntity, e
 
 func (r *pubRepoImpl) UpdatePub(ent *types.PubEntity) error {
 	// 1) 更新主记录
-	if err := r.db.Save(ent).Error; err != nil {
+	//    stock_used 由下单预占维护, 这里不能用读出来的旧值覆盖
+	if err := r.db.Omit("stock_used").Save(ent).Error; err != nil {
 		return err
 	}
 
@@ -83,23 +135,15 @@ func (r *pubRepoImpl) UpdatePub(ent *types.PubEntity) error {
 	return nil
 }
 
+// DeletePubByPublicCode 软删除: 只写 deleted_at, Compositions 保留, 历史订单仍可追溯
+// 永久删除见 PurgePub
 func (r *pubRepoImpl) DeletePubByPublicCode(publicCode string) error {
-	// return r.db.Where("public_code = ?", publicCode).Delete(&types.PubEntity{}).Error
-	// 1) 找到主记录
-	var entity types.PubEntity
-	err := r.db.Where("public_code = ?", publicCode).First(&entity).Error
-	if err != nil {
-		return err
+	res := r.db.Where("public_code = ?", publicCode).Delete(&types.PubEntity{})
+	if res.Error != nil {
+		return res.Error
 	}
-
-	// 2) 删除对应 Compositions
-	if err := r.db.Where("gift_public_id = ?", entity.ID).Delete(&types.PubComposeEntity{}).Error; err != nil {
-		return err
-	}
-
-	// 3) 删除主记录
-	if err := r.db.Delete(&entity).Error; err != nil {
-		return err
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
 	}
 	return nil
 }
@@ -136,12 +180,20 @@ func (r *pubRepoImpl) ListPub(page, size int64) ([]types.PubEntity, int64, error
 		pubIDs = append(pubIDs, pub.ID)
 	}
 
-	// 5) 一次性把所有关联的 PubComposeEntity 都捞出来
-	//    where gift_public_id IN (我们所有的 pub ID)
+	// 5) 一次性把所有关联的 PubComposeEntity 都捞出来, 回填到每个 PubEntity
+	if err := r.attachCompositions(list, pubIDs); err != nil {
+		return nil, 0, err
+	}
+
+	return list, total, nil
+}
+
+// attachCompositions where gift_public_id IN (pubIDs), 按 pub 分组回填 Compositions
+func (r *pubRepoImpl) attachCompositions(list []types.PubEntity, pubIDs []uint64) error {
 	var comps []types.PubComposeEntity
 	if err := r.db.Where("gift_public_id IN ?", pubIDs).
 		Find(&comps).Error; err != nil {
-		return nil, 0, err
+		return err
 	}
 
 	// 6) 按 gift_public_id 分组存到 map 里
@@ -154,8 +206,7 @@ func (r *pubRepoImpl) ListPub(page, size int64) ([]types.PubEntity, int64, error
 	for i := range list {
 		list[i].Compositions = compMap[list[i].ID]
 	}
-
-	return list, total, nil
+	return nil
 }
 func (r *pubRepoImpl) FindPubByNamePrefix(prefix string, pubs *[]types.PubEntity) error {
 	// 例如: product_name LIKE '爱奇艺%'
@@ -164,3 +215,147 @@ func (r *pubRepoImpl) FindPubByNamePrefix(prefix string, pubs *[]types.PubEntity
 	return r.db.Where("product_name LIKE ?", likeStr).
 		Find(pubs).Error
 }
+
+// FindPubBySelector 按规则圈选 pub:
+// 前缀/价格/baseCode 在 SQL 中过滤; 正则先取其中的字面量在 SQL 中 LIKE 预过滤,
+// 再在内存中精确匹配(避免依赖 MySQL 的 REGEXP 方言). 规则需先经 PubSelector.Validate 校验
+func (r *pubRepoImpl) FindPubBySelector(sel types.PubSelector) ([]types.PubEntity, error) {
+	var re *regexp.Regexp
+	if sel.NameRegex != "" {
+		compiled, err := regexp.Compile(sel.NameRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nameRegex: %w", err)
+		}
+		re = compiled
+	}
+
+	tx := r.db.Model(&types.PubEntity{})
+	if sel.NamePrefix != "" {
+		tx = tx.Where("product_name LIKE ?", escapeLike(sel.NamePrefix)+"%")
+	}
+	if lit := types.RegexLiteral(sel.NameRegex); lit != "" {
+		tx = tx.Where("product_name LIKE ?", "%"+escapeLike(lit)+"%")
+	}
+	if sel.MinPrice > 0 {
+		tx = tx.Where("sale_price >= ?", sel.MinPrice)
+	}
+	if sel.MaxPrice > 0 {
+		tx = tx.Where("sale_price <= ?", sel.MaxPrice)
+	}
+	if len(sel.BaseCodes) > 0 {
+		sub := r.db.Model(&types.PubComposeEntity{}).
+			Select("gift_public_id").
+			Where("base_code IN ?", sel.BaseCodes)
+		tx = tx.Where("id IN (?)", sub)
+	}
+
+	var list []types.PubEntity
+	if err := tx.Find(&list).Error; err != nil {
+		return nil, err
+	}
+	if re == nil {
+		return list, nil
+	}
+
+	matched := make([]types.PubEntity, 0, len(list))
+	for _, p := range list {
+		if re.MatchString(p.ProductName) {
+			matched = append(matched, p)
+		}
+	}
+	return matched, nil
+}
+
+// escapeLike 转义 LIKE 的通配符, 使其按字面匹配
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// SavePubCategories 在同一事务里写入 tag / categories_json 并记录撤销日志
+func (r *pubRepoImpl) SavePubCategories(pubs []types.PubEntity, logs []types.PubCategoryLogEntity) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for i := range pubs {
+			// 用 UpdateColumns 跳过 BeforeSave, 避免覆盖 pics_json
+			if err := updateCategoryColumns(tx, pubs[i].ID, pubs[i].Tag, pubs[i].Categories); err != nil {
+				return err
+			}
+		}
+		if len(logs) > 0 {
+			if err := tx.Create(&logs).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RevertCategoryBatch 把某批次尚未撤销的日志还原成旧值, 返回被还原的 pub 与冲突的 publicCode;
+// 只还原 tag / categories_json 仍是该批次写入值的 pub, 之后又被改过的视为冲突, 保留现值且日志不标记撤销
+func (r *pubRepoImpl) RevertCategoryBatch(batchId string) ([]types.PubEntity, []string, error) {
+	var reverted []types.PubEntity
+	var conflicts []string
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var logs []types.PubCategoryLogEntity
+		if err := tx.Where("batch_id = ? AND reverted = ?", batchId, false).
+			Find(&logs).Error; err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			return types.NewNotFoundError("BATCH_NOT_FOUND", fmt.Sprintf("batch %s not found or already reverted", batchId), nil)
+		}
+
+		for _, l := range logs {
+			// 条件更新: 仍是本批次写入的值才还原
+			res := tx.Model(&types.PubEntity{}).
+				Where("public_code = ? AND tag = ? AND categories_json = ?", l.PublicCode, l.NewTag, l.NewCategories).
+				UpdateColumns(map[string]interface{}{
+					"tag":             l.OldTag,
+					"categories_json": l.OldCategories,
+				})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				var n int64
+				if err := tx.Model(&types.PubEntity{}).Where("public_code = ?", l.PublicCode).Count(&n).Error; err != nil {
+					return err
+				}
+				// pub 已被删除: 无需还原, 日志照常标记
+				if n > 0 {
+					conflicts = append(conflicts, l.PublicCode)
+					continue
+				}
+			} else {
+				var ent types.PubEntity
+				if err := tx.Where("public_code = ?", l.PublicCode).First(&ent).Error; err != nil {
+					return err
+				}
+				reverted = append(reverted, ent)
+			}
+			if err := tx.Model(&types.PubCategoryLogEntity{}).
+				Where("id = ?", l.ID).
+				UpdateColumn("reverted", true).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return reverted, conflicts, nil
+}
+
+func updateCategoryColumns(tx *gorm.DB, id uint64, tag string, categories []string) error {
+	catsJSON, err := types.MarshalStrings(categories)
+	if err != nil {
+		return err
+	}
+	return tx.Model(&types.PubEntity{}).Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{
+			"tag":             tag,
+			"categories_json": catsJSON,
+		}).Error
+}
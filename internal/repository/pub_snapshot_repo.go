@@ -0,0 +1,18 @@
+// internal/repository/pub_snapshot_repo.go
+package repository
+
+import (
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+// UpdateComposeSnapshot 只改写单条组合的快照列
+func (r *pubRepoImpl) UpdateComposeSnapshot(id uint64, snapshot string, at time.Time) error {
+	return r.db.Model(&types.PubComposeEntity{}).
+		Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{
+			"snapshot":    snapshot,
+			"snapshot_at": at,
+		}).Error
+}
@@ -0,0 +1,137 @@
+// internal/repository/pub_stock_repo.go
+package repository
+
+import (
+	"errors"
+
+	"10000hk.com/vip_gift/internal/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReserveStock 在一个事务里完成库存与限购的校验和预占:
+// 1) 锁住 pub 行(SELECT ... FOR UPDATE), 并发下单按 pub 串行
+// 2) 校验总库存 / 当日库存 / 手机号限购 / userSn 限购
+// 3) 写预占记录, 累加 stock_used 与当日 used
+// pub 不存在(如话费直充产品)时不做任何限制
+func (r *pubRepoImpl) ReserveStock(res types.StockReservation, day string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var pub types.PubEntity
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("public_code = ?", res.PublicCode).First(&pub).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		// 1) 总库存
+		if pub.StockTotal > 0 && pub.StockUsed >= pub.StockTotal {
+			return types.ErrOutOfStock
+		}
+
+		// 2) 当日库存
+		var daily types.PubDailyStockEntity
+		if pub.DailyStock > 0 {
+			seed := types.PubDailyStockEntity{PublicCode: pub.PublicCode, Day: day}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&seed).Error; err != nil {
+				return err
+			}
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("public_code = ? AND day = ?", pub.PublicCode, day).
+				First(&daily).Error; err != nil {
+				return err
+			}
+			if daily.Used >= pub.DailyStock {
+				return types.ErrDailyStockExhausted
+			}
+		}
+
+		// 3) 限购
+		if pub.LimitPerPhone > 0 && res.Phone != "" {
+			n, err := countReservations(tx, pub.PublicCode, "phone = ?", res.Phone)
+			if err != nil {
+				return err
+			}
+			if n >= pub.LimitPerPhone {
+				return types.ErrPurchaseLimitReached
+			}
+		}
+		if pub.LimitPerUser > 0 && res.UserSn != "" {
+			n, err := countReservations(tx, pub.PublicCode, "user_sn = ?", res.UserSn)
+			if err != nil {
+				return err
+			}
+			if n >= pub.LimitPerUser {
+				return types.ErrPurchaseLimitReached
+			}
+		}
+
+		// 4) 预占
+		if err := tx.Create(&types.PubReservationEntity{
+			OrderId:    res.OrderId,
+			PublicCode: pub.PublicCode,
+			Phone:      res.Phone,
+			UserSn:     res.UserSn,
+			Day:        day,
+			Status:     types.ReservationReserved,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&types.PubEntity{}).Where("id = ?", pub.ID).
+			UpdateColumn("stock_used", gorm.Expr("stock_used + 1")).Error; err != nil {
+			return err
+		}
+		if pub.DailyStock > 0 {
+			if err := tx.Model(&types.PubDailyStockEntity{}).Where("id = ?", daily.ID).
+				UpdateColumn("used", gorm.Expr("used + 1")).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReleaseStock 订单失败时释放预占, 可重复调用; 返回本次是否真正释放
+func (r *pubRepoImpl) ReleaseStock(orderId string) (bool, error) {
+	released := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var res types.PubReservationEntity
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_id = ? AND status = ?", orderId, types.ReservationReserved).
+			First(&res).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		if err := tx.Model(&res).UpdateColumn("status", types.ReservationReleased).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&types.PubEntity{}).
+			Where("public_code = ? AND stock_used > 0", res.PublicCode).
+			UpdateColumn("stock_used", gorm.Expr("stock_used - 1")).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&types.PubDailyStockEntity{}).
+			Where("public_code = ? AND day = ? AND used > 0", res.PublicCode, res.Day).
+			UpdateColumn("used", gorm.Expr("used - 1")).Error; err != nil {
+			return err
+		}
+		released = true
+		return nil
+	})
+	return released, err
+}
+
+func countReservations(tx *gorm.DB, publicCode, cond string, arg interface{}) (int64, error) {
+	var n int64
+	err := tx.Model(&types.PubReservationEntity{}).
+		Where("public_code = ? AND status = ?", publicCode, types.ReservationReserved).
+		Where(cond, arg).
+		Count(&n).Error
+	return n, err
+}
@@ -0,0 +1,105 @@
+// internal/repository/pub_trash_repo.go
+package repository
+
+import (
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+	"gorm.io/gorm"
+)
+
+// ListTrashedPub 回收站分页查询, 按删除时间倒序 (含 Compositions)
+func (r *pubRepoImpl) ListTrashedPub(page, size int64) ([]types.PubEntity, int64, error) {
+	var list []types.PubEntity
+	var total int64
+	tx := r.db.Unscoped().Model(&types.PubEntity{}).Where("deleted_at IS NOT NULL")
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if page > 0 && size > 0 {
+		tx = tx.Offset(int((page - 1) * size)).Limit(int(size))
+	}
+	if err := tx.Order("deleted_at DESC").Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	if len(list) == 0 {
+		return list, total, nil
+	}
+	pubIDs := make([]uint64, 0, len(list))
+	for _, pub := range list {
+		pubIDs = append(pubIDs, pub.ID)
+	}
+	if err := r.attachCompositions(list, pubIDs); err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// GetTrashedPub 只查回收站中的 pub, 不在回收站返回 gorm.ErrRecordNotFound
+func (r *pubRepoImpl) GetTrashedPub(publicCode string) (*types.PubEntity, error) {
+	var entity types.PubEntity
+	err := r.db.Unscoped().
+		Where("public_code = ? AND deleted_at IS NOT NULL", publicCode).
+		First(&entity).Error
+	if err != nil {
+		return nil, err
+	}
+	var comps []types.PubComposeEntity
+	if err := r.db.Where("gift_public_id = ?", entity.ID).Find(&comps).Error; err != nil {
+		return nil, err
+	}
+	entity.Compositions = comps
+	return &entity, nil
+}
+
+// ListTrashedPublicCodes 返回 publicCodes 中处于回收站的那部分
+func (r *pubRepoImpl) ListTrashedPublicCodes(publicCodes []string) ([]string, error) {
+	var codes []string
+	if len(publicCodes) == 0 {
+		return codes, nil
+	}
+	err := r.db.Unscoped().Model(&types.PubEntity{}).
+		Where("public_code IN ? AND deleted_at IS NOT NULL", publicCodes).
+		Pluck("public_code", &codes).Error
+	return codes, err
+}
+
+// RestorePub 清空 deleted_at, Compositions 软删除时未动, 无需恢复
+func (r *pubRepoImpl) RestorePub(publicCode string) error {
+	res := r.db.Unscoped().Model(&types.PubEntity{}).
+		Where("public_code = ? AND deleted_at IS NOT NULL", publicCode).
+		UpdateColumn("deleted_at", nil)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// PurgePub 永久删除回收站中的 pub 及其 Compositions
+func (r *pubRepoImpl) PurgePub(publicCode string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var entity types.PubEntity
+		err := tx.Unscoped().
+			Where("public_code = ? AND deleted_at IS NOT NULL", publicCode).
+			First(&entity).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("gift_public_id = ?", entity.ID).Delete(&types.PubComposeEntity{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&entity).Error
+	})
+}
+
+// CountOrdersSince 统计 since 之后下单且引用了 publicCode 的订单数
+func (r *pubRepoImpl) CountOrdersSince(publicCode string, since time.Time) (int64, error) {
+	var n int64
+	err := r.db.Model(&types.OrderEntity{}).
+		Where("public_code = ? AND created_at >= ?", publicCode, since).
+		Count(&n).Error
+	return n, err
+}
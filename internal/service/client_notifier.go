@@ -10,9 +10,12 @@ import (
 	"net/http"
 	"time"
 
+	"10000hk.com/vip_gift/internal/metrics"
 	"10000hk.com/vip_gift/internal/types"
+	"10000hk.com/vip_gift/pkg"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 // UpstreamNotifier 封装了「通知上游系统」的行为
@@ -29,7 +32,8 @@ type upstreamNotifier struct {
 func NewUpstreamNotifier(notifyURL string) UpstreamNotifier {
 	return &upstreamNotifier{
 		httpClient: &http.Client{
-			Timeout: 5 * time.Second, // 可以视情况调大
+			Timeout:   5 * time.Second, // 可以视情况调大
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
 		},
 		notifyURL: notifyURL,
 	}
@@ -37,6 +41,16 @@ func NewUpstreamNotifier(notifyURL string) UpstreamNotifier {
 
 // NotifyOrderStatus 发送 HTTP POST 到上游接口
 func (u *upstreamNotifier) NotifyOrderStatus(ctx context.Context, orderDTO *types.OrderDTO) error {
+	ctx, span := pkg.Tracer().Start(ctx, "UpstreamNotifier notify")
+	defer span.End()
+
+	err := u.notify(ctx, orderDTO)
+	pkg.RecordSpanError(ctx, err)
+	metrics.NotifierRequests.WithLabelValues(metrics.Result(err)).Inc()
+	return err
+}
+
+func (u *upstreamNotifier) notify(ctx context.Context, orderDTO *types.OrderDTO) error {
 	payload := map[string]interface{}{
 		"upstreamOrderSn": orderDTO.OrderId, // 也可能是 orderDTO.DownstreamOrderId, 视具体需求
 		"message":         orderDTO.Remark,
@@ -54,6 +68,9 @@ func (u *upstreamNotifier) NotifyOrderStatus(ctx context.Context, orderDTO *type
 		return fmt.Errorf("create request error: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if id := pkg.RequestID(ctx); id != "" {
+		req.Header.Set(pkg.HeaderRequestID, id)
+	}
 	token, err := GenerateToken("VIP")
 	if err != nil {
 		return fmt.Errorf("failed to generate token: %w", err)
@@ -2,61 +2,73 @@
 package service
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"net/http"
+	"log"
+	"sync"
 	"time"
 
 	"10000hk.com/vip_gift/internal/repository"
 	"10000hk.com/vip_gift/internal/types"
+	"gorm.io/gorm"
 )
 
 type GncService interface {
 	Create(dto *types.GncDTO) (*types.GncDTO, error)
 	GetByBaseCode(baseCode string) (*types.GncDTO, error)
 	UpdateByBaseCode(baseCode string, dto *types.GncDTO) (*types.GncDTO, error)
+	PatchByBaseCode(baseCode string, patch *types.Patch) (*types.GncDTO, error)
 	DeleteByBaseCode(baseCode string) error
 
 	List(page, size int64) ([]types.GncDTO, int64, error)
-	SyncFromRemote(remoteURL string, pageSize int, bearerToken ...string) error
+	SyncFromRemote(trigger string, src types.CatalogSource, pageSize int) (*types.GncSyncRunEntity, error)
+	ListSyncRuns(page, size int64) ([]types.GncSyncRunEntity, int64, error)
+
+	// 回收站 (gnc_trash_service.go)
+	ListTrash(page, size int64) ([]types.GncDTO, int64, error)
+	RestoreByBaseCode(baseCode string) (*types.GncDTO, error)
+	PurgeByBaseCode(baseCode string) error
 }
 
 type gncServiceImpl struct {
-	repo repository.GncRepo
+	repo           repository.GncRepo
+	listener       BaseChangeListener // 可为 nil: 不做联动
+	policy         string             // types.PropagationXxx
+	missingAction  string             // types.MissingActionXxx
+	purgeGuardDays int                // 永久删除前回溯多少天的订单
+	syncMu         sync.Map           // source => *sync.Mutex
 }
 
-func NewGncService(repo repository.GncRepo) GncService {
-	return &gncServiceImpl{repo: repo}
+// NewGncService policy 见 types.ParsePropagationPolicy, missingAction 见 types.ParseMissingAction,
+// purgeGuardDays 见 types.ParsePurgeGuardDays
+func NewGncService(repo repository.GncRepo, listener BaseChangeListener, policy, missingAction string, purgeGuardDays int) GncService {
+	return &gncServiceImpl{
+		repo:           repo,
+		listener:       listener,
+		policy:         policy,
+		missingAction:  missingAction,
+		purgeGuardDays: purgeGuardDays,
+	}
 }
 
-// ---------------------
-// 第三方接口返回结构示例
-// ---------------------
-type remoteGncResponse struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-	Data    struct {
-		DataList []remoteGncProduct `json:"dataList"`
-		Total    int                `json:"total"`
-	} `json:"data"`
-}
-type remoteGncProduct struct {
-	ProductId    string   `json:"publicCode"`
-	ProductName  string   `json:"productName"`
-	ProductType  int64    `json:"productType"`
-	ParValue     float64  `json:"parValue"`
-	SalePrice    float64  `json:"salePrice"`
-	IsShelve     int64    `json:"status"`
-	ProductDesc  string   `json:"desc"`
-	ProductPics  []string `json:"pics"`
-	ProductCover string   `json:"cover"`
+// notifyChange 把 base 变更交给依赖的 pub 处理; 联动失败只记日志, 不影响 base 本身的写入
+func (s *gncServiceImpl) notifyChange(baseCode string, oldEnt, newEnt *types.GncEntity) {
+	if s.listener == nil {
+		return
+	}
+	change := types.BaseChange{BaseCode: baseCode, Old: oldEnt, New: newEnt}
+	if _, err := s.listener.OnBaseChanged(change, s.policy); err != nil {
+		log.Printf("[GncService] propagate %s failed: %v\n", baseCode, err)
+	}
 }
 
 func (s *gncServiceImpl) Create(dto *types.GncDTO) (*types.GncDTO, error) {
 	if dto.BaseCode == "" {
-		return nil, errors.New("baseCode is required")
+		return nil, types.NewValidationError("baseCode is required")
+	}
+	if _, err := s.repo.GetTrashedGnc(dto.BaseCode); err == nil {
+		return nil, fmt.Errorf("%w: %s", types.ErrInTrash, dto.BaseCode)
 	}
 	ent, err := dto.ToEntity()
 	if err != nil {
@@ -69,6 +81,7 @@ func (s *gncServiceImpl) Create(dto *types.GncDTO) (*types.GncDTO, error) {
 	if err := s.repo.CreateGnc(ent); err != nil {
 		return nil, err
 	}
+	s.notifyChange(ent.BaseCode, nil, ent)
 	_ = dto.FromEntity(ent)
 	return dto, nil
 }
@@ -88,6 +101,7 @@ func (s *gncServiceImpl) UpdateByBaseCode(baseCode string, dto *types.GncDTO) (*
 	if err != nil {
 		return nil, err
 	}
+	before := *oldEnt
 	// 这里简单处理：如果不为零就更新
 	if dto.ProductName != "" {
 		oldEnt.ProductName = dto.ProductName
@@ -107,6 +121,9 @@ func (s *gncServiceImpl) UpdateByBaseCode(baseCode string, dto *types.GncDTO) (*
 	if dto.ProductCover != "" {
 		oldEnt.ProductCover = dto.ProductCover
 	}
+	if dto.ProductPics != nil {
+		oldEnt.ProductPics = dto.ProductPics
+	}
 	if dto.OriginData != "" {
 		oldEnt.OriginData = dto.OriginData
 	}
@@ -114,13 +131,51 @@ func (s *gncServiceImpl) UpdateByBaseCode(baseCode string, dto *types.GncDTO) (*
 	if err := s.repo.UpdateGnc(oldEnt); err != nil {
 		return nil, err
 	}
+	s.notifyChange(baseCode, &before, oldEnt)
 	var updated types.GncDTO
 	_ = updated.FromEntity(oldEnt)
 	return &updated, nil
 }
 
+// PatchByBaseCode 只修改 patch 涉及的字段, 可以把 isShelve 改为 0
+func (s *gncServiceImpl) PatchByBaseCode(baseCode string, patch *types.Patch) (*types.GncDTO, error) {
+	oldEnt, err := s.repo.GetGncByBaseCode(baseCode)
+	if err != nil {
+		return nil, err
+	}
+	var dto types.GncDTO
+	_ = dto.FromEntity(oldEnt)
+	if err := patch.Apply(&dto, types.GncPatchFields); err != nil {
+		return nil, fmt.Errorf("%w: %v", types.ErrInvalidPatch, err)
+	}
+	ent, err := dto.ToEntity()
+	if err != nil {
+		return nil, err
+	}
+	ent.ID = oldEnt.ID
+	ent.BaseCode = oldEnt.BaseCode
+	ent.Source = oldEnt.Source
+	ent.LastSyncedAt = oldEnt.LastSyncedAt
+
+	if err := s.repo.UpdateGnc(ent); err != nil {
+		return nil, err
+	}
+	s.notifyChange(baseCode, oldEnt, ent)
+	var updated types.GncDTO
+	_ = updated.FromEntity(ent)
+	return &updated, nil
+}
+
 func (s *gncServiceImpl) DeleteByBaseCode(baseCode string) error {
-	return s.repo.DeleteGncByBaseCode(baseCode)
+	oldEnt, err := s.repo.GetGncByBaseCode(baseCode)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteGncByBaseCode(baseCode); err != nil {
+		return err
+	}
+	s.notifyChange(baseCode, oldEnt, nil)
+	return nil
 }
 
 func (s *gncServiceImpl) List(page, size int64) ([]types.GncDTO, int64, error) {
@@ -135,126 +190,243 @@ func (s *gncServiceImpl) List(page, size int64) ([]types.GncDTO, int64, error) {
 	return result, total, nil
 }
 
-func (s *gncServiceImpl) SyncFromRemote(remoteURL string, pageSize int, bearerToken ...string) error {
+// SyncFromRemote 分页拉取某个商品目录来源并增量写入本地, 每次执行都会留下一条 GncSyncRunEntity
+func (s *gncServiceImpl) SyncFromRemote(trigger string, src types.CatalogSource, pageSize int) (*types.GncSyncRunEntity, error) {
+	mu := s.syncLock(src.Name())
+	if !mu.TryLock() {
+		return nil, types.NewConflictError("SYNC_RUNNING", fmt.Sprintf("gnc sync of %s already running", src.Name()), nil)
+	}
+	defer mu.Unlock()
+
 	if pageSize <= 0 {
 		pageSize = 5 // 默认每页抓取 5 条
 	}
-	page := 1
 
-	client := &http.Client{
-		Timeout: 10 * time.Second, // 超时时间可自行设定
+	// 1) 记录本次同步
+	run := &types.GncSyncRunEntity{
+		Trigger:   trigger,
+		Source:    src.Name(),
+		Status:    types.SyncRunRunning,
+		StartedAt: time.Now(),
 	}
-
-	// 若 Token 可选，通过 bearerToken 可变参数判断是否传入
-	var token string
-	if len(bearerToken) > 0 && bearerToken[0] != "" {
-		token = bearerToken[0]
+	if err := s.repo.CreateSyncRun(run); err != nil {
+		return nil, err
 	}
 
-	for {
-		// 1) 构造请求体： { "page": X, "size": Y }
-		reqBody := map[string]int{
-			"page": page,
-			"size": pageSize,
-		}
-		bodyBytes, _ := json.Marshal(reqBody)
+	// 2) 拉取并 upsert
+	seen := make(map[string]bool)
+	err := s.syncPages(run, src, pageSize, seen)
 
-		// 2) 发送 POST 请求
-		req, err := http.NewRequest("POST", remoteURL, bytes.NewReader(bodyBytes))
-		if err != nil {
-			return fmt.Errorf("failed to create request: %w", err)
-		}
-		req.Header.Set("Content-Type", "application/json")
+	// 3) 完整拉取后才检测上游删除, 否则没拉到的页会被误判为删除
+	if err == nil {
+		s.handleMissing(run, seen)
+	}
 
-		// 如果传入了 token，就加到 Header
-		if token != "" {
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-		}
+	// 4) 收尾
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Status = types.SyncRunSuccess
+	if err != nil {
+		run.Status = types.SyncRunFailed
+		run.Error = err.Error()
+	}
+	if saveErr := s.repo.SaveSyncRun(run); saveErr != nil {
+		log.Printf("[GncSync] save run %d failed: %v\n", run.ID, saveErr)
+	}
+	log.Printf("[GncSync] run %d %s %s: created=%d updated=%d unchanged=%d missing=%d failed=%d\n",
+		run.ID, run.Source, run.Status, run.Created, run.Updated, run.Unchanged, run.Missing, run.Failed)
+	return run, err
+}
 
-		resp, err := client.Do(req)
-		if err != nil {
-			return fmt.Errorf("failed to do request: %w", err)
-		}
-		// 使用完 resp 后记得关闭 body
-		defer resp.Body.Close()
-		// fmt.Printf("%s,%v", remoteURL, resp)
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("remote returned non-200 status: %d", resp.StatusCode)
-		}
+func (s *gncServiceImpl) ListSyncRuns(page, size int64) ([]types.GncSyncRunEntity, int64, error) {
+	return s.repo.ListSyncRuns(page, size)
+}
 
-		// 3) 解析返回 JSON
-		var remoteResp remoteGncResponse
-		if err := json.NewDecoder(resp.Body).Decode(&remoteResp); err != nil {
-			return fmt.Errorf("failed to decode remote response: %w", err)
-		}
+// syncLock 同一来源的手动与定时同步不能并发, 不同来源互不影响
+func (s *gncServiceImpl) syncLock(source string) *sync.Mutex {
+	mu, _ := s.syncMu.LoadOrStore(source, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
 
-		// 判断第三方响应 code
-		if remoteResp.Code != 200 {
-			return errors.New("remote response not success: " + remoteResp.Message)
+func (s *gncServiceImpl) syncPages(run *types.GncSyncRunEntity, src types.CatalogSource, pageSize int, seen map[string]bool) error {
+	for page := 1; ; page++ {
+		dataList, err := src.FetchPage(context.Background(), page, pageSize)
+		if err != nil {
+			return fmt.Errorf("page %d: %w", page, err)
 		}
 
-		// 4) 将 dataList 同步到本地 (Upsert)
-		for _, p := range remoteResp.Data.DataList {
-			err := s.upsertGncProduct(p)
+		// 将 dataList 同步到本地 (Upsert), 单条失败只计数
+		for _, raw := range dataList {
+			item, err := src.Map(raw)
+			if err != nil {
+				log.Printf("[GncSync] bad item %s: %v\n", string(raw), err)
+				run.Failed++
+				continue
+			}
+			seen[item.BaseCode] = true
+			outcome, err := s.upsertGncProduct(src.Name(), item, run.StartedAt)
 			if err != nil {
-				// 这里是你的业务逻辑决定: return error 或继续处理
-				fmt.Printf("Warn: upsert %s failed: %v\n", p.ProductId, err)
+				log.Printf("[GncSync] upsert %s failed: %v\n", item.BaseCode, err)
+				run.Failed++
+				continue
+			}
+			switch outcome {
+			case syncCreated:
+				run.Created++
+			case syncUpdated:
+				run.Updated++
+			default:
+				run.Unchanged++
 			}
 		}
 
 		// 如果本页数据量小于 pageSize，说明已到最后一页，无需再翻
-		gotCount := len(remoteResp.Data.DataList)
-		if gotCount < pageSize {
-			break
+		if len(dataList) < pageSize {
+			return nil
 		}
-
-		// 或者使用 total 判断:
-		// if page*pageSize >= remoteResp.Data.Total { break }
-
-		page++
 	}
-
-	return nil
 }
 
+const (
+	syncCreated   = "created"
+	syncUpdated   = "updated"
+	syncUnchanged = "unchanged"
+)
+
 // ---------------------
 // upsertGncProduct
 // ---------------------
-// 根据 productId 去查本地数据库，如果已存在 => update，否则 => create
-func (s *gncServiceImpl) upsertGncProduct(p remoteGncProduct) error {
-	oldEnt, err := s.repo.GetGncByBaseCode(p.ProductId)
-	if err != nil {
-		// not found 或错误
-		// 这里先简单判断是 "record not found" 还是别的错误
-		// 如果是 record not found，说明需要 create
-		// 否则返回 err
+// 根据 baseCode 去查本地数据库，如果已存在 => update(内容无变化则跳过)，否则 => create
+func (s *gncServiceImpl) upsertGncProduct(source string, item types.CatalogItem, syncedAt time.Time) (string, error) {
+	oldEnt, err := s.repo.GetGncByBaseCode(item.BaseCode)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
 	}
 
 	if oldEnt == nil || err != nil {
+		// 本地已删除(在回收站)的 base 不再由同步重新创建
+		if _, err := s.repo.GetTrashedGnc(item.BaseCode); err == nil {
+			return syncUnchanged, nil
+		}
 		// 需要 create
 		newEnt := &types.GncEntity{
-			BaseCode:     p.ProductId,
-			ProductName:  p.ProductName,
-			ProductType:  p.ProductType,
-			ParValue:     p.ParValue,
-			SalePrice:    p.SalePrice,
-			IsShelve:     p.IsShelve,
-			ProductDesc:  p.ProductDesc,
-			ProductCover: p.ProductCover,
-			// ProductPics 还未存储，需要你在 entity 里看如何持久化
-			OriginData: "", // 如果想存下第三方原始字段，可序列化 p 到 JSON
+			BaseCode:     item.BaseCode,
+			ProductName:  item.ProductName,
+			ProductType:  item.ProductType,
+			ParValue:     item.ParValue,
+			SalePrice:    item.SalePrice,
+			IsShelve:     item.IsShelve,
+			ProductDesc:  item.ProductDesc,
+			ProductCover: item.ProductCover,
+			ProductPics:  item.ProductPics,
+			UpstreamRaw:  item.Raw,
+			Source:       source,
+			LastSyncedAt: &syncedAt,
+		}
+		if err := s.repo.CreateGnc(newEnt); err != nil {
+			return "", err
 		}
-		return s.repo.CreateGnc(newEnt)
-	} else {
-		// update
-		oldEnt.ProductName = p.ProductName
-		oldEnt.ProductType = p.ProductType
-		oldEnt.ParValue = p.ParValue
-		oldEnt.SalePrice = p.SalePrice
-		oldEnt.IsShelve = p.IsShelve
-		oldEnt.ProductDesc = p.ProductDesc
-		oldEnt.ProductCover = p.ProductCover
-		// ...
-		return s.repo.UpdateGnc(oldEnt)
+		s.notifyChange(newEnt.BaseCode, nil, newEnt)
+		return syncCreated, nil
 	}
+
+	// 已由另一个来源同步的 base 不互相覆盖
+	if oldEnt.LastSyncedAt != nil && oldEnt.Source != "" && oldEnt.Source != source {
+		return "", types.NewConflictError("BASE_SYNCED_ELSEWHERE", fmt.Sprintf("baseCode already synced from %s", oldEnt.Source), nil)
+	}
+
+	// OriginData(手工配置的福禄商品ID)与未映射字段不由同步修改
+	item.KeepLocal(oldEnt)
+	if sameAsRemote(oldEnt, item) {
+		if oldEnt.LastSyncedAt == nil || oldEnt.Source != source {
+			return syncUnchanged, s.repo.MarkGncSynced(oldEnt.BaseCode, source, syncedAt)
+		}
+		return syncUnchanged, nil
+	}
+
+	// update
+	before := *oldEnt
+	oldEnt.ProductName = item.ProductName
+	oldEnt.ProductType = item.ProductType
+	oldEnt.ParValue = item.ParValue
+	oldEnt.SalePrice = item.SalePrice
+	oldEnt.IsShelve = item.IsShelve
+	oldEnt.ProductDesc = item.ProductDesc
+	oldEnt.ProductCover = item.ProductCover
+	oldEnt.ProductPics = item.ProductPics
+	oldEnt.UpstreamRaw = item.Raw
+	oldEnt.Source = source
+	oldEnt.LastSyncedAt = &syncedAt
+	if err := s.repo.UpdateGnc(oldEnt); err != nil {
+		return "", err
+	}
+	s.notifyChange(oldEnt.BaseCode, &before, oldEnt)
+	return syncUpdated, nil
+}
+
+func sameAsRemote(ent *types.GncEntity, item types.CatalogItem) bool {
+	if ent.ProductName != item.ProductName ||
+		ent.ProductType != item.ProductType ||
+		ent.ParValue != item.ParValue ||
+		ent.SalePrice != item.SalePrice ||
+		ent.IsShelve != item.IsShelve ||
+		ent.ProductDesc != item.ProductDesc ||
+		ent.ProductCover != item.ProductCover ||
+		ent.UpstreamRaw != item.Raw ||
+		len(ent.ProductPics) != len(item.ProductPics) {
+		return false
+	}
+	for i := range item.ProductPics {
+		if ent.ProductPics[i] != item.ProductPics[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// handleMissing 曾经从该来源同步过、本次没有返回的 base, 按 missingAction 处理
+func (s *gncServiceImpl) handleMissing(run *types.GncSyncRunEntity, seen map[string]bool) {
+	if len(seen) == 0 {
+		// 上游返回空列表更可能是故障, 不当成全部删除
+		log.Println("[GncSync] remote returned no products, skip missing detection")
+		return
+	}
+	synced, err := s.repo.ListSyncedGnc(run.Source)
+	if err != nil {
+		log.Printf("[GncSync] list synced gnc failed: %v\n", err)
+		run.Failed++
+		return
+	}
+	for i := range synced {
+		g := &synced[i]
+		if seen[g.BaseCode] {
+			continue
+		}
+		run.Missing++
+		if err := s.applyMissingAction(g); err != nil {
+			log.Printf("[GncSync] %s missing upstream, %s failed: %v\n", g.BaseCode, s.missingAction, err)
+			run.Failed++
+		}
+	}
+}
+
+func (s *gncServiceImpl) applyMissingAction(g *types.GncEntity) error {
+	switch s.missingAction {
+	case types.MissingActionDelete:
+		if err := s.repo.DeleteGncByBaseCode(g.BaseCode); err != nil {
+			return err
+		}
+		s.notifyChange(g.BaseCode, g, nil)
+	case types.MissingActionUnshelve:
+		if g.IsShelve == 0 {
+			return nil
+		}
+		before := *g
+		g.IsShelve = 0
+		if err := s.repo.UpdateGnc(g); err != nil {
+			return err
+		}
+		s.notifyChange(g.BaseCode, &before, g)
+	}
+	return nil
 }
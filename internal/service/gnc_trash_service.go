@@ -0,0 +1,75 @@
+// internal/service/gnc_trash_service.go
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+	"gorm.io/gorm"
+)
+
+// ListTrash 回收站中的 base, 带删除时间
+func (s *gncServiceImpl) ListTrash(page, size int64) ([]types.GncDTO, int64, error) {
+	ents, total, err := s.repo.ListTrashedGnc(page, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	result := make([]types.GncDTO, len(ents))
+	for i := range ents {
+		_ = result[i].FromEntity(&ents[i])
+	}
+	return result, total, nil
+}
+
+// RestoreByBaseCode 移出回收站, 依赖它的 pub 按新增 base 联动
+func (s *gncServiceImpl) RestoreByBaseCode(baseCode string) (*types.GncDTO, error) {
+	// base_code 没有唯一索引, 删除后又新建了同编码的 base 时不能恢复
+	if _, err := s.repo.GetGncByBaseCode(baseCode); err == nil {
+		return nil, types.NewConflictError("BASE_EXISTS", fmt.Sprintf("base %s already exists", baseCode), nil)
+	}
+	if err := s.repo.RestoreGnc(baseCode); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, types.NewNotFoundError("NOT_IN_TRASH", fmt.Sprintf("base %s is not in trash", baseCode), err)
+		}
+		return nil, err
+	}
+	ent, err := s.repo.GetGncByBaseCode(baseCode)
+	if err != nil {
+		return nil, err
+	}
+	s.notifyChange(baseCode, nil, ent)
+	var dto types.GncDTO
+	_ = dto.FromEntity(ent)
+	return &dto, nil
+}
+
+// PurgeByBaseCode 永久删除回收站中的 base; 仍被 pub 组合引用(含回收站中的 pub), 或最近 purgeGuardDays 天内有订单引用时拒绝
+func (s *gncServiceImpl) PurgeByBaseCode(baseCode string) error {
+	if _, err := s.repo.GetTrashedGnc(baseCode); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return types.NewNotFoundError("NOT_IN_TRASH", fmt.Sprintf("base %s is not in trash", baseCode), err)
+		}
+		return err
+	}
+	// 组合快照依赖 base 的上下文, 先改组合或永久删除这些 pub
+	pubs, err := s.repo.CountPubsByBaseCode(baseCode)
+	if err != nil {
+		return err
+	}
+	if pubs > 0 {
+		return fmt.Errorf("%w: %d pubs", types.ErrReferencedByPubs, pubs)
+	}
+	if s.purgeGuardDays > 0 {
+		since := time.Now().AddDate(0, 0, -s.purgeGuardDays)
+		n, err := s.repo.CountOrdersSinceByBaseCode(baseCode, since)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("%w: %d orders in the last %d days", types.ErrReferencedByOrders, n, s.purgeGuardDays)
+		}
+	}
+	return s.repo.PurgeGnc(baseCode)
+}
@@ -0,0 +1,151 @@
+// internal/service/health_service.go
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"gorm.io/gorm"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+// HealthService 存活/就绪检查
+type HealthService interface {
+	// Liveness 进程内部是否还在工作(如查单调度循环), 失败应重启
+	Liveness(ctx context.Context) types.HealthReport
+	// Readiness 依赖是否可用, 关键依赖异常时不应再接流量
+	Readiness(ctx context.Context) types.HealthReport
+}
+
+type healthServiceImpl struct {
+	timeout   time.Duration
+	liveness  []types.HealthCheck
+	readiness []types.HealthCheck
+}
+
+var _ HealthService = (*healthServiceImpl)(nil)
+
+// NewHealthService timeout 为单项检查的超时
+func NewHealthService(timeout time.Duration, liveness, readiness []types.HealthCheck) HealthService {
+	return &healthServiceImpl{timeout: timeout, liveness: liveness, readiness: readiness}
+}
+
+func (s *healthServiceImpl) Liveness(ctx context.Context) types.HealthReport {
+	return s.run(ctx, s.liveness)
+}
+
+func (s *healthServiceImpl) Readiness(ctx context.Context) types.HealthReport {
+	return s.run(ctx, s.readiness)
+}
+
+// run 并发执行各项检查, 每项单独超时, 慢的依赖不拖住其它检查
+func (s *healthServiceImpl) run(ctx context.Context, checks []types.HealthCheck) types.HealthReport {
+	report := types.HealthReport{
+		Status:    types.HealthUp,
+		CheckedAt: time.Now(),
+		Checks:    make(map[string]types.HealthCheckResult, len(checks)),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, hc := range checks {
+		wg.Add(1)
+		go func(hc types.HealthCheck) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := hc.Check(cctx)
+			res := types.HealthCheckResult{
+				Status:    types.HealthUp,
+				Critical:  hc.Critical,
+				LatencyMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				res.Status = types.HealthDown
+				res.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[hc.Name] = res
+			switch {
+			case err == nil:
+			case hc.Critical:
+				report.Status = types.HealthDown
+			case report.Status == types.HealthUp:
+				report.Status = types.HealthDegraded
+			}
+		}(hc)
+	}
+	wg.Wait()
+	return report
+}
+
+// ========== 常用依赖检查 ==========
+
+// DBHealthCheck MySQL ping
+func DBHealthCheck(db *gorm.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}
+}
+
+// ESHealthCheck 集群健康非 red, 且索引(或别名)都存在; client 为 nil 说明启动时就没连上
+func ESHealthCheck(es *elasticsearch.Client, indices ...string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if es == nil {
+			return errors.New("elasticsearch client is not initialised")
+		}
+
+		// 1) 集群健康
+		resp, err := es.Cluster.Health(es.Cluster.Health.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("cluster health: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.IsError() {
+			return fmt.Errorf("cluster health status: %s", resp.Status())
+		}
+		var health struct {
+			Status string `json:"status"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+			return fmt.Errorf("decode cluster health: %w", err)
+		}
+		if health.Status == "red" {
+			return errors.New("cluster status is red")
+		}
+
+		// 2) 索引 / 别名, HEAD /<name> 对两者都适用
+		var missing []string
+		for _, name := range indices {
+			res, err := es.Indices.Exists([]string{name}, es.Indices.Exists.WithContext(ctx))
+			if err != nil {
+				return fmt.Errorf("check index %s: %w", name, err)
+			}
+			res.Body.Close()
+			if res.StatusCode == http.StatusNotFound {
+				missing = append(missing, name)
+			} else if res.IsError() {
+				return fmt.Errorf("check index %s: %s", name, res.Status())
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("index or alias not found: %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}
+}
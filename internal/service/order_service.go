@@ -4,16 +4,20 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"strings"
+	"log/slog"
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 
+	"10000hk.com/vip_gift/internal/metrics"
 	"10000hk.com/vip_gift/internal/repository"
 	"10000hk.com/vip_gift/internal/types"
+	"10000hk.com/vip_gift/pkg"
 )
 
 // OrderService 定义订单接口
@@ -32,9 +36,19 @@ type OrderService interface {
 
 	// ListOrder 分页获取订单列表
 	// ListOrder(ctx context.Context, page, size int64) ([]types.OrderDTO, int64, error)
-	ListOrder(ctx context.Context, page, size int64, orderIds, downstreamIds []string) ([]types.OrderDTO, int64, error)
+	// userSn 非空时只列出该渠道及其直属下级渠道的订单
+	ListOrder(ctx context.Context, page, size int64, orderIds, downstreamIds []string, userSn string) ([]types.OrderDTO, int64, error)
 	// PublishOrderUpdate 发送订单更新消息
 	PublishOrderUpdate(ctx context.Context, downstreamOrderId string, message []byte) error
+
+	// 熔断期间暂存的订单, 由消费端定时领取后重新下单; 领取超过 lease 仍未写入新状态的可再次领取
+	ListParkedOrders(ctx context.Context, limit int, lease time.Duration) ([]types.OrderEntity, int64, error)
+	ClaimParkedOrder(ctx context.Context, orderId string, lease time.Duration) (bool, error)
+
+	// 组合订单子单
+	CreateOrderItems(ctx context.Context, items []types.OrderItemEntity) error
+	ListOrderItems(ctx context.Context, orderId string) ([]types.OrderItemEntity, error)
+	UpdateOrderItem(ctx context.Context, item *types.OrderItemEntity) error
 }
 
 // orderServiceImpl
@@ -42,17 +56,19 @@ type orderServiceImpl struct {
 	repo        repository.OrderRepo
 	kafkaWriter *kafka.Writer
 	snowflakeFn func() string
+	stock       StockReserver // 库存/限购预占, 可为 nil
 	// esClient   *elasticsearch.Client (如需写ES可加)
 }
 
 var _ OrderService = (*orderServiceImpl)(nil)
 
 // NewOrderService
-func NewOrderService(repo repository.OrderRepo, kWriter *kafka.Writer, sfFn func() string) OrderService {
+func NewOrderService(repo repository.OrderRepo, kWriter *kafka.Writer, sfFn func() string, stock StockReserver) OrderService {
 	return &orderServiceImpl{
 		repo:        repo,
 		kafkaWriter: kWriter,
 		snowflakeFn: sfFn,
+		stock:       stock,
 	}
 }
 
@@ -61,7 +77,7 @@ func NewOrderService(repo repository.OrderRepo, kWriter *kafka.Writer, sfFn func
 // -------------------------------------------------------------------
 func (s *orderServiceImpl) CreateOrder(ctx context.Context, dto *types.OrderDTO) (*types.OrderDTO, error) {
 	if dto.DownstreamOrderId == "" {
-		return nil, fmt.Errorf("[vip order-service] downstreamOrderId is required")
+		return nil, types.NewValidationError("downstreamOrderId is required")
 		// generatedDsId := fmt.Sprintf("DS-%d", generateRandom()) // 你可以用 Snowflake 等更好的生成
 		// dto.DownstreamOrderId = generatedDsId
 		// log.Printf("[CreateOrder] No downstreamOrderId provided, generated one: %s\n", generatedDsId)
@@ -79,21 +95,32 @@ func (s *orderServiceImpl) CreateOrder(ctx context.Context, dto *types.OrderDTO)
 		orderId = fmt.Sprintf("VIP-%d", generateRandom())
 	}
 	dto.OrderId = orderId
+	ctx = pkg.WithOrderIDs(ctx, orderId, dto.DownstreamOrderId)
 
 	// 只发Kafka (本模式)
 	if s.kafkaWriter == nil {
-		return nil, fmt.Errorf("kafkaWriter is nil, can't produce message")
+		return nil, types.NewUnavailableError("ORDER_QUEUE_UNAVAILABLE", "order queue is unavailable", errors.New("kafkaWriter is nil"))
+	}
+
+	// 预占库存 & 限购额度, 不满足直接拒单
+	if s.stock != nil {
+		if err := s.stock.ReserveStock(ctx, types.StockReservation{
+			OrderId:    orderId,
+			PublicCode: dto.PublicCode,
+			Phone:      dto.Phone,
+			UserSn:     dto.UserSn,
+		}); err != nil {
+			return nil, err
+		}
 	}
+
 	msgBytes, _ := json.Marshal(dto)
-	err := s.kafkaWriter.WriteMessages(ctx, kafka.Message{
-		Key:   []byte(orderId),
-		Value: msgBytes,
-		Topic: "vip-order-create",
-	})
+	err := s.publish(ctx, "vip-order-create", []byte(orderId), msgBytes)
 	if err != nil {
-		return nil, fmt.Errorf("kafka produce error: %v", err)
+		s.releaseStock(ctx, orderId)
+		return nil, types.NewUnavailableError("ORDER_QUEUE_UNAVAILABLE", "order queue is unavailable", err)
 	}
-	log.Printf("[CreateOrder] order %s produced to Kafka (no DB write)\n", orderId)
+	slog.InfoContext(ctx, "order produced to kafka")
 
 	return dto, nil
 }
@@ -105,16 +132,15 @@ func (s *orderServiceImpl) StoreToDB(ctx context.Context, dto *types.OrderDTO) e
 	if dto.OrderId == "" {
 		return fmt.Errorf("StoreToDB: orderId is required")
 	}
+	ctx = pkg.WithOrderIDs(ctx, dto.OrderId, dto.DownstreamOrderId)
 
 	// 先到 Repo 查一下
 	existing, err := s.repo.GetOrderByOrderId(dto.OrderId)
-	// 注意，目前 GetOrderByOrderId 如果找不到，会返回自定义错误 "订单不存在, orderId=xxx"
-	// 你也可以让它原样返回 gorm.ErrRecordNotFound，以便用 errors.Is 来判断。
+	// GetOrderByOrderId 找不到时包装 gorm.ErrRecordNotFound
 
 	if err != nil {
-		// 若是“订单不存在”类的错误 => 说明尚无记录 => 执行插入
-		if err.Error() != "" &&
-			(strings.Contains(err.Error(), "订单不存在") /* 或者 errors.Is(err, gorm.ErrRecordNotFound) */) {
+		// 订单不存在 => 说明尚无记录 => 执行插入
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			// 插入
 			newEnt := &types.OrderEntity{
 				OrderId:           dto.OrderId,           // 初次创建时可使用
@@ -128,11 +154,18 @@ func (s *orderServiceImpl) StoreToDB(ctx context.Context, dto *types.OrderDTO) e
 				CommissionSelf:    dto.CommissionSelf,
 				CommissionParent:  dto.CommissionParent,
 				Channel:           dto.Channel,
+				PublicCode:        dto.PublicCode,
+				SalePrice:         dto.SalePrice,
+				ParValue:          dto.ParValue,
+				CommissionMF:      dto.CommissionMF,
 			}
 			if errC := s.repo.CreateOrder(newEnt); errC != nil {
 				return fmt.Errorf("StoreToDB: create error: %w", errC)
 			}
-			log.Printf("[StoreToDB] new order %s inserted.\n", dto.OrderId)
+			slog.InfoContext(ctx, "order inserted", "status", int64(dto.Status))
+			if isFailStatus(dto.Status) {
+				s.releaseStock(ctx, dto.OrderId)
+			}
 			return nil
 		}
 		// 如果是其它错误，就直接返回
@@ -144,26 +177,44 @@ func (s *orderServiceImpl) StoreToDB(ctx context.Context, dto *types.OrderDTO) e
 	// ================
 
 	// 只更新可变的字段( Status / Remark 等)
+	wasFailed := isFailStatus(existing.Status)
 	existing.Status = dto.Status
 	existing.Remark = dto.Remark
+	existing.ClaimedAt = nil // 写入新状态即结束暂存订单的领取
 
 	if errU := s.repo.UpdateOrder(existing); errU != nil {
 		return fmt.Errorf("StoreToDB: update error: %w", errU)
 	}
-	log.Printf("[StoreToDB] order %s updated in DB\n", dto.OrderId)
+	slog.InfoContext(ctx, "order updated", "status", int64(dto.Status))
 
+	// 订单由非失败变为失败 => 释放库存/限购预占; 之后再保存失败状态不重复释放
+	if isFailStatus(dto.Status) && !wasFailed {
+		s.releaseStock(ctx, dto.OrderId)
+	}
 	return nil
 }
 
+// isFailStatus 终态失败: 下单失败, 或查单确认上游失败; 查单请求本身出错不写失败状态
+func isFailStatus(status types.OrderStatus) bool {
+	return status == types.StatusDownstreamFail || status == types.StatusUpstreamFail
+}
+
+// releaseStock 释放失败只记日志, 不影响订单状态落库
+func (s *orderServiceImpl) releaseStock(ctx context.Context, orderId string) {
+	if s.stock == nil {
+		return
+	}
+	if err := s.stock.ReleaseStock(ctx, orderId); err != nil {
+		slog.ErrorContext(pkg.WithOrderIDs(ctx, orderId, ""), "release stock failed", "error", err)
+	}
+}
+
 // -------------------------------------------------------------------
 // 3) GetOrder: 根据orderId查询订单
 // -------------------------------------------------------------------
 func (s *orderServiceImpl) GetOrder(ctx context.Context, orderId string) (*types.OrderDTO, error) {
 	ent, err := s.repo.GetOrderByOrderId(orderId)
 	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return nil, fmt.Errorf("订单不存在: %s", orderId)
-		}
 		return nil, err
 	}
 	dto := &types.OrderDTO{
@@ -181,6 +232,22 @@ func (s *orderServiceImpl) GetOrder(ctx context.Context, orderId string) (*types
 		RefundStatus:      ent.RefundStatus,
 		DeliveryStatus:    ent.DeliveryStatus,
 		SettlementStatus:  ent.SettlementStatus,
+		Channel:           ent.Channel,
+		PublicCode:        ent.PublicCode,
+		SalePrice:         ent.SalePrice,
+		ParValue:          ent.ParValue,
+		CommissionMF:      ent.CommissionMF,
+	}
+
+	// 组合订单: 附带子单, 便于看到哪个组合失败
+	items, err := s.repo.ListOrderItems(ent.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		var item types.OrderItemDTO
+		item.FromEntity(&items[i])
+		dto.Items = append(dto.Items, item)
 	}
 	return dto, nil
 }
@@ -213,8 +280,8 @@ func (s *orderServiceImpl) UpdateOrder(ctx context.Context, ent *types.OrderEnti
 
 	return s.repo.UpdateOrder(ent)
 }
-func (s *orderServiceImpl) ListOrder(ctx context.Context, page, size int64, orderIds, downstreamIds []string) ([]types.OrderDTO, int64, error) {
-	ents, total, err := s.repo.ListOrder(page, size, orderIds, downstreamIds)
+func (s *orderServiceImpl) ListOrder(ctx context.Context, page, size int64, orderIds, downstreamIds []string, userSn string) ([]types.OrderDTO, int64, error) {
+	ents, total, err := s.repo.ListOrder(page, size, orderIds, downstreamIds, userSn)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -234,6 +301,11 @@ func (s *orderServiceImpl) ListOrder(ctx context.Context, page, size int64, orde
 			RefundStatus:      e.RefundStatus,
 			DeliveryStatus:    e.DeliveryStatus,
 			SettlementStatus:  e.SettlementStatus,
+			Channel:           e.Channel,
+			PublicCode:        e.PublicCode,
+			SalePrice:         e.SalePrice,
+			ParValue:          e.ParValue,
+			CommissionMF:      e.CommissionMF,
 		}
 	}
 	return dtos, total, nil
@@ -254,9 +326,44 @@ func generateRandom() int64 {
 	return 100000 + time.Now().UnixNano()%100000
 }
 func (s *orderServiceImpl) PublishOrderUpdate(ctx context.Context, downstreamOrderId string, message []byte) error {
-	return s.kafkaWriter.WriteMessages(ctx, kafka.Message{
-		Key:   []byte(downstreamOrderId),
-		Value: message,
-		Topic: "vip-order-update",
+	return s.publish(ctx, "vip-order-update", []byte(downstreamOrderId), message)
+}
+
+// publish 写 Kafka: 开 producer span, 消息头带上关联 ID 与 traceparent, 消费者的 span 接在它下面
+func (s *orderServiceImpl) publish(ctx context.Context, topic string, key, value []byte) error {
+	ctx, span := pkg.Tracer().Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(semconv.MessagingSystemKafka, semconv.MessagingDestinationName(topic)),
+	)
+	defer span.End()
+
+	err := s.kafkaWriter.WriteMessages(ctx, kafka.Message{
+		Key:     key,
+		Value:   value,
+		Topic:   topic,
+		Headers: pkg.KafkaHeaders(ctx),
 	})
+	pkg.RecordSpanError(ctx, err)
+	metrics.KafkaProduced.WithLabelValues(topic, metrics.Result(err)).Inc()
+	return err
+}
+
+func (s *orderServiceImpl) ListParkedOrders(ctx context.Context, limit int, lease time.Duration) ([]types.OrderEntity, int64, error) {
+	return s.repo.ListParkedOrders(limit, time.Now().Add(-lease))
+}
+
+func (s *orderServiceImpl) ClaimParkedOrder(ctx context.Context, orderId string, lease time.Duration) (bool, error) {
+	return s.repo.ClaimParkedOrder(orderId, time.Now().Add(-lease))
+}
+
+func (s *orderServiceImpl) CreateOrderItems(ctx context.Context, items []types.OrderItemEntity) error {
+	return s.repo.CreateOrderItems(items)
+}
+
+func (s *orderServiceImpl) ListOrderItems(ctx context.Context, orderId string) ([]types.OrderItemEntity, error) {
+	return s.repo.ListOrderItems(orderId)
+}
+
+func (s *orderServiceImpl) UpdateOrderItem(ctx context.Context, item *types.OrderItemEntity) error {
+	return s.repo.UpdateOrderItem(item)
 }
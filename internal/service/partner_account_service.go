@@ -0,0 +1,184 @@
+// internal/service/partner_account_service.go
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"10000hk.com/vip_gift/internal/types"
+	"gorm.io/gorm"
+)
+
+// maxPartnerDepth 向上查找上级的最大层数, 防止脏数据成环时死循环
+const maxPartnerDepth = 32
+
+// CreatePartner 登记渠道; 有上级时上级必须已存在, level = 上级 level + 1
+func (s *partnerServiceImpl) CreatePartner(dto *types.PartnerDTO) (*types.PartnerEntity, error) {
+	if dto.UserSn == "" || dto.Name == "" {
+		return nil, types.NewValidationError("userSn and name are required")
+	}
+	ent := &types.PartnerEntity{
+		UserSn:          dto.UserSn,
+		CommissionShare: types.DefaultCommissionShare,
+	}
+	if err := s.applyPartnerDTO(ent, dto); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreatePartner(ent); err != nil {
+		return nil, err
+	}
+	return ent, nil
+}
+
+func (s *partnerServiceImpl) GetPartner(userSn string) (*types.PartnerEntity, error) {
+	return s.repo.GetPartner(userSn)
+}
+
+// UpdatePartner 整体替换可编辑字段(userSn 不可改); 上级变化时重算本级及下级的 level
+func (s *partnerServiceImpl) UpdatePartner(userSn string, dto *types.PartnerDTO) (*types.PartnerEntity, error) {
+	ent, err := s.repo.GetPartner(userSn)
+	if err != nil {
+		return nil, err
+	}
+	oldLevel := ent.Level
+	if dto.Name == "" {
+		dto.Name = ent.Name
+	}
+	if dto.Status == "" {
+		dto.Status = ent.Status
+	}
+	if err := s.applyPartnerDTO(ent, dto); err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdatePartner(ent); err != nil {
+		return nil, err
+	}
+	if ent.Level != oldLevel {
+		if err := s.repo.UpdateChildLevels(ent.UserSn, ent.Level); err != nil {
+			return nil, err
+		}
+	}
+	return ent, nil
+}
+
+// SetPartnerStatus active / suspended; 停用后不能下单, 其下级的上级分佣也不再发放
+func (s *partnerServiceImpl) SetPartnerStatus(userSn, status string) error {
+	if status != types.PartnerActive && status != types.PartnerSuspended {
+		return types.NewValidationError(fmt.Sprintf("invalid status: %s", status))
+	}
+	ent, err := s.repo.GetPartner(userSn)
+	if err != nil {
+		return err
+	}
+	ent.Status = status
+	return s.repo.UpdatePartner(ent)
+}
+
+// DeletePartner 只允许删除没有下级、也没有订单的渠道; 否则请改为 suspended
+func (s *partnerServiceImpl) DeletePartner(userSn string) error {
+	children, err := s.repo.CountChildPartners(userSn)
+	if err != nil {
+		return err
+	}
+	orders, err := s.repo.CountPartnerOrders(userSn)
+	if err != nil {
+		return err
+	}
+	if children > 0 || orders > 0 {
+		return fmt.Errorf("%w: %d children, %d orders", types.ErrPartnerHasRelations, children, orders)
+	}
+	return s.repo.DeletePartner(userSn)
+}
+
+func (s *partnerServiceImpl) ListPartners(parentSn, status string, page, size int64) ([]types.PartnerEntity, int64, error) {
+	return s.repo.ListPartners(parentSn, status, page, size)
+}
+
+// AuthorizeOrder 下单前校验渠道: 必须已登记、active 且允许该业务线;
+// 返回渠道及其上级(没有上级时为 nil), 供订单归属与分佣使用
+func (s *partnerServiceImpl) AuthorizeOrder(userSn, channel string) (*types.PartnerEntity, *types.PartnerEntity, error) {
+	partner, err := s.repo.GetPartner(userSn)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, fmt.Errorf("%w: %s is not registered", types.ErrPartnerInactive, userSn)
+		}
+		return nil, nil, err
+	}
+	if partner.Status != types.PartnerActive {
+		return nil, nil, fmt.Errorf("%w: %s is %s", types.ErrPartnerInactive, userSn, partner.Status)
+	}
+	if !partner.AllowsChannel(channel) {
+		return nil, nil, fmt.Errorf("%w: %s", types.ErrChannelNotAllowed, channel)
+	}
+	if partner.ParentSn == "" {
+		return partner, nil, nil
+	}
+	parent, err := s.repo.GetPartner(partner.ParentSn)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return partner, nil, nil
+		}
+		return nil, nil, err
+	}
+	return partner, parent, nil
+}
+
+// applyPartnerDTO 校验并写入可编辑字段, 同时按上级推导 level
+func (s *partnerServiceImpl) applyPartnerDTO(ent *types.PartnerEntity, dto *types.PartnerDTO) error {
+	status := dto.Status
+	if status == "" {
+		status = types.PartnerActive
+	}
+	if status != types.PartnerActive && status != types.PartnerSuspended {
+		return types.NewValidationError(fmt.Sprintf("invalid status: %s", status))
+	}
+	for _, c := range dto.AllowedChannels {
+		if c != types.CatalogGift && c != types.CatalogCharge {
+			return types.NewValidationError(fmt.Sprintf("invalid channel: %s", c))
+		}
+	}
+	if dto.CommissionShare != nil {
+		if *dto.CommissionShare < 0 || *dto.CommissionShare > 1 {
+			return types.NewValidationError("commissionShare must be between 0 and 1")
+		}
+		ent.CommissionShare = *dto.CommissionShare
+	}
+
+	if dto.RateLimit < 0 || dto.DailyOrderQuota < 0 || dto.DailyAmountCap < 0 {
+		return types.NewValidationError("rateLimit and quotas must not be negative")
+	}
+
+	// 上级: 必须存在, 且不能是自己或自己的下级
+	ent.Level = 1
+	if dto.ParentSn != "" {
+		parent, err := s.repo.GetPartner(dto.ParentSn)
+		if err != nil {
+			return fmt.Errorf("parent %s not found: %w", dto.ParentSn, err)
+		}
+		for p, depth := parent, 0; p != nil && depth < maxPartnerDepth; depth++ {
+			if p.UserSn == ent.UserSn {
+				return types.NewValidationError("parentSn would create a cycle")
+			}
+			if p.ParentSn == "" {
+				break
+			}
+			if p, err = s.repo.GetPartner(p.ParentSn); err != nil {
+				break
+			}
+		}
+		ent.Level = parent.Level + 1
+	}
+
+	ent.Name = dto.Name
+	ent.ParentSn = dto.ParentSn
+	ent.Status = status
+	ent.AllowedChannels = dto.AllowedChannels
+	ent.RateLimit = dto.RateLimit
+	ent.DailyOrderQuota = dto.DailyOrderQuota
+	ent.DailyAmountCap = dto.DailyAmountCap
+	ent.ContactName = dto.ContactName
+	ent.ContactPhone = dto.ContactPhone
+	ent.ContactEmail = dto.ContactEmail
+	ent.Remark = dto.Remark
+	return nil
+}
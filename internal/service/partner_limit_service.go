@@ -0,0 +1,70 @@
+// internal/service/partner_limit_service.go
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+// AllowRequest 按渠道的每秒请求数限流, 计数存 MySQL, 多副本共享
+func (s *partnerServiceImpl) AllowRequest(userSn string) error {
+	limit := s.defaultRateLimit
+	if partner, err := s.repo.GetPartner(userSn); err == nil && partner.RateLimit > 0 {
+		limit = partner.RateLimit
+	}
+	if limit <= 0 {
+		return nil
+	}
+	n, err := s.repo.HitRateWindow(userSn, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if n > limit {
+		return &types.RetryAfterError{
+			Err:        types.ErrRateLimited,
+			Reason:     fmt.Sprintf("%d requests per second", limit),
+			RetryAfter: time.Second,
+		}
+	}
+	return nil
+}
+
+// ReserveQuota 预占当日订单数与金额配额, 返回预占的日期供 ReleaseQuota 使用;
+// 渠道未配置配额时不预占, 返回空串
+func (s *partnerServiceImpl) ReserveQuota(partner *types.PartnerEntity, amount float64) (string, error) {
+	if partner.DailyOrderQuota <= 0 && partner.DailyAmountCap <= 0 {
+		return "", nil
+	}
+	now := time.Now()
+	day := now.Format("2006-01-02")
+	err := s.repo.ReserveDailyUsage(partner.UserSn, day, amount, partner.DailyOrderQuota, partner.DailyAmountCap)
+	if err != nil {
+		if errors.Is(err, types.ErrQuotaExceeded) {
+			// 配额按自然日重置
+			tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+			return "", &types.RetryAfterError{Err: err, RetryAfter: tomorrow.Sub(now)}
+		}
+		return "", err
+	}
+	return day, nil
+}
+
+// ReleaseQuota 下单失败时退回 ReserveQuota 预占的用量
+func (s *partnerServiceImpl) ReleaseQuota(ctx context.Context, userSn, day string, amount float64) {
+	if day == "" {
+		return
+	}
+	if err := s.repo.ReleaseDailyUsage(userSn, day, amount); err != nil {
+		slog.WarnContext(ctx, "release partner quota failed", "userSn", userSn, "day", day, "error", err)
+	}
+}
+
+// PurgeRateWindows 每秒计数只需保留最近一分钟
+func (s *partnerServiceImpl) PurgeRateWindows() (int64, error) {
+	return s.repo.PurgeRateWindows(time.Now().Add(-time.Minute).Unix())
+}
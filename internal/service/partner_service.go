@@ -0,0 +1,164 @@
+// internal/service/partner_service.go
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"10000hk.com/vip_gift/internal/repository"
+	"10000hk.com/vip_gift/internal/types"
+	"gorm.io/gorm"
+)
+
+type PartnerService interface {
+	// 渠道账户 (partner_account_service.go)
+	CreatePartner(dto *types.PartnerDTO) (*types.PartnerEntity, error)
+	GetPartner(userSn string) (*types.PartnerEntity, error)
+	UpdatePartner(userSn string, dto *types.PartnerDTO) (*types.PartnerEntity, error)
+	SetPartnerStatus(userSn, status string) error
+	DeletePartner(userSn string) error
+	ListPartners(parentSn, status string, page, size int64) ([]types.PartnerEntity, int64, error)
+	AuthorizeOrder(userSn, channel string) (*types.PartnerEntity, *types.PartnerEntity, error)
+
+	// 密钥管理
+	IssueKey(userSn, remark string) (*types.PartnerKeyEntity, string, error)
+	ListKeys(userSn string, page, size int64) ([]types.PartnerKeyEntity, int64, error)
+	SetKeyStatus(appKey string, status int64) error
+
+	// 验签
+	VerifyRequest(req types.SignedRequest) (*types.PartnerKeyEntity, error)
+	PurgeExpiredNonces() (int64, error)
+
+	// 限流 & 配额 (partner_limit_service.go)
+	AllowRequest(userSn string) error
+	ReserveQuota(partner *types.PartnerEntity, amount float64) (string, error)
+	ReleaseQuota(ctx context.Context, userSn, day string, amount float64)
+	PurgeRateWindows() (int64, error)
+}
+
+type partnerServiceImpl struct {
+	repo             repository.PartnerRepo
+	defaultRateLimit int64 // 渠道未配置 RateLimit 时的每秒请求数
+}
+
+// NewPartnerService defaultRateLimit 见 types.ParsePartnerRateLimit
+func NewPartnerService(repo repository.PartnerRepo, defaultRateLimit int64) PartnerService {
+	return &partnerServiceImpl{repo: repo, defaultRateLimit: defaultRateLimit}
+}
+
+// IssueKey 为已登记的渠道签发一对 appKey/secret; secret 只在这里返回一次
+func (s *partnerServiceImpl) IssueKey(userSn, remark string) (*types.PartnerKeyEntity, string, error) {
+	if userSn == "" {
+		return nil, "", types.NewValidationError("userSn is required")
+	}
+	if _, err := s.repo.GetPartner(userSn); err != nil {
+		return nil, "", fmt.Errorf("partner %s not found: %w", userSn, err)
+	}
+	appKey, err := randomHex(16)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	ent := &types.PartnerKeyEntity{
+		AppKey: "pk_" + appKey,
+		Secret: secret,
+		UserSn: userSn,
+		Status: types.PartnerKeyActive,
+		Remark: remark,
+	}
+	if err := s.repo.CreatePartnerKey(ent); err != nil {
+		return nil, "", err
+	}
+	return ent, secret, nil
+}
+
+func (s *partnerServiceImpl) ListKeys(userSn string, page, size int64) ([]types.PartnerKeyEntity, int64, error) {
+	return s.repo.ListPartnerKeys(userSn, page, size)
+}
+
+func (s *partnerServiceImpl) SetKeyStatus(appKey string, status int64) error {
+	if status != types.PartnerKeyActive && status != types.PartnerKeyDisabled {
+		return types.NewValidationError(fmt.Sprintf("invalid status: %d", status))
+	}
+	return s.repo.UpdatePartnerKeyStatus(appKey, status)
+}
+
+// VerifyRequest 校验下游签名:
+// 1) 时间戳在 PartnerSignWindow 内
+// 2) appKey 存在且启用
+// 3) HMAC 一致
+// 4) nonce 未使用过(验签通过后才占用, 避免伪造请求耗尽他人的 nonce)
+func (s *partnerServiceImpl) VerifyRequest(req types.SignedRequest) (*types.PartnerKeyEntity, error) {
+	if req.AppKey == "" || req.Timestamp == "" || req.Nonce == "" || req.Signature == "" {
+		return nil, fmt.Errorf("%w: missing signature headers", types.ErrInvalidSignature)
+	}
+	if len(req.Nonce) > 64 {
+		return nil, fmt.Errorf("%w: nonce too long", types.ErrInvalidSignature)
+	}
+
+	// 1) 时间戳
+	ts, err := strconv.ParseInt(req.Timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad timestamp", types.ErrInvalidSignature)
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > types.PartnerSignWindow || skew < -types.PartnerSignWindow {
+		return nil, types.ErrReplayedRequest
+	}
+
+	// 2) appKey
+	key, err := s.repo.GetPartnerKey(req.AppKey)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: unknown appKey", types.ErrInvalidSignature)
+		}
+		return nil, err
+	}
+	if key.Status != types.PartnerKeyActive {
+		return nil, types.ErrPartnerDisabled
+	}
+
+	// 3) 签名
+	expected := types.SignPartnerRequest(key.Secret, req.Method, req.Path, req.Timestamp, req.Nonce, req.Body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(req.Signature))) {
+		return nil, types.ErrInvalidSignature
+	}
+
+	// 4) nonce
+	fresh, err := s.repo.UseNonce(key.AppKey, req.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, types.ErrReplayedRequest
+	}
+
+	if err := s.repo.TouchPartnerKey(key.AppKey, time.Now()); err != nil {
+		log.Printf("[PartnerService] touch %s failed: %v\n", key.AppKey, err)
+	}
+	return key, nil
+}
+
+// PurgeExpiredNonces nonce 只需保留一个时间窗(多留一倍余量)
+func (s *partnerServiceImpl) PurgeExpiredNonces() (int64, error) {
+	return s.repo.PurgeNonces(time.Now().Add(-2 * types.PartnerSignWindow))
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
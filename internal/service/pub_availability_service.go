@@ -0,0 +1,189 @@
+// internal/service/pub_availability_service.go
+package service
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+// AddAvailabilityWindow 给 pub 新增一个上架时间窗, 并立即按新窗口校正一次状态;
+// 新增时间窗视为交由调度控制: 当前为下架(0)的 pub 会在窗口内自动上架
+func (s *pubServiceImpl) AddAvailabilityWindow(publicCode string, dto *types.AvailabilityWindowDTO) (*types.PubAvailabilityEntity, error) {
+	pub, err := s.repo.GetPubByPublicCode(publicCode)
+	if err != nil {
+		return nil, err
+	}
+	ent, err := buildAvailabilityWindow(publicCode, dto)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateAvailabilityWindow(ent); err != nil {
+		return nil, err
+	}
+	if pub.Status == 0 && !pub.ScheduleOff {
+		if err := s.repo.UpdatePubStatus(publicCode, 0, true); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.enforceAvailability(publicCode, time.Now()); err != nil {
+		log.Printf("[WARN] enforce availability for %s failed: %v\n", publicCode, err)
+	}
+	return ent, nil
+}
+
+func (s *pubServiceImpl) ListAvailabilityWindows(publicCode string) ([]types.PubAvailabilityEntity, error) {
+	return s.repo.ListAvailabilityWindows(publicCode)
+}
+
+func (s *pubServiceImpl) DeleteAvailabilityWindow(id uint64) error {
+	return s.repo.DeleteAvailabilityWindow(id)
+}
+
+// CheckAvailable 下单前校验: pub 配置了时间窗且当前不在任何窗口内时返回 error
+func (s *pubServiceImpl) CheckAvailable(publicCode string, at time.Time) error {
+	windows, err := s.repo.ListAvailabilityWindows(publicCode)
+	if err != nil {
+		return err
+	}
+	if !types.InAnyWindow(windows, at) {
+		return types.NewConflictError("PUB_NOT_AVAILABLE", fmt.Sprintf("publicCode=%s is not available at %s", publicCode, at.Format(time.RFC3339)), nil)
+	}
+	return nil
+}
+
+// EnforceAvailabilityWindows 由后台定时任务调用:
+// 对所有配置了时间窗的 pub, 按当前时间计算应有的上/下架状态,
+// 与库里不一致的写回 MySQL 并同步 ES, 返回本轮变更的数量
+func (s *pubServiceImpl) EnforceAvailabilityWindows() (int, error) {
+	all, err := s.repo.ListAllAvailabilityWindows()
+	if err != nil {
+		return 0, err
+	}
+	byCode := make(map[string][]types.PubAvailabilityEntity)
+	for _, w := range all {
+		byCode[w.PublicCode] = append(byCode[w.PublicCode], w)
+	}
+	codes := make([]string, 0, len(byCode))
+	for code := range byCode {
+		codes = append(codes, code)
+	}
+	pubs, err := s.repo.ListPubByPublicCodes(codes)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	changed := 0
+	docs := make(map[string]map[string]interface{})
+	for _, pub := range pubs {
+		want, ok := desiredStatus(&pub, byCode[pub.PublicCode], now)
+		if !ok {
+			continue
+		}
+		if err := s.repo.UpdatePubStatus(pub.PublicCode, want, want == 0); err != nil {
+			log.Printf("[WARN] update status for %s failed: %v\n", pub.PublicCode, err)
+			continue
+		}
+		changed++
+		docs[pub.PublicCode] = statusDoc(want)
+	}
+
+	if err := s.bulkUpdateES(docs); err != nil {
+		return changed, fmt.Errorf("ES sync after availability change: %w", err)
+	}
+	if changed > 0 {
+		log.Printf("[EnforceAvailabilityWindows] %d pubs changed status\n", changed)
+	}
+	return changed, nil
+}
+
+// enforceAvailability 只校正单个 pub
+func (s *pubServiceImpl) enforceAvailability(publicCode string, now time.Time) error {
+	pub, err := s.repo.GetPubByPublicCode(publicCode)
+	if err != nil {
+		return err
+	}
+	windows, err := s.repo.ListAvailabilityWindows(publicCode)
+	if err != nil {
+		return err
+	}
+	want, ok := desiredStatus(pub, windows, now)
+	if !ok {
+		return nil
+	}
+	if err := s.repo.UpdatePubStatus(publicCode, want, want == 0); err != nil {
+		return err
+	}
+	return s.bulkUpdateES(map[string]map[string]interface{}{publicCode: statusDoc(want)})
+}
+
+// desiredStatus 返回应有的状态, 以及是否需要变更;
+// 只在 1(上架)/0(下架) 之间切换, 其他状态(如 2)保持人工控制;
+// 人工下架(ScheduleOff=false 的 0)在窗口内也不自动上架
+func desiredStatus(pub *types.PubEntity, windows []types.PubAvailabilityEntity, now time.Time) (int64, bool) {
+	if len(windows) == 0 {
+		return pub.Status, false
+	}
+	in := types.InAnyWindow(windows, now)
+	switch {
+	case pub.Status == 1 && !in:
+		return 0, true
+	case pub.Status == 0 && pub.ScheduleOff && in:
+		return 1, true
+	}
+	return pub.Status, false
+}
+
+func statusDoc(status int64) map[string]interface{} {
+	return map[string]interface{}{
+		"status":     status,
+		"updated_at": time.Now().Format(time.RFC3339),
+	}
+}
+
+func buildAvailabilityWindow(publicCode string, dto *types.AvailabilityWindowDTO) (*types.PubAvailabilityEntity, error) {
+	if dto.StartAt != nil && dto.EndAt != nil && !dto.EndAt.After(*dto.StartAt) {
+		return nil, types.NewValidationError("endAt must be after startAt")
+	}
+	ent := &types.PubAvailabilityEntity{
+		PublicCode: publicCode,
+		StartAt:    dto.StartAt,
+		EndAt:      dto.EndAt,
+		Recurrence: dto.Recurrence,
+		DailyStart: dto.DailyStart,
+		DailyEnd:   dto.DailyEnd,
+		Remark:     dto.Remark,
+	}
+
+	switch dto.Recurrence {
+	case types.RecurrenceNone:
+		if dto.StartAt == nil && dto.EndAt == nil {
+			return nil, types.NewValidationError("startAt or endAt is required for a one-off window")
+		}
+	case types.RecurrenceDaily, types.RecurrenceWeekly:
+		if err := types.ValidateDailyRange(dto.DailyStart, dto.DailyEnd); err != nil {
+			return nil, err
+		}
+		if dto.Recurrence == types.RecurrenceWeekly {
+			if len(dto.Weekdays) == 0 {
+				return nil, types.NewValidationError("weekdays is required for a weekly window")
+			}
+			days := make([]string, 0, len(dto.Weekdays))
+			for _, d := range dto.Weekdays {
+				if d < 0 || d > 6 {
+					return nil, types.NewValidationError(fmt.Sprintf("invalid weekday %d, want 0~6", d))
+				}
+				days = append(days, strconv.Itoa(d))
+			}
+			ent.Weekdays = strings.Join(days, ",")
+		}
+	default:
+		return nil, types.NewValidationError(fmt.Sprintf("unknown recurrence %q", dto.Recurrence))
+	}
+	return ent, nil
+}
@@ -0,0 +1,119 @@
+// internal/service/pub_batch_service.go
+package service
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+	"github.com/google/uuid"
+)
+
+// PreviewBatchCategory 预览(dry-run): 只返回会被命中的 pub, 不做任何写入
+func (s *pubServiceImpl) PreviewBatchCategory(sel types.PubSelector) ([]types.PubDTO, error) {
+	if err := sel.Validate(); err != nil {
+		return nil, err
+	}
+	pubs, err := s.repo.FindPubBySelector(sel)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]types.PubDTO, len(pubs))
+	for i := range pubs {
+		_ = result[i].FromEntity(&pubs[i])
+	}
+	return result, nil
+}
+
+// BatchCategorize 给命中规则的 pub 追加分类并设置 tag:
+// 1) MySQL 事务内写 tag / categories_json + 撤销日志
+// 2) ES 批量局部更新
+// 返回批次ID(用于撤销)与命中数量; 没有命中时返回 404 NO_PUB_MATCHED, 不生成批次
+func (s *pubServiceImpl) BatchCategorize(sel types.PubSelector, category, tag string) (string, int, error) {
+	if err := sel.Validate(); err != nil {
+		return "", 0, err
+	}
+	if category == "" || tag == "" {
+		return "", 0, types.NewValidationError("category & tag are required")
+	}
+
+	// 1) 圈选
+	pubs, err := s.repo.FindPubBySelector(sel)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(pubs) == 0 {
+		return "", 0, types.NewNotFoundError("NO_PUB_MATCHED", "no pub matches the selector", nil)
+	}
+
+	// 2) 计算新值并记录旧值
+	batchId := uuid.NewString()
+	logs := make([]types.PubCategoryLogEntity, 0, len(pubs))
+	for i := range pubs {
+		pub := &pubs[i]
+		oldCats, _ := types.MarshalStrings(pub.Categories)
+		oldTag := pub.Tag
+
+		pub.Tag = tag
+		// 如果已经包含就不重复添加
+		if !containsString(pub.Categories, category) {
+			pub.Categories = append(pub.Categories, category)
+		}
+		newCats, _ := types.MarshalStrings(pub.Categories)
+
+		logs = append(logs, types.PubCategoryLogEntity{
+			BatchId:       batchId,
+			PublicCode:    pub.PublicCode,
+			OldTag:        oldTag,
+			OldCategories: oldCats,
+			NewTag:        pub.Tag,
+			NewCategories: newCats,
+		})
+	}
+
+	// 3) 写 MySQL
+	if err := s.repo.SavePubCategories(pubs, logs); err != nil {
+		return "", 0, fmt.Errorf("save categories error: %w", err)
+	}
+
+	// 4) 同步 ES
+	if err := s.bulkUpdateES(categoryDocs(pubs)); err != nil {
+		// DB 已是准确数据, ES 可通过再次保存或搜索回填修复
+		log.Printf("[WARN] BatchCategorize bulk ES failed, batch=%s: %v\n", batchId, err)
+	}
+
+	log.Printf("已为 %d 个产品追加分类 %q, batch=%s", len(pubs), category, batchId)
+	return batchId, len(pubs), nil
+}
+
+// UndoBatchCategory 按批次还原 tag / categories, 返回还原的数量与冲突的 publicCode;
+// 批次之后又被改过 tag / categories 的 pub 不还原, 留给人工处理, 可改回后再次撤销
+func (s *pubServiceImpl) UndoBatchCategory(batchId string) (int, []string, error) {
+	if batchId == "" {
+		return 0, nil, types.NewValidationError("batchId is required")
+	}
+	pubs, conflicts, err := s.repo.RevertCategoryBatch(batchId)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := s.bulkUpdateES(categoryDocs(pubs)); err != nil {
+		log.Printf("[WARN] UndoBatchCategory bulk ES failed, batch=%s: %v\n", batchId, err)
+	}
+	log.Printf("已撤销批次 %s, 还原 %d 个产品, 冲突 %d 个", batchId, len(pubs), len(conflicts))
+	return len(pubs), conflicts, nil
+}
+
+// categoryDocs 组装只包含 tag / categories 的 ES 局部文档
+func categoryDocs(pubs []types.PubEntity) map[string]map[string]interface{} {
+	docs := make(map[string]map[string]interface{}, len(pubs))
+	now := time.Now().Format(time.RFC3339)
+	for _, p := range pubs {
+		docs[p.PublicCode] = map[string]interface{}{
+			"tag":        p.Tag,
+			"categories": p.Categories,
+			"updated_at": now,
+		}
+	}
+	return docs
+}
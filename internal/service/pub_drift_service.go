@@ -0,0 +1,160 @@
+// internal/service/pub_drift_service.go
+package service
+
+import (
+	"log"
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+// captureSnapshots 用当前 GncEntity 覆盖组合的快照;
+// base 查不到时保留客户端传入的内容, SnapshotAt 置空, 漂移报告里会体现为 missing_base
+func (s *pubServiceImpl) captureSnapshots(comps []types.PubComposeEntity) {
+	if len(comps) == 0 {
+		return
+	}
+	gncMap, err := s.loadGncMap(comps)
+	if err != nil {
+		log.Printf("[captureSnapshots] load gnc failed: %v", err)
+		return
+	}
+	applySnapshots(comps, gncMap)
+}
+
+// applySnapshots 用已查好的 gncMap 写入快照, 供批量场景复用
+func applySnapshots(comps []types.PubComposeEntity, gncMap map[string]*types.GncEntity) {
+	now := time.Now()
+	for i := range comps {
+		comps[i].SnapshotAt = nil
+		g, ok := gncMap[comps[i].BaseCode]
+		if !ok {
+			log.Printf("[captureSnapshots] baseCode=%s not found, keep client snapshot", comps[i].BaseCode)
+			continue
+		}
+		comps[i].Snapshot = types.NewGncSnapshot(g).JSON()
+		comps[i].SnapshotAt = &now
+	}
+}
+
+// loadGncMap 批量查询组合引用的 base, 返回 baseCode => GncEntity
+func (s *pubServiceImpl) loadGncMap(comps []types.PubComposeEntity) (map[string]*types.GncEntity, error) {
+	codes := make([]string, 0, len(comps))
+	for _, c := range comps {
+		if !containsString(codes, c.BaseCode) {
+			codes = append(codes, c.BaseCode)
+		}
+	}
+	list, err := s.gncRepo.ListGncByBaseCodes(codes)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]*types.GncEntity, len(list))
+	for i := range list {
+		m[list[i].BaseCode] = &list[i]
+	}
+	return m, nil
+}
+
+// driftMaxPageSize 不指定 publicCode 时每页最多检查的 pub 数
+const driftMaxPageSize = 500
+
+// DriftReport 对比组合快照与当前 base, 列出发生漂移的 pub;
+// publicCode 为空时按 page/size 分页检查, total 为参与分页的 pub 总数
+func (s *pubServiceImpl) DriftReport(publicCode string, page, size int64) ([]types.PubDrift, int64, error) {
+	// 1) 取待检查的 pub (含 Compositions)
+	var pubs []types.PubEntity
+	var total int64
+	if publicCode != "" {
+		ent, err := s.repo.GetPubByPublicCode(publicCode)
+		if err != nil {
+			return nil, 0, err
+		}
+		pubs, total = []types.PubEntity{*ent}, 1
+	} else {
+		if page <= 0 {
+			page = 1
+		}
+		if size <= 0 || size > driftMaxPageSize {
+			size = driftMaxPageSize
+		}
+		list, n, err := s.repo.ListPub(page, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		pubs, total = list, n
+	}
+
+	// 2) 一次性查出所有引用到的 base
+	var allComps []types.PubComposeEntity
+	for _, p := range pubs {
+		allComps = append(allComps, p.Compositions...)
+	}
+	gncMap, err := s.loadGncMap(allComps)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 3) 逐个组合比对
+	report := make([]types.PubDrift, 0)
+	for _, p := range pubs {
+		var drifts []types.CompositionDrift
+		for _, c := range p.Compositions {
+			if d, ok := compositionDrift(c, gncMap[c.BaseCode]); ok {
+				drifts = append(drifts, d)
+			}
+		}
+		if len(drifts) > 0 {
+			report = append(report, types.PubDrift{
+				PublicCode:   p.PublicCode,
+				ProductName:  p.ProductName,
+				Compositions: drifts,
+			})
+		}
+	}
+	return report, total, nil
+}
+
+func compositionDrift(c types.PubComposeEntity, g *types.GncEntity) (types.CompositionDrift, bool) {
+	d := types.CompositionDrift{BaseCode: c.BaseCode}
+	if g == nil {
+		d.Reason = types.DriftMissingBase
+		return d, true
+	}
+	snap, ok := types.ParseGncSnapshot(c.Snapshot)
+	if !ok {
+		d.Reason = types.DriftNoSnapshot
+		return d, true
+	}
+	d.Diffs = snap.Diff(types.NewGncSnapshot(g))
+	if len(d.Diffs) == 0 {
+		return d, false
+	}
+	d.Reason = types.DriftChanged
+	return d, true
+}
+
+// RefreshSnapshots 运营确认漂移后, 按当前 base 重新捕获快照, 返回更新的组合数
+func (s *pubServiceImpl) RefreshSnapshots(publicCode string) (int, error) {
+	ent, err := s.repo.GetPubByPublicCode(publicCode)
+	if err != nil {
+		return 0, err
+	}
+	gncMap, err := s.loadGncMap(ent.Compositions)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	count := 0
+	for _, c := range ent.Compositions {
+		g, ok := gncMap[c.BaseCode]
+		if !ok {
+			continue
+		}
+		if err := s.repo.UpdateComposeSnapshot(c.ID, types.NewGncSnapshot(g).JSON(), now); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,286 @@
+// internal/service/pub_import_service.go
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"10000hk.com/vip_gift/internal/types"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+// importBatchSize 每个事务提交的行数
+const importBatchSize = 100
+
+// ImportPubs 批量导入: 先校验全部行, 有错误则整体不提交;
+// 每行是完整的产品定义, 已存在的 publicCode 整行覆盖(组合整体替换), 内容无变化的行跳过
+func (s *pubServiceImpl) ImportPubs(dtos []types.PubDTO, parseErrs []types.ImportError, dryRun bool) (*types.ImportResult, error) {
+	result := &types.ImportResult{
+		DryRun:  dryRun,
+		Total:   len(dtos),
+		Errors:  append([]types.ImportError{}, parseErrs...),
+		Changes: make([]types.ImportChange, 0),
+	}
+
+	// 1) 一次性查出已存在的 pub 和引用到的 base
+	codes := make([]string, 0, len(dtos))
+	var allComps []types.PubComposeEntity
+	for _, d := range dtos {
+		codes = append(codes, d.PublicCode)
+		for _, c := range d.Compositions {
+			allComps = append(allComps, types.PubComposeEntity{BaseCode: c.BaseCode})
+		}
+	}
+	existing, err := s.repo.FindPubsWithCompositions(codes)
+	if err != nil {
+		return nil, err
+	}
+	oldMap := make(map[string]*types.PubEntity, len(existing))
+	for i := range existing {
+		oldMap[existing[i].PublicCode] = &existing[i]
+	}
+	gncMap, err := s.loadGncMap(allComps)
+	if err != nil {
+		return nil, err
+	}
+	trashedCodes, err := s.repo.ListTrashedPublicCodes(codes)
+	if err != nil {
+		return nil, err
+	}
+	trashed := make(map[string]bool, len(trashedCodes))
+	for _, c := range trashedCodes {
+		trashed[c] = true
+	}
+
+	// 2) 逐行校验并计算 diff
+	var pending []*types.PubEntity
+	seen := make(map[string]int, len(dtos))
+	for i := range dtos {
+		row := i + 1
+		dto := &dtos[i]
+		fail := func(msg string) {
+			result.Errors = append(result.Errors, types.ImportError{Row: row, PublicCode: dto.PublicCode, Message: msg})
+		}
+
+		if msgs := validateImportRow(dto, gncMap); len(msgs) > 0 {
+			for _, m := range msgs {
+				fail(m)
+			}
+			continue
+		}
+		if first, ok := seen[dto.PublicCode]; ok {
+			fail(fmt.Sprintf("duplicate publicCode, first seen at row %d", first))
+			continue
+		}
+		seen[dto.PublicCode] = row
+		if trashed[dto.PublicCode] {
+			fail(types.ErrInTrash.Error())
+			continue
+		}
+
+		ent, err := dto.ToEntity()
+		if err != nil {
+			fail(err.Error())
+			continue
+		}
+		old := oldMap[dto.PublicCode]
+		if old != nil {
+			ent.ID = old.ID
+			ent.StockUsed = old.StockUsed
+			ent.NeedsReview = old.NeedsReview
+			ent.ReviewReason = old.ReviewReason
+			ent.ScheduleOff = old.ScheduleOff && ent.Status == old.Status
+		} else {
+			ent.StockUsed, ent.NeedsReview, ent.ReviewReason = 0, 0, ""
+		}
+		if _, _, missing := composeCost(ent.Compositions, gncMap); len(missing) > 0 {
+			fail(unresolvedBases(missing))
+			continue
+		}
+		if reason := s.marginIssue(ent, gncMap); reason != "" && !dto.MarginOverride {
+			fail(fmt.Sprintf("%s: %s", types.ErrMarginViolation, reason))
+			continue
+		}
+
+		change := types.ImportChange{Row: row, PublicCode: dto.PublicCode, Action: types.ImportCreate}
+		if old != nil {
+			change.Diffs = diffPub(old, ent)
+			change.Action = types.ImportUpdate
+			if len(change.Diffs) == 0 {
+				change.Action = types.ImportUnchanged
+			}
+		}
+		switch change.Action {
+		case types.ImportCreate:
+			result.Creates++
+		case types.ImportUpdate:
+			result.Updates++
+		default:
+			result.Unchanged++
+			continue
+		}
+		result.Changes = append(result.Changes, change)
+		applySnapshots(ent.Compositions, gncMap)
+		pending = append(pending, ent)
+	}
+
+	if dryRun || len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	// 3) 分批提交, 每批提交后批量写 ES
+	for start := 0; start < len(pending); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		batch := pending[start:end]
+		if err := s.repo.SavePubBatch(batch); err != nil {
+			return result, fmt.Errorf("batch %d-%d: %w", start+1, end, err)
+		}
+		result.Committed += len(batch)
+		for _, ent := range batch {
+			if old := oldMap[ent.PublicCode]; old != nil {
+				s.recordPriceChange(*old, ent, types.PriceSourceImport)
+			} else {
+				s.recordPriceChange(types.PubEntity{}, ent, types.PriceSourceCreate)
+			}
+		}
+		if err := s.bulkIndexES(batch); err != nil {
+			log.Printf("[ImportPubs] ES bulk index batch %d-%d failed: %v\n", start+1, end, err)
+		}
+	}
+	return result, nil
+}
+
+// validateImportRow 单行的字段校验, 返回全部错误
+func validateImportRow(dto *types.PubDTO, gncMap map[string]*types.GncEntity) []string {
+	var msgs []string
+	if dto.PublicCode == "" {
+		msgs = append(msgs, "publicCode is required")
+	}
+	if dto.ProductName == "" {
+		msgs = append(msgs, "productName is required")
+	}
+	if dto.SalePrice <= 0 {
+		msgs = append(msgs, fmt.Sprintf("salePrice must be positive, got %v", dto.SalePrice))
+	}
+	if dto.ParValue < 0 {
+		msgs = append(msgs, fmt.Sprintf("parValue must not be negative, got %v", dto.ParValue))
+	}
+	if dto.CommissionMF < 0 {
+		msgs = append(msgs, fmt.Sprintf("commissionMF must not be negative, got %v", dto.CommissionMF))
+	}
+	if dto.Status < 0 || dto.Status > 2 {
+		msgs = append(msgs, fmt.Sprintf("status must be 0, 1 or 2, got %d", dto.Status))
+	}
+	for _, c := range dto.Compositions {
+		if _, ok := gncMap[c.BaseCode]; !ok {
+			msgs = append(msgs, fmt.Sprintf("unknown baseCode %q", c.BaseCode))
+		}
+	}
+	return msgs
+}
+
+// diffPub 导入会覆盖的字段中发生变化的部分
+func diffPub(old, cur *types.PubEntity) []types.FieldDiff {
+	var diffs []types.FieldDiff
+	add := func(field string, o, n interface{}) {
+		diffs = append(diffs, types.FieldDiff{Field: field, Old: o, New: n})
+	}
+	str := func(field, o, n string) {
+		if o != n {
+			add(field, o, n)
+		}
+	}
+	num := func(field string, o, n float64) {
+		if o != n {
+			add(field, o, n)
+		}
+	}
+	str("productName", old.ProductName, cur.ProductName)
+	num("salePrice", old.SalePrice, cur.SalePrice)
+	num("parValue", old.ParValue, cur.ParValue)
+	num("commissionMF", old.CommissionMF, cur.CommissionMF)
+	str("commissionRuleMF", old.CommissionRuleMF, cur.CommissionRuleMF)
+	num("status", float64(old.Status), float64(cur.Status))
+	str("tag", old.Tag, cur.Tag)
+	str("categories", strings.Join(old.Categories, "|"), strings.Join(cur.Categories, "|"))
+	str("cover", old.Cover, cur.Cover)
+	str("desc", old.Desc, cur.Desc)
+	str("pics", strings.Join(old.Pics, "|"), strings.Join(cur.Pics, "|"))
+	str("originData", old.OriginData, cur.OriginData)
+	str("compositions", joinCompositions(old.Compositions), joinCompositions(cur.Compositions))
+	num("stockTotal", float64(old.StockTotal), float64(cur.StockTotal))
+	num("dailyStock", float64(old.DailyStock), float64(cur.DailyStock))
+	num("limitPerPhone", float64(old.LimitPerPhone), float64(cur.LimitPerPhone))
+	num("limitPerUser", float64(old.LimitPerUser), float64(cur.LimitPerUser))
+	return diffs
+}
+
+func joinCompositions(comps []types.PubComposeEntity) string {
+	parts := make([]string, len(comps))
+	for i, c := range comps {
+		parts[i] = c.BaseCode + ":" + c.Strategy
+	}
+	return strings.Join(parts, "|")
+}
+
+// ExportPubs 导出全部 pub (含组合), 格式与导入一致
+func (s *pubServiceImpl) ExportPubs() ([]types.PubDTO, error) {
+	ents, _, err := s.repo.ListPub(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]types.PubDTO, len(ents))
+	for i := range ents {
+		_ = result[i].FromEntity(&ents[i])
+	}
+	return result, nil
+}
+
+// bulkIndexES 批量写入完整文档
+func (s *pubServiceImpl) bulkIndexES(ents []*types.PubEntity) error {
+	if len(ents) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, ent := range ents {
+		meta := map[string]interface{}{
+			"index": map[string]interface{}{"_index": "vip_pub", "_id": ent.PublicCode},
+		}
+		metaBytes, _ := json.Marshal(meta)
+		docBytes, _ := json.Marshal(esDoc(ent))
+		buf.Write(metaBytes)
+		buf.WriteByte('\n')
+		buf.Write(docBytes)
+		buf.WriteByte('\n')
+	}
+
+	reqES := esapi.BulkRequest{
+		Body:    &buf,
+		Refresh: "true",
+	}
+	resp, err := reqES.Do(context.Background(), s.esTransport())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return fmt.Errorf("ES bulk error: %s", resp.Status())
+	}
+	var br struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&br); err != nil {
+		return err
+	}
+	if br.Errors {
+		return fmt.Errorf("ES bulk index reported item errors")
+	}
+	return nil
+}
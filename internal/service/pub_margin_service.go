@@ -0,0 +1,144 @@
+// internal/service/pub_margin_service.go
+package service
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+// marginIssue 计算 ent 的成本与毛利(写回 ent), 亏损或组合里有本地查不到的 base 时返回原因
+// 成本按策略计算(all 为售价之和, 其余取最贵的 base), 见 composeCost
+func (s *pubServiceImpl) marginIssue(ent *types.PubEntity, gncMap map[string]*types.GncEntity) string {
+	cost, _, missing := composeCost(ent.Compositions, gncMap)
+	ent.CostPrice = cost
+	ent.Margin = ent.SalePrice - cost
+	switch {
+	case len(missing) > 0:
+		return unresolvedBases(missing)
+	case ent.Margin < 0:
+		return fmt.Sprintf("salePrice %.2f below cost %.2f", ent.SalePrice, cost)
+	case ent.CommissionMF > ent.Margin:
+		return fmt.Sprintf("commissionMF %.2f exceeds margin %.2f", ent.CommissionMF, ent.Margin)
+	}
+	return ""
+}
+
+func unresolvedBases(missing []string) string {
+	return "composed base not found: " + strings.Join(missing, ", ")
+}
+
+// checkMargin 创建/修改 pub 前的毛利校验; override=true 时亏损只记日志, 组合里有查不到的 base 时无法计算成本, 不可跳过.
+// 下架(status=0)或组合、售价、佣金都没变(old 为修改前的记录)时不拦截, 引用的 base 被删除后仍能下架或修改其它字段
+func (s *pubServiceImpl) checkMargin(ent, old *types.PubEntity, override bool) error {
+	gncMap, err := s.loadGncMap(ent.Compositions)
+	if err != nil {
+		return err
+	}
+	reason := s.marginIssue(ent, gncMap)
+	if reason == "" {
+		return nil
+	}
+	if ent.Status == 0 || (old != nil && !pricingChanged(old, ent)) {
+		log.Printf("[checkMargin] %s saved without margin check: %s\n", ent.PublicCode, reason)
+		return nil
+	}
+	if _, _, missing := composeCost(ent.Compositions, gncMap); len(missing) > 0 {
+		return types.NewValidationError(unresolvedBases(missing))
+	}
+	if override {
+		log.Printf("[checkMargin] %s saved with marginOverride: %s\n", ent.PublicCode, reason)
+		return nil
+	}
+	return fmt.Errorf("%w: %s (set marginOverride to save anyway)", types.ErrMarginViolation, reason)
+}
+
+// pricingChanged 组合(base 与策略)、售价或佣金是否有变化
+func pricingChanged(old, ent *types.PubEntity) bool {
+	if old.SalePrice != ent.SalePrice || old.CommissionMF != ent.CommissionMF ||
+		len(old.Compositions) != len(ent.Compositions) {
+		return true
+	}
+	for i := range ent.Compositions {
+		if old.Compositions[i].BaseCode != ent.Compositions[i].BaseCode ||
+			old.Compositions[i].Strategy != ent.Compositions[i].Strategy {
+			return true
+		}
+	}
+	return false
+}
+
+// MarginReport 按当前 base 售价实时计算, 列出亏损或佣金超过毛利的 pub
+func (s *pubServiceImpl) MarginReport() ([]types.MarginIssue, error) {
+	issues, _, err := s.scanMargins()
+	return issues, err
+}
+
+// RefreshMargins 定时任务: 回写所有 pub 的成本/毛利, 新出现的亏损 pub 标记复核
+func (s *pubServiceImpl) RefreshMargins() ([]types.MarginIssue, error) {
+	issues, pubs, err := s.scanMargins()
+	if err != nil {
+		return nil, err
+	}
+	flagged := make(map[string]string, len(issues))
+	for _, is := range issues {
+		flagged[is.PublicCode] = is.Reason
+	}
+	docs := make(map[string]map[string]interface{})
+	for _, p := range pubs {
+		cols := map[string]interface{}{"cost_price": p.CostPrice, "margin": p.Margin}
+		if reason, ok := flagged[p.PublicCode]; ok && p.NeedsReview == 0 {
+			cols["needs_review"] = 1
+			cols["review_reason"] = reason
+			p.NeedsReview = 1
+		}
+		if err := s.repo.UpdatePubColumns(p.PublicCode, cols); err != nil {
+			log.Printf("[RefreshMargins] update %s failed: %v\n", p.PublicCode, err)
+			continue
+		}
+		docs[p.PublicCode] = map[string]interface{}{"margin": p.Margin, "needsReview": p.NeedsReview}
+	}
+	if err := s.bulkUpdateES(docs); err != nil {
+		log.Printf("[RefreshMargins] ES update failed: %v\n", err)
+	}
+	for _, is := range issues {
+		log.Printf("[RefreshMargins] unprofitable %s: %s\n", is.PublicCode, is.Reason)
+	}
+	return issues, nil
+}
+
+func (s *pubServiceImpl) scanMargins() ([]types.MarginIssue, []types.PubEntity, error) {
+	pubs, _, err := s.repo.ListPub(0, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var allComps []types.PubComposeEntity
+	for _, p := range pubs {
+		allComps = append(allComps, p.Compositions...)
+	}
+	gncMap, err := s.loadGncMap(allComps)
+	if err != nil {
+		return nil, nil, err
+	}
+	issues := make([]types.MarginIssue, 0)
+	for i := range pubs {
+		p := &pubs[i]
+		reason := s.marginIssue(p, gncMap)
+		if reason == "" {
+			continue
+		}
+		issues = append(issues, types.MarginIssue{
+			PublicCode:   p.PublicCode,
+			ProductName:  p.ProductName,
+			Status:       p.Status,
+			SalePrice:    p.SalePrice,
+			CostPrice:    p.CostPrice,
+			Margin:       p.Margin,
+			CommissionMF: p.CommissionMF,
+			Reason:       reason,
+		})
+	}
+	return issues, pubs, nil
+}
@@ -0,0 +1,122 @@
+// internal/service/pub_price_service.go
+package service
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+// 每轮最多处理的到期调价计划数
+const duePriceBatchSize = 200
+
+// SchedulePriceChange 新建一条定时调价计划, 到达 effectiveAt 后由后台任务生效
+func (s *pubServiceImpl) SchedulePriceChange(publicCode string, dto *types.PriceScheduleDTO) (*types.PubPriceScheduleEntity, error) {
+	if dto.SalePrice == nil && dto.ParValue == nil && dto.CommissionMF == nil {
+		return nil, types.NewValidationError("at least one of salePrice/parValue/commissionMF is required")
+	}
+	if dto.EffectiveAt.IsZero() {
+		return nil, types.NewValidationError("effectiveAt is required")
+	}
+	if !dto.EffectiveAt.After(time.Now()) {
+		return nil, types.NewValidationError("effectiveAt must be in the future")
+	}
+	// 确认产品存在
+	if _, err := s.repo.GetPubByPublicCode(publicCode); err != nil {
+		return nil, err
+	}
+
+	ent := &types.PubPriceScheduleEntity{
+		PublicCode:   publicCode,
+		SalePrice:    dto.SalePrice,
+		ParValue:     dto.ParValue,
+		CommissionMF: dto.CommissionMF,
+		EffectiveAt:  dto.EffectiveAt,
+		Status:       types.PriceSchedulePending,
+		Remark:       dto.Remark,
+	}
+	if err := s.repo.CreatePriceSchedule(ent); err != nil {
+		return nil, err
+	}
+	return ent, nil
+}
+
+func (s *pubServiceImpl) ListPriceSchedules(publicCode string) ([]types.PubPriceScheduleEntity, error) {
+	return s.repo.ListPriceSchedules(publicCode)
+}
+
+func (s *pubServiceImpl) CancelPriceSchedule(id uint64) error {
+	return s.repo.CancelPriceSchedule(id)
+}
+
+func (s *pubServiceImpl) ListPriceHistory(publicCode string, page, size int64) ([]types.PubPriceHistoryEntity, int64, error) {
+	return s.repo.ListPriceHistory(publicCode, page, size)
+}
+
+// ApplyDuePriceChanges 由后台定时任务调用: 让所有到期的调价计划生效, 并同步 ES
+// 返回本轮成功生效的数量
+func (s *pubServiceImpl) ApplyDuePriceChanges() (int, error) {
+	due, err := s.repo.ListDuePriceSchedules(time.Now(), duePriceBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	docs := make(map[string]map[string]interface{})
+	for i := range due {
+		ent, err := s.repo.ApplyPriceSchedule(&due[i])
+		if err != nil {
+			log.Printf("[WARN] apply price schedule %d failed: %v\n", due[i].ID, err)
+			continue
+		}
+		if ent == nil {
+			// 已被其他实例处理, 或产品不存在
+			continue
+		}
+		applied++
+		docs[ent.PublicCode] = priceDoc(ent)
+	}
+
+	if err := s.bulkUpdateES(docs); err != nil {
+		return applied, fmt.Errorf("ES sync after price change: %w", err)
+	}
+	if applied > 0 {
+		log.Printf("[ApplyDuePriceChanges] %d price schedules applied\n", applied)
+	}
+	return applied, nil
+}
+
+// recordPriceChange 价格有变化时写一条历史, 写失败只记日志, 不影响主流程
+func (s *pubServiceImpl) recordPriceChange(old types.PubEntity, ent *types.PubEntity, source string) {
+	if source != types.PriceSourceCreate &&
+		old.SalePrice == ent.SalePrice &&
+		old.ParValue == ent.ParValue &&
+		old.CommissionMF == ent.CommissionMF {
+		return
+	}
+	hist := &types.PubPriceHistoryEntity{
+		PublicCode:      ent.PublicCode,
+		OldSalePrice:    old.SalePrice,
+		OldParValue:     old.ParValue,
+		OldCommissionMF: old.CommissionMF,
+		SalePrice:       ent.SalePrice,
+		ParValue:        ent.ParValue,
+		CommissionMF:    ent.CommissionMF,
+		Source:          source,
+	}
+	if err := s.repo.CreatePriceHistory(hist); err != nil {
+		log.Printf("[WARN] record price history for %s failed: %v\n", ent.PublicCode, err)
+	}
+}
+
+// priceDoc 组装只包含价格字段的 ES 局部文档
+func priceDoc(ent *types.PubEntity) map[string]interface{} {
+	return map[string]interface{}{
+		"salePrice":    ent.SalePrice,
+		"parValue":     ent.ParValue,
+		"commissionMF": ent.CommissionMF,
+		"updated_at":   time.Now().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,143 @@
+// internal/service/pub_propagation_service.go
+package service
+
+import (
+	"log"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+// BaseChangeListener GncService 在 base 增删改/同步后回调, 由 PubService 实现
+type BaseChangeListener interface {
+	OnBaseChanged(change types.BaseChange, policy string) (int, error)
+}
+
+// OnBaseChanged 找出引用该 base 的 pub, 按 policy 处理并同步 ES, 返回受影响的 pub 数
+func (s *pubServiceImpl) OnBaseChanged(change types.BaseChange, policy string) (int, error) {
+	if !change.Relevant() {
+		return 0, nil
+	}
+	// 1) 通过 pub_compose_entities 反查依赖的 pub
+	pubs, err := s.repo.ListPubByBaseCode(change.BaseCode)
+	if err != nil || len(pubs) == 0 {
+		return 0, err
+	}
+
+	// 2) 其余 base 从库里取, 本次变更的 base 以 change.New 为准
+	var allComps []types.PubComposeEntity
+	for _, p := range pubs {
+		allComps = append(allComps, p.Compositions...)
+	}
+	gncMap, err := s.loadGncMap(allComps)
+	if err != nil {
+		return 0, err
+	}
+	if change.New == nil {
+		delete(gncMap, change.BaseCode)
+	} else {
+		gncMap[change.BaseCode] = change.New
+	}
+
+	// 3) 逐个 pub 重算成本/毛利, 按策略决定下架或标记复核
+	priceChanged := change.Old != nil && change.New != nil &&
+		(change.Old.SalePrice != change.New.SalePrice || change.Old.ParValue != change.New.ParValue)
+	docs := make(map[string]map[string]interface{})
+	for _, p := range pubs {
+		cost, sellable, _ := composeCost(p.Compositions, gncMap)
+		margin := p.SalePrice - cost
+		cols := map[string]interface{}{"cost_price": cost, "margin": margin}
+
+		flag := false
+		switch policy {
+		case types.PropagationUnshelve:
+			if !sellable && p.Status == 1 {
+				cols["status"] = 0
+				p.Status = 0
+			}
+			flag = !sellable || priceChanged
+		case types.PropagationMargin:
+			flag = !sellable || margin < 0
+		default:
+			flag = change.AffectsDependents()
+		}
+		if flag {
+			cols["needs_review"] = 1
+			cols["review_reason"] = change.Reason()
+			p.NeedsReview = 1
+		}
+
+		if err := s.repo.UpdatePubColumns(p.PublicCode, cols); err != nil {
+			log.Printf("[OnBaseChanged] update %s failed: %v\n", p.PublicCode, err)
+			continue
+		}
+		docs[p.PublicCode] = map[string]interface{}{
+			"status":      p.Status,
+			"margin":      margin,
+			"needsReview": p.NeedsReview,
+		}
+	}
+
+	// 4) 同步 ES
+	if err := s.bulkUpdateES(docs); err != nil {
+		return len(docs), err
+	}
+	log.Printf("[OnBaseChanged] %s policy=%s affected %d pubs\n", change.Reason(), policy, len(docs))
+	return len(docs), nil
+}
+
+// composeCost 本地查不到的 base 放进 missing, 成本只算查得到的 base(不论是否在架):
+// all 每个组合都下发, 成本为售价之和; 其余策略只交付一个 base, 取最贵的作为成本上限.
+// 可售: all 需所有 base 在架, 其余策略任一 base 在架即可
+func composeCost(comps []types.PubComposeEntity, gncMap map[string]*types.GncEntity) (cost float64, sellable bool, missing []string) {
+	if len(comps) == 0 {
+		return 0, false, nil
+	}
+	strategy, _ := types.ParseStrategy(comps[0].Strategy)
+	available := 0
+	for _, c := range comps {
+		g, ok := gncMap[c.BaseCode]
+		if !ok {
+			missing = append(missing, c.BaseCode)
+			continue
+		}
+		if strategy == types.StrategyAll {
+			cost += g.SalePrice
+		} else {
+			cost = max(cost, g.SalePrice)
+		}
+		if g.IsShelve != 0 {
+			available++
+		}
+	}
+	if strategy == types.StrategyAll {
+		return cost, available == len(comps), missing
+	}
+	return cost, available > 0, missing
+}
+
+// ListNeedsReview 待复核的 pub 列表
+func (s *pubServiceImpl) ListNeedsReview(page, size int64) ([]types.PubDTO, int64, error) {
+	ents, total, err := s.repo.ListPubNeedsReview(page, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	result := make([]types.PubDTO, len(ents))
+	for i := range ents {
+		_ = result[i].FromEntity(&ents[i])
+	}
+	return result, total, nil
+}
+
+// ClearReview 运营处理完后清除复核标记
+func (s *pubServiceImpl) ClearReview(publicCode string) error {
+	if _, err := s.repo.GetPubByPublicCode(publicCode); err != nil {
+		return err
+	}
+	cols := map[string]interface{}{"needs_review": 0, "review_reason": ""}
+	if err := s.repo.UpdatePubColumns(publicCode, cols); err != nil {
+		return err
+	}
+	return s.bulkUpdateES(map[string]map[string]interface{}{
+		publicCode: {"needsReview": 0},
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"sync"
 	"time"
 
@@ -25,6 +26,7 @@ type PubService interface {
 	Create(dto *types.PubDTO) (*types.PubDTO, error)
 	GetByPublicCode(publicCode string) (*types.PubDTO, error)
 	UpdateByPublicCode(publicCode string, dto *types.PubDTO) (*types.PubDTO, error)
+	PatchByPublicCode(publicCode string, patch *types.Patch) (*types.PubDTO, error)
 	DeleteByPublicCode(publicCode string) error
 	List(page, size int64) ([]types.PubDTO, int64, error)
 
@@ -32,19 +34,81 @@ type PubService interface {
 	SearchByKeyword(keyword string, page, size int64) ([]GroupedItem, int64, error)
 	GetAllCategories() ([]string, error)
 	BatchAddCategoryForPrefix(string, string, string) error
+	PreviewBatchCategory(sel types.PubSelector) ([]types.PubDTO, error)
+	BatchCategorize(sel types.PubSelector, category, tag string) (string, int, error)
+	UndoBatchCategory(batchId string) (int, []string, error)
+
+	// ----- 价格历史 & 定时调价 -----
+	SchedulePriceChange(publicCode string, dto *types.PriceScheduleDTO) (*types.PubPriceScheduleEntity, error)
+	ListPriceSchedules(publicCode string) ([]types.PubPriceScheduleEntity, error)
+	CancelPriceSchedule(id uint64) error
+	ListPriceHistory(publicCode string, page, size int64) ([]types.PubPriceHistoryEntity, int64, error)
+	ApplyDuePriceChanges() (int, error)
+
+	// ----- 上架时间窗 -----
+	AddAvailabilityWindow(publicCode string, dto *types.AvailabilityWindowDTO) (*types.PubAvailabilityEntity, error)
+	ListAvailabilityWindows(publicCode string) ([]types.PubAvailabilityEntity, error)
+	DeleteAvailabilityWindow(id uint64) error
+	CheckAvailable(publicCode string, at time.Time) error
+	EnforceAvailabilityWindows() (int, error)
+
+	// ----- 库存 & 限购 -----
+	StockReserver
 	GetBaseCodesByPublicCode(publicCode string) ([]string, error)
 	GetGncOriginDataByPublicCode(publicCode string) (string, error)
+	PlanFulfillment(ctx context.Context, publicCode string) (*types.FulfillmentPlan, error)
+	LocalCatalog(source string, baseCodes []string) ([]types.GncEntity, error)
+
+	// ----- 组合快照 & 漂移 -----
+	DriftReport(publicCode string, page, size int64) ([]types.PubDrift, int64, error)
+	RefreshSnapshots(publicCode string) (int, error)
+
+	// ----- 批量导入导出 -----
+	ImportPubs(dtos []types.PubDTO, parseErrs []types.ImportError, dryRun bool) (*types.ImportResult, error)
+	ExportPubs() ([]types.PubDTO, error)
+
+	// ----- 毛利 -----
+	MarginReport() ([]types.MarginIssue, error)
+	RefreshMargins() ([]types.MarginIssue, error)
+
+	// ----- base 变更联动 -----
+	BaseChangeListener
+	ListNeedsReview(page, size int64) ([]types.PubDTO, int64, error)
+	ClearReview(publicCode string) error
+
+	// ----- 回收站 -----
+	ListTrash(page, size int64) ([]types.PubDTO, int64, error)
+	RestoreByPublicCode(publicCode string) (*types.PubDTO, error)
+	PurgeByPublicCode(publicCode string) error
 }
 
 type pubServiceImpl struct {
-	repo    repository.PubRepo
-	es      *elasticsearch.Client
-	gncRepo repository.GncRepo
+	repo           repository.PubRepo
+	es             *elasticsearch.Client
+	gncRepo        repository.GncRepo
+	purgeGuardDays int // 永久删除前回溯多少天的订单, 见 types.ParsePurgeGuardDays
 }
 
 // NewPubService 返回默认的 pubServiceImpl 实例
-func NewPubService(repo repository.PubRepo, es *elasticsearch.Client, gncRepo repository.GncRepo) PubService {
-	return &pubServiceImpl{repo: repo, es: es, gncRepo: gncRepo}
+func NewPubService(repo repository.PubRepo, es *elasticsearch.Client, gncRepo repository.GncRepo, purgeGuardDays int) PubService {
+	return &pubServiceImpl{repo: repo, es: es, gncRepo: gncRepo, purgeGuardDays: purgeGuardDays}
+}
+
+// errESNotInitialised config.InitES 连接失败时 es 为 nil, 搜索/索引返回错误而不是 panic
+var errESNotInitialised = errors.New("elasticsearch client is not initialised")
+
+type unavailableTransport struct{}
+
+func (unavailableTransport) Perform(*http.Request) (*http.Response, error) {
+	return nil, errESNotInitialised
+}
+
+// esTransport es 为 nil 时返回总是报错的 Transport, 调用方按 ES 请求失败处理
+func (s *pubServiceImpl) esTransport() esapi.Transport {
+	if s.es == nil {
+		return unavailableTransport{}
+	}
+	return s.es.Transport
 }
 
 // -------------------------------------------------------------------
@@ -52,7 +116,10 @@ func NewPubService(repo repository.PubRepo, es *elasticsearch.Client, gncRepo re
 // -------------------------------------------------------------------
 func (s *pubServiceImpl) Create(dto *types.PubDTO) (*types.PubDTO, error) {
 	if dto.PublicCode == "" {
-		return nil, errors.New("publicCode is required")
+		return nil, types.NewValidationError("publicCode is required")
+	}
+	if err := s.checkNotTrashed(dto.PublicCode); err != nil {
+		return nil, err
 	}
 	ent, err := dto.ToEntity()
 	if err != nil {
@@ -62,11 +129,16 @@ func (s *pubServiceImpl) Create(dto *types.PubDTO) (*types.PubDTO, error) {
 	if ent.Status == 0 {
 		ent.Status = 1
 	}
+	if err := s.checkMargin(ent, nil, dto.MarginOverride); err != nil {
+		return nil, err
+	}
 
-	// 1) 写数据库
+	// 1) 写数据库 (组合快照以当前 base 为准)
+	s.captureSnapshots(ent.Compositions)
 	if err := s.repo.CreatePub(ent); err != nil {
 		return nil, err
 	}
+	s.recordPriceChange(types.PubEntity{}, ent, types.PriceSourceCreate)
 
 	// 2) 同步到 ES
 	if err := s.indexToES(ent); err != nil {
@@ -101,6 +173,9 @@ func (s *pubServiceImpl) UpdateByPublicCode(publicCode string, dto *types.PubDTO
 		return nil, err
 	}
 
+	// 记下旧价格, 用于写价格历史
+	oldPrice := *oldEnt
+
 	// 2) 用 dto 覆盖旧记录字段
 	if dto.SalePrice != 0 {
 		oldEnt.SalePrice = dto.SalePrice
@@ -125,6 +200,7 @@ func (s *pubServiceImpl) UpdateByPublicCode(publicCode string, dto *types.PubDTO
 	}
 	if dto.Status != 0 {
 		oldEnt.Status = dto.Status
+		oldEnt.ScheduleOff = false // 人工设置状态, 不再由时间窗自动上架
 	}
 	if len(dto.Categories) > 0 {
 		oldEnt.Categories = dto.Categories
@@ -138,8 +214,21 @@ func (s *pubServiceImpl) UpdateByPublicCode(publicCode string, dto *types.PubDTO
 	if dto.ProductName != "" {
 		oldEnt.ProductName = dto.ProductName
 	}
+	// 库存/限购: 请求体中出现即写入, 0 表示取消限制
+	if dto.StockTotal != nil {
+		oldEnt.StockTotal = *dto.StockTotal
+	}
+	if dto.DailyStock != nil {
+		oldEnt.DailyStock = *dto.DailyStock
+	}
+	if dto.LimitPerPhone != nil {
+		oldEnt.LimitPerPhone = *dto.LimitPerPhone
+	}
+	if dto.LimitPerUser != nil {
+		oldEnt.LimitPerUser = *dto.LimitPerUser
+	}
 
-	// 更新组合(Compositions)
+	// 更新组合(Compositions): 省略(nil)时保持不变, 传空数组表示清空
 	if len(dto.Compositions) > 0 {
 		newComps := make([]types.PubComposeEntity, len(dto.Compositions))
 		for i, cDto := range dto.Compositions {
@@ -148,15 +237,19 @@ func (s *pubServiceImpl) UpdateByPublicCode(publicCode string, dto *types.PubDTO
 			newComps[i].Snapshot = cDto.Snapshot
 		}
 		oldEnt.Compositions = newComps
-	} else {
-		// 这里视业务：若前端传空数组, 可能表示清空
+		s.captureSnapshots(oldEnt.Compositions)
+	} else if dto.Compositions != nil {
 		oldEnt.Compositions = nil
 	}
+	if err := s.checkMargin(oldEnt, &oldPrice, dto.MarginOverride); err != nil {
+		return nil, err
+	}
 
 	// 3) 更新数据库
 	if err := s.repo.UpdatePub(oldEnt); err != nil {
 		return nil, err
 	}
+	s.recordPriceChange(oldPrice, oldEnt, types.PriceSourceManual)
 
 	// 4) 同步到 ES
 	if err := s.indexToES(oldEnt); err != nil {
@@ -170,7 +263,57 @@ func (s *pubServiceImpl) UpdateByPublicCode(publicCode string, dto *types.PubDTO
 }
 
 // -------------------------------------------------------------------
-// 4) Delete
+// 3.1) Patch: 只修改 patch 涉及的字段, 零值也会写入
+// -------------------------------------------------------------------
+func (s *pubServiceImpl) PatchByPublicCode(publicCode string, patch *types.Patch) (*types.PubDTO, error) {
+	// 1) 旧记录 => DTO, 应用 patch
+	oldEnt, err := s.repo.GetPubByPublicCode(publicCode)
+	if err != nil {
+		return nil, err
+	}
+	var dto types.PubDTO
+	_ = dto.FromEntity(oldEnt)
+	if err := patch.Apply(&dto, types.PubPatchFields); err != nil {
+		return nil, fmt.Errorf("%w: %v", types.ErrInvalidPatch, err)
+	}
+
+	// 2) DTO => 实体, 只读字段沿用旧值
+	ent, err := dto.ToEntity()
+	if err != nil {
+		return nil, err
+	}
+	ent.ID = oldEnt.ID
+	ent.PublicCode = oldEnt.PublicCode
+	ent.StockUsed = oldEnt.StockUsed
+	ent.NeedsReview = oldEnt.NeedsReview
+	ent.ReviewReason = oldEnt.ReviewReason
+	ent.ScheduleOff = oldEnt.ScheduleOff && !patch.Has("status")
+	for i := range ent.Compositions {
+		ent.Compositions[i].ID = 0
+	}
+	if patch.Has("compositions") {
+		s.captureSnapshots(ent.Compositions)
+	}
+	if err := s.checkMargin(ent, oldEnt, patch.MarginOverride); err != nil {
+		return nil, err
+	}
+
+	// 3) 更新数据库 & ES
+	if err := s.repo.UpdatePub(ent); err != nil {
+		return nil, err
+	}
+	s.recordPriceChange(*oldEnt, ent, types.PriceSourceManual)
+	if err := s.indexToES(ent); err != nil {
+		return nil, fmt.Errorf("ES index error: %w", err)
+	}
+
+	var updated types.PubDTO
+	_ = updated.FromEntity(ent)
+	return &updated, nil
+}
+
+// -------------------------------------------------------------------
+// 4) Delete (软删除, 进回收站)
 // -------------------------------------------------------------------
 func (s *pubServiceImpl) DeleteByPublicCode(publicCode string) error {
 	// 1) 先查实体
@@ -244,14 +387,14 @@ func (s *pubServiceImpl) SearchByKeyword(keyword string, page, size int64) ([]Gr
 		Index: []string{"vip_pub"}, // 你的 ES 索引名
 		Body:  bytes.NewReader(bodyBytes),
 	}
-	resp, err := reqES.Do(context.Background(), s.es.Transport)
+	resp, err := reqES.Do(context.Background(), s.esTransport())
 	if err != nil {
-		return nil, 0, fmt.Errorf("ES search error: %v", err)
+		return nil, 0, types.NewUnavailableError("SEARCH_UNAVAILABLE", "search is unavailable", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.IsError() {
-		return nil, 0, fmt.Errorf("ES search status: %s", resp.Status())
+		return nil, 0, types.NewUnavailableError("SEARCH_UNAVAILABLE", "search is unavailable", fmt.Errorf("ES search status: %s", resp.Status()))
 	}
 
 	// 3) 解析返回
@@ -334,6 +477,8 @@ func (s *pubServiceImpl) fillFromDBAndUpdateES(dto *types.PubDTO) error {
 	}
 	var dbDTO types.PubDTO
 	_ = dbDTO.FromEntity(ent)
+	// 上下架状态以 DB 为准, 避免回写 ES 时把状态写成 0
+	dto.Status = dbDTO.Status
 
 	// 如果 dbDTO 也是空, 说明确实无数据
 	// => 标识这个文档已检查过(空)
@@ -492,9 +637,9 @@ func (s *pubServiceImpl) fetchEsCategories() ([]string, error) {
 		Index: []string{"vip_pub"},
 		Body:  bytes.NewReader(bodyBytes),
 	}
-	resp, err := reqES.Do(context.Background(), s.es.Transport)
+	resp, err := reqES.Do(context.Background(), s.esTransport())
 	if err != nil {
-		return nil, fmt.Errorf("ES agg error: %w", err)
+		return nil, types.NewUnavailableError("SEARCH_UNAVAILABLE", "search is unavailable", err)
 	}
 	defer resp.Body.Close()
 
@@ -520,9 +665,9 @@ func (s *pubServiceImpl) fetchEsCategories() ([]string, error) {
 	return cats, nil
 }
 
-// indexToES 把 pubEntity 同步到 ES
-func (s *pubServiceImpl) indexToES(ent *types.PubEntity) error {
-	doc := map[string]interface{}{
+// esDoc 组装 vip_pub 的完整文档
+func esDoc(ent *types.PubEntity) map[string]interface{} {
+	return map[string]interface{}{
 		"id":               ent.PublicCode, // _id
 		"name":             ent.ProductName,
 		"tag":              ent.Tag,
@@ -534,10 +679,17 @@ func (s *pubServiceImpl) indexToES(ent *types.PubEntity) error {
 		"cover":            ent.Cover,
 		"pics":             ent.Pics,
 		"fetched":          ent.Fetched,
+		"status":           ent.Status,
+		"margin":           ent.Margin,
+		"needsReview":      ent.NeedsReview,
 		"created_at":       time.Now().Format(time.RFC3339),
 		"updated_at":       time.Now().Format(time.RFC3339),
 	}
-	bodyBytes, _ := json.Marshal(doc)
+}
+
+// indexToES 把 pubEntity 同步到 ES
+func (s *pubServiceImpl) indexToES(ent *types.PubEntity) error {
+	bodyBytes, _ := json.Marshal(esDoc(ent))
 
 	reqES := esapi.IndexRequest{
 		Index:      "vip_pub", // 你的索引名
@@ -545,7 +697,7 @@ func (s *pubServiceImpl) indexToES(ent *types.PubEntity) error {
 		Body:       bytes.NewReader(bodyBytes),
 		Refresh:    "true", // dev环境可用, 生产可去掉
 	}
-	resp, err := reqES.Do(context.Background(), s.es.Transport)
+	resp, err := reqES.Do(context.Background(), s.esTransport())
 	if err != nil {
 		return err
 	}
@@ -563,7 +715,7 @@ func (s *pubServiceImpl) deleteFromES(ent *types.PubEntity) error {
 		DocumentID: ent.PublicCode,
 		Refresh:    "true",
 	}
-	resp, err := reqES.Do(context.Background(), s.es.Transport)
+	resp, err := reqES.Do(context.Background(), s.esTransport())
 	if err != nil {
 		return err
 	}
@@ -578,6 +730,68 @@ func (s *pubServiceImpl) deleteFromES(ent *types.PubEntity) error {
 	return nil
 }
 
+// bulkUpdateES 用 _bulk 对多个文档做局部更新, key 为文档ID(publicCode)
+// 文档不存在的条目只记录日志, 其余失败返回 error
+func (s *pubServiceImpl) bulkUpdateES(docs map[string]map[string]interface{}) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	for id, fields := range docs {
+		meta := map[string]interface{}{
+			"update": map[string]interface{}{"_index": "vip_pub", "_id": id},
+		}
+		metaBytes, _ := json.Marshal(meta)
+		docBytes, _ := json.Marshal(map[string]interface{}{"doc": fields})
+		buf.Write(metaBytes)
+		buf.WriteByte('\n')
+		buf.Write(docBytes)
+		buf.WriteByte('\n')
+	}
+
+	reqES := esapi.BulkRequest{
+		Body:    &buf,
+		Refresh: "true",
+	}
+	resp, err := reqES.Do(context.Background(), s.esTransport())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return fmt.Errorf("ES bulk error: %s", resp.Status())
+	}
+
+	var br struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string `json:"_id"`
+			Status int    `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&br); err != nil {
+		return err
+	}
+	if !br.Errors {
+		return nil
+	}
+	failed := 0
+	for _, item := range br.Items {
+		for _, r := range item {
+			switch {
+			case r.Status == 404:
+				log.Printf("[WARN] bulkUpdateES: doc %s not in ES, skipped\n", r.ID)
+			case r.Status >= 300:
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("ES bulk update: %d items failed", failed)
+	}
+	return nil
+}
+
 // stringValue is a helper to safely convert an interface{} to a string
 func stringValue(v interface{}) string {
 	if v == nil {
@@ -594,42 +808,11 @@ func boolValue(v interface{}) bool {
 	}
 	return false
 }
-func (s *pubServiceImpl) BatchAddCategoryForPrefix(prefix, category string, tag string) error {
-	// 1) 从 DB 中查出所有名称以 prefix 开头的 Pub
-	var pubs []types.PubEntity
-	if err := s.repo.FindPubByNamePrefix(prefix, &pubs); err != nil {
-		return err
-	}
-	if len(pubs) == 0 {
-		log.Printf("没有找到任何名称以 %q 开头的产品", prefix)
-		return nil
-	}
-
-	// 2) 给每个 pubEntity 的 Categories 追加 category, 并同步到 ES
-	for i := range pubs {
-		pub := &pubs[i]
-		pub.Tag = tag
-		// 如果已经包含就不重复添加
-		if !containsString(pub.Categories, category) {
-			pub.Categories = append(pub.Categories, category)
-		}
-		// 方式1: 仅写ES(如果 Categories 不存MySQL):
-		if err := s.indexToES(pub); err != nil {
-			log.Printf("[WARN] indexToES failed for %s: %v\n", pub.PublicCode, err)
-			// 也可选择return err看你业务需求
-		}
 
-		// 方式2(可选): 如果你希望 DB 也存 categories_json,
-		//   则可以通过 UpdatePub(...) 触发 DB+ES 同步:
-		// var dto types.PubDTO
-		// _ = dto.FromEntity(pub)
-		// if _, err := s.UpdateByPublicCode(pub.PublicCode, &dto); err != nil {
-		//     log.Printf("[WARN] UpdateByPublicCode failed for %s: %v\n", pub.PublicCode, err)
-		// }
-	}
-
-	log.Printf("已为 %d 个产品追加分类 %q 并同步ES", len(pubs), category)
-	return nil
+// BatchAddCategoryForPrefix 兼容旧接口: 等价于只按名称前缀圈选的 BatchCategorize
+func (s *pubServiceImpl) BatchAddCategoryForPrefix(prefix, category string, tag string) error {
+	_, _, err := s.BatchCategorize(types.PubSelector{NamePrefix: prefix}, category, tag)
+	return err
 }
 
 func (s *pubServiceImpl) GetBaseCodesByPublicCode(publicCode string) ([]string, error) {
@@ -710,7 +893,7 @@ func CateToSmallPositive(cate string) (int64, error) {
 
 	// 若还没出现过，但已到达上限
 	if nextID > maxCateCount {
-		return 0, fmt.Errorf("分类已达上限(%d)，无法为 %q 分配新的ID", maxCateCount, cate)
+		return 0, types.NewConflictError("CATEGORY_LIMIT_REACHED", fmt.Sprintf("category limit (%d) reached, cannot allocate an id for %q", maxCateCount, cate), nil)
 	}
 
 	ephemeralMap[cate] = nextID
@@ -737,7 +920,7 @@ func (s *pubServiceImpl) GetGncOriginDataByPublicCode(publicCode string) (string
 	}
 
 	if len(baseCodes) == 0 {
-		return "", errors.New("no baseCodes found for this publicCode")
+		return "", types.NewNotFoundError("BASE_NOT_FOUND", "no baseCodes found for this publicCode", nil)
 	}
 
 	// 2. We'll use the first baseCode to find the GncEntity
@@ -753,3 +936,8 @@ func (s *pubServiceImpl) GetGncOriginDataByPublicCode(publicCode string) (string
 	// 4. Return the OriginData
 	return gncEntity.OriginData, nil
 }
+
+// LocalCatalog 已同步到本地的某个来源的在架商品, baseCodes 为空表示全部
+func (s *pubServiceImpl) LocalCatalog(source string, baseCodes []string) ([]types.GncEntity, error) {
+	return s.gncRepo.ListGncBySource(source, baseCodes)
+}
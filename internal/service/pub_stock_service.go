@@ -0,0 +1,37 @@
+// internal/service/pub_stock_service.go
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+// StockReserver 下单链路使用的库存/限购预占, 由 PubService 实现并注入 OrderService
+type StockReserver interface {
+	ReserveStock(ctx context.Context, res types.StockReservation) error
+	ReleaseStock(ctx context.Context, orderId string) error
+}
+
+// ReserveStock 校验并预占库存与限购额度, 失败时返回 types.ErrOutOfStock 等错误
+func (s *pubServiceImpl) ReserveStock(ctx context.Context, res types.StockReservation) error {
+	if res.PublicCode == "" || res.OrderId == "" {
+		return nil
+	}
+	day := time.Now().Format("2006-01-02")
+	return s.repo.ReserveStock(res, day)
+}
+
+// ReleaseStock 订单进入失败状态后释放预占, 可重复调用
+func (s *pubServiceImpl) ReleaseStock(ctx context.Context, orderId string) error {
+	released, err := s.repo.ReleaseStock(orderId)
+	if err != nil {
+		return err
+	}
+	if released {
+		slog.InfoContext(ctx, "stock reservation released", "orderId", orderId)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// internal/service/pub_strategy_service.go
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+
+	"10000hk.com/vip_gift/internal/types"
+)
+
+// PlanFulfillment 根据 pub 的组合与策略, 给出下发到上游的 base 顺序:
+// - 已下架(IsShelve=0)的 base 不参与; all 策略下不存在/已下架的 base 标记 Unavailable 后保留, 下单时落失败子单
+// - failover / all: 保持组合顺序
+// - cheapest: 按 base 售价升序, 查不到 Gnc 的排最后
+// - weighted: 按权重随机选出第一个, 其余保持组合顺序作为兜底
+func (s *pubServiceImpl) PlanFulfillment(ctx context.Context, publicCode string) (*types.FulfillmentPlan, error) {
+	ent, err := s.repo.GetPubByPublicCode(publicCode)
+	if err != nil {
+		return nil, err
+	}
+	if len(ent.Compositions) == 0 {
+		return nil, types.NewValidationError(fmt.Sprintf("publicCode=%s has no compositions", publicCode))
+	}
+
+	strategy, _ := types.ParseStrategy(ent.Compositions[0].Strategy)
+	plan := &types.FulfillmentPlan{Strategy: strategy}
+	var unknownPrice []types.FulfillmentTarget
+
+	for _, comp := range ent.Compositions {
+		_, weight := types.ParseStrategy(comp.Strategy)
+		target := types.FulfillmentTarget{
+			BaseCode: comp.BaseCode,
+			Source:   "VIP_GIFT",
+			Weight:   weight,
+		}
+		gnc, err := s.gncRepo.GetGncByBaseCode(comp.BaseCode)
+		if err != nil && strategy == types.StrategyAll {
+			slog.WarnContext(ctx, "PlanFulfillment: gnc not found, bundle item will fail", "publicCode", publicCode, "baseCode", comp.BaseCode, "error", err)
+			target.Unavailable = "base not found"
+			plan.Targets = append(plan.Targets, target)
+			continue
+		}
+		if err != nil {
+			// 本地没有该 base, 仍按 baseCode 直接下发给 gift
+			slog.WarnContext(ctx, "PlanFulfillment: gnc not found", "publicCode", publicCode, "baseCode", comp.BaseCode, "error", err)
+			unknownPrice = append(unknownPrice, target)
+			continue
+		}
+		if gnc.IsShelve == 0 {
+			slog.WarnContext(ctx, "PlanFulfillment: gnc is off shelve, skipped", "publicCode", publicCode, "baseCode", comp.BaseCode)
+			if strategy == types.StrategyAll {
+				target.Unavailable = "base is off shelve"
+				plan.Targets = append(plan.Targets, target)
+			}
+			continue
+		}
+		target.SalePrice = gnc.SalePrice
+		if pid := gnc.UpstreamProductId(); pid != "" {
+			target.ProductId = pid
+			target.Source = "VIP_FULU"
+		}
+		plan.Targets = append(plan.Targets, target)
+	}
+
+	switch strategy {
+	case types.StrategyCheapest:
+		sort.SliceStable(plan.Targets, func(i, j int) bool {
+			return plan.Targets[i].SalePrice < plan.Targets[j].SalePrice
+		})
+		plan.Targets = append(plan.Targets, unknownPrice...)
+	case types.StrategyWeighted:
+		plan.Targets = append(plan.Targets, unknownPrice...)
+		pickWeighted(plan.Targets)
+	default:
+		plan.Targets = keepCompositionOrder(ent.Compositions, append(plan.Targets, unknownPrice...))
+	}
+
+	if len(plan.Targets) == 0 {
+		return nil, types.NewUnavailableError("NO_AVAILABLE_BASE", fmt.Sprintf("publicCode=%s has no available base product", publicCode), nil)
+	}
+	return plan, nil
+}
+
+// pickWeighted 按权重随机选一个放到首位, 其余相对顺序不变
+func pickWeighted(targets []types.FulfillmentTarget) {
+	total := 0
+	for _, t := range targets {
+		total += t.Weight
+	}
+	if total <= 0 || len(targets) < 2 {
+		return
+	}
+	n := rand.Intn(total)
+	for i, t := range targets {
+		if n < t.Weight {
+			chosen := targets[i]
+			copy(targets[1:i+1], targets[:i])
+			targets[0] = chosen
+			return
+		}
+		n -= t.Weight
+	}
+}
+
+// keepCompositionOrder 把 targets 恢复成组合里的原始顺序
+func keepCompositionOrder(comps []types.PubComposeEntity, targets []types.FulfillmentTarget) []types.FulfillmentTarget {
+	byCode := make(map[string]types.FulfillmentTarget, len(targets))
+	for _, t := range targets {
+		byCode[t.BaseCode] = t
+	}
+	ordered := make([]types.FulfillmentTarget, 0, len(targets))
+	for _, c := range comps {
+		if t, ok := byCode[c.BaseCode]; ok {
+			ordered = append(ordered, t)
+			delete(byCode, c.BaseCode)
+		}
+	}
+	return ordered
+}
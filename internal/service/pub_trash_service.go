@@ -0,0 +1,77 @@
+// internal/service/pub_trash_service.go
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"10000hk.com/vip_gift/internal/types"
+	"gorm.io/gorm"
+)
+
+// ListTrash 回收站中的 pub, 带删除时间
+func (s *pubServiceImpl) ListTrash(page, size int64) ([]types.PubDTO, int64, error) {
+	ents, total, err := s.repo.ListTrashedPub(page, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	result := make([]types.PubDTO, len(ents))
+	for i := range ents {
+		_ = result[i].FromEntity(&ents[i])
+	}
+	return result, total, nil
+}
+
+// RestoreByPublicCode 移出回收站并重建 ES 文档
+func (s *pubServiceImpl) RestoreByPublicCode(publicCode string) (*types.PubDTO, error) {
+	if err := s.repo.RestorePub(publicCode); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, types.NewNotFoundError("NOT_IN_TRASH", fmt.Sprintf("pub %s is not in trash", publicCode), err)
+		}
+		return nil, err
+	}
+	ent, err := s.repo.GetPubByPublicCode(publicCode)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.indexToES(ent); err != nil {
+		return nil, fmt.Errorf("failed to index to ES: %w", err)
+	}
+	var dto types.PubDTO
+	_ = dto.FromEntity(ent)
+	return &dto, nil
+}
+
+// PurgeByPublicCode 永久删除回收站中的 pub; 最近 purgeGuardDays 天内有订单引用时拒绝
+func (s *pubServiceImpl) PurgeByPublicCode(publicCode string) error {
+	if _, err := s.repo.GetTrashedPub(publicCode); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return types.NewNotFoundError("NOT_IN_TRASH", fmt.Sprintf("pub %s is not in trash", publicCode), err)
+		}
+		return err
+	}
+	if s.purgeGuardDays > 0 {
+		since := time.Now().AddDate(0, 0, -s.purgeGuardDays)
+		n, err := s.repo.CountOrdersSince(publicCode, since)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("%w: %d orders in the last %d days", types.ErrReferencedByOrders, n, s.purgeGuardDays)
+		}
+	}
+	return s.repo.PurgePub(publicCode)
+}
+
+// checkNotTrashed publicCode 唯一, 回收站中的同编码 pub 需先恢复或永久删除
+func (s *pubServiceImpl) checkNotTrashed(publicCode string) error {
+	codes, err := s.repo.ListTrashedPublicCodes([]string{publicCode})
+	if err != nil {
+		return err
+	}
+	if len(codes) > 0 {
+		return fmt.Errorf("%w: %s", types.ErrInTrash, publicCode)
+	}
+	return nil
+}
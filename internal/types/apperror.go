@@ -0,0 +1,197 @@
+// internal/types/apperror.go
+package types
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrorKind 领域错误分类, 决定 HTTP 状态码
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindRateLimited
+	KindUnavailable
+)
+
+// HTTPStatus 分类对应的 HTTP 状态码
+func (k ErrorKind) HTTPStatus() int {
+	switch k {
+	case KindValidation:
+		return http.StatusBadRequest
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindForbidden:
+		return http.StatusForbidden
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindRateLimited:
+		return http.StatusTooManyRequests
+	case KindUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Code 分类的默认错误码
+func (k ErrorKind) Code() string {
+	switch k {
+	case KindValidation:
+		return "VALIDATION_FAILED"
+	case KindUnauthorized:
+		return "UNAUTHORIZED"
+	case KindForbidden:
+		return "FORBIDDEN"
+	case KindNotFound:
+		return "NOT_FOUND"
+	case KindConflict:
+		return "CONFLICT"
+	case KindRateLimited:
+		return "RATE_LIMITED"
+	case KindUnavailable:
+		return "UPSTREAM_UNAVAILABLE"
+	default:
+		return "INTERNAL_ERROR"
+	}
+}
+
+// KindOfStatus 由 HTTP 状态码反推分类, 供 ErrorJSON 等只带状态码的旧调用使用
+func KindOfStatus(status int) ErrorKind {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return KindValidation
+	case http.StatusUnauthorized:
+		return KindUnauthorized
+	case http.StatusForbidden:
+		return KindForbidden
+	case http.StatusNotFound:
+		return KindNotFound
+	case http.StatusConflict:
+		return KindConflict
+	case http.StatusTooManyRequests:
+		return KindRateLimited
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return KindUnavailable
+	default:
+		return KindInternal
+	}
+}
+
+// AppError 对外的领域错误: Message 返回给客户端, Err 只写日志
+type AppError struct {
+	Kind    ErrorKind
+	Code    string // 稳定的机器可读错误码, 如 PUB_NOT_FOUND; 空时取 Kind.Code()
+	Message string
+	Err     error
+}
+
+func (e *AppError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *AppError) Unwrap() error { return e.Err }
+
+// ErrorCode 对外的错误码
+func (e *AppError) ErrorCode() string {
+	if e.Code != "" {
+		return e.Code
+	}
+	return e.Kind.Code()
+}
+
+// NewValidationError 请求参数不合法, 错误码统一为 VALIDATION_FAILED
+func NewValidationError(msg string) *AppError {
+	return &AppError{Kind: KindValidation, Message: msg}
+}
+
+func NewNotFoundError(code, msg string, err error) *AppError {
+	return &AppError{Kind: KindNotFound, Code: code, Message: msg, Err: err}
+}
+
+func NewConflictError(code, msg string, err error) *AppError {
+	return &AppError{Kind: KindConflict, Code: code, Message: msg, Err: err}
+}
+
+func NewUnauthorizedError(code, msg string) *AppError {
+	return &AppError{Kind: KindUnauthorized, Code: code, Message: msg}
+}
+
+func NewUnavailableError(code, msg string, err error) *AppError {
+	return &AppError{Kind: KindUnavailable, Code: code, Message: msg, Err: err}
+}
+
+// sentinelErrors 已有哨兵错误到分类/错误码的映射; 消息由本服务拼装, 可直接返回给客户端
+var sentinelErrors = []struct {
+	err  error
+	kind ErrorKind
+	code string
+}{
+	{ErrOutOfStock, KindConflict, "OUT_OF_STOCK"},
+	{ErrDailyStockExhausted, KindConflict, "DAILY_STOCK_EXHAUSTED"},
+	{ErrPurchaseLimitReached, KindConflict, "PURCHASE_LIMIT_REACHED"},
+	{ErrInvalidPatch, KindValidation, "INVALID_PATCH"},
+	{ErrMarginViolation, KindValidation, "MARGIN_VIOLATION"},
+	{ErrInTrash, KindConflict, "IN_TRASH"},
+	{ErrReferencedByOrders, KindConflict, "REFERENCED_BY_ORDERS"},
+	{ErrReferencedByPubs, KindConflict, "REFERENCED_BY_PUBS"},
+	{ErrInvalidSignature, KindUnauthorized, "INVALID_SIGNATURE"},
+	{ErrReplayedRequest, KindUnauthorized, "REPLAYED_REQUEST"},
+	{ErrPartnerDisabled, KindUnauthorized, "PARTNER_DISABLED"},
+	{ErrPartnerInactive, KindForbidden, "PARTNER_INACTIVE"},
+	{ErrChannelNotAllowed, KindForbidden, "CHANNEL_NOT_ALLOWED"},
+	{ErrPartnerHasRelations, KindConflict, "PARTNER_HAS_RELATIONS"},
+	{ErrRateLimited, KindRateLimited, "RATE_LIMITED"},
+	{ErrQuotaExceeded, KindRateLimited, "QUOTA_EXCEEDED"},
+	{ErrCircuitOpen, KindUnavailable, "UPSTREAM_CIRCUIT_OPEN"},
+	{ErrBulkheadFull, KindUnavailable, "UPSTREAM_BUSY"},
+	{gorm.ErrRecordNotFound, KindNotFound, "NOT_FOUND"},
+}
+
+// AsAppError 把任意错误归类为 AppError; 未识别的错误一律视为内部错误,
+// 不向客户端暴露原始信息(GORM/ES 细节等)
+func AsAppError(err error) *AppError {
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	for _, s := range sentinelErrors {
+		if errors.Is(err, s.err) {
+			return &AppError{Kind: s.kind, Code: s.code, Message: err.Error(), Err: err}
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return NewUnavailableError("UPSTREAM_TIMEOUT", "upstream request timed out", err)
+	}
+	return &AppError{Kind: KindInternal, Message: "internal server error", Err: err}
+}
+
+// ParseLegacyErrorStatus 兼容旧客户端: true 时错误响应仍用 HTTP 200, 真实状态码只放在 body.code; 空串为 false
+func ParseLegacyErrorStatus(s string) (bool, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return false, nil
+	}
+	on, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid legacy error status flag: %s", s)
+	}
+	return on, nil
+}
@@ -0,0 +1,154 @@
+// internal/types/catalog.go
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// 商品目录来源, 写入 GncEntity.Source / GncSyncRunEntity.Source
+const (
+	CatalogGift   = "gift"
+	CatalogCharge = "charge"
+)
+
+// CatalogItem 各来源商品映射到 GncEntity 前的统一结构
+type CatalogItem struct {
+	BaseCode     string
+	ProductName  string
+	ProductType  int64
+	ParValue     float64
+	SalePrice    float64
+	IsShelve     int64
+	ProductDesc  string
+	ProductCover string
+	ProductPics  []string
+	Raw          string   // 上游原始 JSON, 写入 UpstreamRaw
+	Unmapped     []string // 映射里没有的字段, 同步时保留本地值, 见 KeepLocal
+}
+
+// 可由同步保留本地值的字段; baseCode 必须映射, isShelve 未映射时视为上架
+var keepableFields = []string{"productName", "productType", "parValue", "productDesc", "productCover", "productPics"}
+
+// KeepLocal 用本地 base 的值填充未映射字段(如 charge 的 productType/parValue), 避免同步时被清零
+func (item *CatalogItem) KeepLocal(ent *GncEntity) {
+	for _, key := range item.Unmapped {
+		switch key {
+		case "productName":
+			item.ProductName = ent.ProductName
+		case "productType":
+			item.ProductType = ent.ProductType
+		case "parValue":
+			item.ParValue = ent.ParValue
+		case "productDesc":
+			item.ProductDesc = ent.ProductDesc
+		case "productCover":
+			item.ProductCover = ent.ProductCover
+		case "productPics":
+			item.ProductPics = ent.ProductPics
+		}
+	}
+}
+
+// FieldMapping CatalogItem 字段 => 上游 JSON 字段, 可选 key:
+// baseCode productName productType parValue salePrice isShelve productDesc productCover productPics
+type FieldMapping map[string]string
+
+// ParseFieldMapping 解析 JSON 形式的映射并覆盖到默认映射上; s 为空时直接返回默认映射
+func ParseFieldMapping(s string, defaults FieldMapping) (FieldMapping, error) {
+	m := make(FieldMapping, len(defaults))
+	for k, v := range defaults {
+		m[k] = v
+	}
+	if s == "" {
+		return m, nil
+	}
+	var override map[string]string
+	if err := json.Unmarshal([]byte(s), &override); err != nil {
+		return nil, fmt.Errorf("invalid field mapping: %w", err)
+	}
+	for k, v := range override {
+		m[k] = v
+	}
+	return m, nil
+}
+
+// Apply 按映射把上游单条商品转成 CatalogItem;
+// 上游数字字段可能是字符串(如 charge 的 salePrice), 统一转换; 未映射 isShelve 时视为上架
+func (m FieldMapping) Apply(raw json.RawMessage) (CatalogItem, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return CatalogItem{}, err
+	}
+	item := CatalogItem{
+		BaseCode:     anyString(obj[m["baseCode"]]),
+		ProductName:  anyString(obj[m["productName"]]),
+		ProductType:  int64(anyFloat(obj[m["productType"]])),
+		ParValue:     anyFloat(obj[m["parValue"]]),
+		SalePrice:    anyFloat(obj[m["salePrice"]]),
+		IsShelve:     1,
+		ProductDesc:  anyString(obj[m["productDesc"]]),
+		ProductCover: anyString(obj[m["productCover"]]),
+		ProductPics:  anyStrings(obj[m["productPics"]]),
+		Raw:          string(raw),
+	}
+	for _, key := range keepableFields {
+		if m[key] == "" {
+			item.Unmapped = append(item.Unmapped, key)
+		}
+	}
+	if key := m["isShelve"]; key != "" {
+		item.IsShelve = int64(anyFloat(obj[key]))
+	}
+	if item.BaseCode == "" {
+		return item, fmt.Errorf("field %q is empty", m["baseCode"])
+	}
+	return item, nil
+}
+
+func anyString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func anyFloat(v interface{}) float64 {
+	switch t := v.(type) {
+	case float64:
+		return t
+	case string:
+		f, _ := strconv.ParseFloat(t, 64)
+		return f
+	case bool:
+		if t {
+			return 1
+		}
+	}
+	return 0
+}
+
+func anyStrings(v interface{}) []string {
+	switch t := v.(type) {
+	case []interface{}:
+		result := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	case string:
+		if t != "" {
+			return []string{t}
+		}
+	}
+	return []string{}
+}
@@ -2,9 +2,15 @@
 package types
 
 import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/copier"
+	"gorm.io/gorm"
 )
 
 // ------------------
@@ -25,7 +31,10 @@ type GncDTO struct {
 	CallbackURL string `json:"callbackUrl"`
 	QueryURL    string `json:"queryUrl"`
 
-	OriginData string `json:"originData"`
+	OriginData  string `json:"originData"`
+	UpstreamRaw string `json:"upstreamRaw,omitempty"` // 只读: 同步写入的上游原始条目
+
+	DeletedAt *time.Time `json:"deletedAt,omitempty"` // 只读: 回收站中的删除时间
 }
 
 // FromEntity uses helper to unify the logic
@@ -54,6 +63,8 @@ type PubComposeDTO struct {
 	BaseCode     string `json:"baseCode"`
 	Strategy     string `json:"strategy"`
 	Snapshot     string `json:"snapshot"`
+	// 仅输出: 服务端捕获快照的时间
+	SnapshotAt *time.Time `json:"snapshotAt,omitempty"`
 }
 
 // FromEntity uses helper
@@ -95,6 +106,24 @@ type PubDTO struct {
 
 	Compositions []PubComposeDTO `json:"compositions,omitempty"`
 	Fetched      bool            `json:"fetched"`
+
+	// 库存/限购: nil 表示 PUT 时不修改, 传 0 表示取消限制
+	StockTotal    *int64 `json:"stockTotal,omitempty"`    // 总库存, 0=不限
+	StockUsed     int64  `json:"stockUsed,omitempty"`     // 只读
+	DailyStock    *int64 `json:"dailyStock,omitempty"`    // 每日库存, 0=不限
+	LimitPerPhone *int64 `json:"limitPerPhone,omitempty"` // 每个手机号限购, 0=不限
+	LimitPerUser  *int64 `json:"limitPerUser,omitempty"`  // 每个 userSn 限购, 0=不限
+
+	// 只读: base 变更联动维护
+	CostPrice    float64 `json:"costPrice,omitempty"`
+	Margin       float64 `json:"margin,omitempty"`
+	NeedsReview  int64   `json:"needsReview,omitempty"`
+	ReviewReason string  `json:"reviewReason,omitempty"`
+
+	// 只写: 售价低于成本或佣金超过毛利时仍强制保存
+	MarginOverride bool `json:"marginOverride,omitempty"`
+
+	DeletedAt *time.Time `json:"deletedAt,omitempty"` // 只读: 回收站中的删除时间
 }
 
 func (dto *PubDTO) FromEntity(ent *PubEntity) error {
@@ -117,28 +146,50 @@ func (dto *PubDTO) ToEntity() (*PubEntity, error) {
 // 4. OrderDTO
 // ------------------
 type OrderDTO struct {
-	DownstreamOrderId string      `json:"downstreamOrderId"`
-	PublicCode        string      `json:"publicCode"`
-	DataJSON          string      `json:"dataJSON"`
-	OrderId           string      `json:"orderId"`
-	Status            OrderStatus `json:"status"`
-	Remark            string      `json:"remark"`                     // 新增
-	CommissionRule    string      `json:"commissionRule,omitempty"`   // MF CYF YYF
-	UserSn            string      `json:"userSn,omitempty"`           // 用户编号
-	ParentSn          string      `json:"parentSn,omitempty"`         // 上级编号
-	CommissionSelf    float64     `json:"commissionSelf,omitempty"`   // 自己的佣金
-	CommissionParent  float64     `json:"commissionParent,omitempty"` // 上级的佣金
-	TradeStatus       string      `json:"tradeStatus,omitempty"`
-	RefundStatus      string      `json:"refundStatus,omitempty"`
-	DeliveryStatus    int64       `json:"deliveryStatus,omitempty"`
-	SettlementStatus  int64       `json:"settlementStatus,omitempty"`
-	Channel           string      `json:"channel,omitempty"` // 渠道
+	DownstreamOrderId string         `json:"downstreamOrderId"`
+	PublicCode        string         `json:"publicCode"`
+	DataJSON          string         `json:"dataJSON"`
+	OrderId           string         `json:"orderId"`
+	Status            OrderStatus    `json:"status"`
+	Remark            string         `json:"remark"`                     // 新增
+	CommissionRule    string         `json:"commissionRule,omitempty"`   // MF CYF YYF
+	UserSn            string         `json:"userSn,omitempty"`           // 用户编号
+	ParentSn          string         `json:"parentSn,omitempty"`         // 上级编号
+	CommissionSelf    float64        `json:"commissionSelf,omitempty"`   // 自己的佣金
+	CommissionParent  float64        `json:"commissionParent,omitempty"` // 上级的佣金
+	TradeStatus       string         `json:"tradeStatus,omitempty"`
+	RefundStatus      string         `json:"refundStatus,omitempty"`
+	DeliveryStatus    int64          `json:"deliveryStatus,omitempty"`
+	SettlementStatus  int64          `json:"settlementStatus,omitempty"`
+	Channel           string         `json:"channel,omitempty"`      // 渠道
+	SalePrice         float64        `json:"salePrice,omitempty"`    // 下单时价格快照
+	ParValue          float64        `json:"parValue,omitempty"`     // 下单时面值快照
+	CommissionMF      float64        `json:"commissionMF,omitempty"` // 下单时佣金快照
+	Phone             string         `json:"phone,omitempty"`        // 充值手机号, 用于限购
+	Items             []OrderItemDTO `json:"items,omitempty"`        // 组合订单的子单
 }
 type ClientOrderDTO struct {
 	*OrderDTO
 	StatusText string `json:"statusText"`
 }
 
+// OrderItemDTO 子单, 用于订单详情中展示部分失败
+type OrderItemDTO struct {
+	CustomerOrderNo string      `json:"customerOrderNo"`
+	BaseCode        string      `json:"baseCode"`
+	Status          OrderStatus `json:"status"`
+	StatusText      string      `json:"statusText"`
+	Remark          string      `json:"remark,omitempty"`
+}
+
+func (dto *OrderItemDTO) FromEntity(ent *OrderItemEntity) {
+	dto.CustomerOrderNo = ent.CustomerOrderNo
+	dto.BaseCode = ent.BaseCode
+	dto.Status = ent.Status
+	dto.StatusText = ent.Status.String()
+	dto.Remark = ent.Remark
+}
+
 func (dto *OrderDTO) FromEntity(ent *OrderEntity) error {
 	if ent == nil {
 		return nil
@@ -163,6 +214,174 @@ func (dto *OrderDTO) ToClientDTO() (*ClientOrderDTO, error) {
 	return clientDTO, nil
 }
 
+// ------------------
+// 5. PubSelector
+// ------------------
+// PubSelector 批量操作时用来圈选 pub 的规则, 多个条件之间为 AND,
+// 空条件表示不限制
+type PubSelector struct {
+	NamePrefix string   `json:"namePrefix,omitempty"` // 名称前缀
+	NameRegex  string   `json:"nameRegex,omitempty"`  // 名称正则 (Go regexp 语法)
+	BaseCodes  []string `json:"baseCodes,omitempty"`  // 组合中包含任一 baseCode
+	MinPrice   float64  `json:"minPrice,omitempty"`   // salePrice >= MinPrice
+	MaxPrice   float64  `json:"maxPrice,omitempty"`   // salePrice <= MaxPrice
+}
+
+// IsEmpty 没有任何条件时返回 true, 用于防止误操作全表
+func (s PubSelector) IsEmpty() bool {
+	return s.NamePrefix == "" && s.NameRegex == "" && len(s.BaseCodes) == 0 &&
+		s.MinPrice == 0 && s.MaxPrice == 0
+}
+
+// Validate 校验规则可执行: 正则须合法; 只给正则时, 正则里必须有一段字面量,
+// 以便先在 SQL 里用 LIKE 缩小范围, 否则要整表载入内存
+func (s PubSelector) Validate() error {
+	if s.IsEmpty() {
+		return NewValidationError("selector is empty")
+	}
+	if s.NameRegex == "" {
+		return nil
+	}
+	if _, err := regexp.Compile(s.NameRegex); err != nil {
+		return NewValidationError(fmt.Sprintf("invalid nameRegex: %v", err))
+	}
+	narrowed := s.NamePrefix != "" || len(s.BaseCodes) > 0 || s.MinPrice > 0 || s.MaxPrice > 0
+	if !narrowed && RegexLiteral(s.NameRegex) == "" {
+		return NewValidationError("nameRegex has no literal text, combine it with namePrefix/baseCodes/minPrice/maxPrice")
+	}
+	return nil
+}
+
+// RegexLiteral 正则的任何匹配都必须包含的最长一段字面量(区分大小写), 没有时返回 "";
+// 如 ^爱奇艺.*月卡 => 爱奇艺
+func RegexLiteral(expr string) string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	re = uncapture(re.Simplify())
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	longest := ""
+	for _, sub := range subs {
+		sub = uncapture(sub)
+		if sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0 && len(string(sub.Rune)) > len(longest) {
+			longest = string(sub.Rune)
+		}
+	}
+	return longest
+}
+
+func uncapture(re *syntax.Regexp) *syntax.Regexp {
+	for re.Op == syntax.OpCapture {
+		re = re.Sub[0]
+	}
+	return re
+}
+
+// ------------------
+// 6. PriceScheduleDTO
+// ------------------
+// PriceScheduleDTO 新建定时调价的请求体, 价格字段为 nil 表示不调整
+type PriceScheduleDTO struct {
+	SalePrice    *float64  `json:"salePrice,omitempty"`
+	ParValue     *float64  `json:"parValue,omitempty"`
+	CommissionMF *float64  `json:"commissionMF,omitempty"`
+	EffectiveAt  time.Time `json:"effectiveAt"`
+	Remark       string    `json:"remark,omitempty"`
+}
+
+// ------------------
+// 7. AvailabilityWindowDTO
+// ------------------
+type AvailabilityWindowDTO struct {
+	StartAt    *time.Time `json:"startAt,omitempty"`
+	EndAt      *time.Time `json:"endAt,omitempty"`
+	Recurrence string     `json:"recurrence,omitempty"` // "" / daily / weekly
+	DailyStart string     `json:"dailyStart,omitempty"` // HH:MM
+	DailyEnd   string     `json:"dailyEnd,omitempty"`   // HH:MM
+	Weekdays   []int      `json:"weekdays,omitempty"`   // 0=周日 ... 6=周六
+	Remark     string     `json:"remark,omitempty"`
+}
+
+// ------------------
+// 8. StockReservation
+// ------------------
+// StockReservation 下单时向库存/限购发起预占的参数
+type StockReservation struct {
+	OrderId    string
+	PublicCode string
+	Phone      string
+	UserSn     string
+}
+
+// ------------------
+// 9. 组合履约策略
+// ------------------
+// PubComposeEntity.Strategy 的取值; pub 的策略以第一个组合为准,
+// weighted 的权重写在各自组合上, 如 "weighted:30", 缺省为 1
+const (
+	StrategyFailover = "failover" // 默认: 按组合顺序下发, 上游失败时切到下一个
+	StrategyCheapest = "cheapest" // 优先售价最低的可用 base, 失败再按价格依次切换
+	StrategyWeighted = "weighted" // 按权重随机选一个, 失败再按组合顺序切换
+	StrategyAll      = "all"      // 每个组合都下发
+)
+
+// ParseStrategy 解析 "weighted:30" 这类写法, 返回策略名与权重
+// 空字符串或 "primary" 视为 failover
+func ParseStrategy(s string) (string, int) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(strings.ToLower(s)), ":")
+	weight := 1
+	if n, err := strconv.Atoi(arg); err == nil && n > 0 {
+		weight = n
+	}
+	switch name {
+	case StrategyCheapest, StrategyWeighted, StrategyAll:
+		return name, weight
+	default:
+		return StrategyFailover, weight
+	}
+}
+
+// FulfillmentTarget 一个可下发的 base
+type FulfillmentTarget struct {
+	BaseCode  string  `json:"baseCode"`
+	ProductId string  `json:"productId,omitempty"` // 见 GncEntity.UpstreamProductId
+	Source    string  `json:"source"`              // VIP_GIFT / VIP_FULU
+	SalePrice float64 `json:"salePrice"`
+	Weight    int     `json:"weight"`
+	// Unavailable 非空表示该 base 不能下发(不存在/已下架), 只出现在 all 策略中, 组合订单据此落失败子单
+	Unavailable string `json:"unavailable,omitempty"`
+}
+
+// FulfillmentPlan 按策略排好顺序的下发计划
+type FulfillmentPlan struct {
+	Strategy string              `json:"strategy"`
+	Targets  []FulfillmentTarget `json:"targets"`
+}
+
+// ------------------
+// 10. PartnerDTO
+// ------------------
+// PartnerDTO 新建/修改渠道的请求体; level 由层级推导, 不接受客户端传入
+type PartnerDTO struct {
+	UserSn          string   `json:"userSn"`
+	Name            string   `json:"name"`
+	ParentSn        string   `json:"parentSn,omitempty"`
+	Status          string   `json:"status,omitempty"`          // 默认 active
+	AllowedChannels []string `json:"allowedChannels,omitempty"` // CatalogGift / CatalogCharge
+	CommissionShare *float64 `json:"commissionShare,omitempty"` // nil: 新建用默认值, 修改时保持原值
+	RateLimit       int64    `json:"rateLimit,omitempty"`       // 每秒请求数, 0 用全局默认
+	DailyOrderQuota int64    `json:"dailyOrderQuota,omitempty"` // 每日订单数, 0=不限
+	DailyAmountCap  float64  `json:"dailyAmountCap,omitempty"`
+	ContactName     string   `json:"contactName,omitempty"`
+	ContactPhone    string   `json:"contactPhone,omitempty"`
+	ContactEmail    string   `json:"contactEmail,omitempty"`
+	Remark          string   `json:"remark,omitempty"`
+}
+
 // =====================================================================
 // HELPER FUNCTIONS (Private) - One place to unify the logic
 // =====================================================================
@@ -184,6 +403,9 @@ func copyGncDTOToEntity(dto *GncDTO) (*GncEntity, error) {
 		return nil, err
 	}
 	// If you have special logic for pics or others, do it here
+	// 删除状态只能走 Delete/Restore, 上游原始条目只由同步写入, 都不接受客户端传入
+	ent.DeletedAt = gorm.DeletedAt{}
+	ent.UpstreamRaw = ""
 	return ent, nil
 }
 
@@ -243,6 +465,12 @@ func copyPubDTOToEntity(dto *PubDTO) (*PubEntity, error) {
 		}
 		ent.Compositions = comps
 	}
+	// 删除状态只能走 Delete/Restore, 不接受客户端传入
+	ent.DeletedAt = gorm.DeletedAt{}
+	// 只读字段: 已占用库存由下单维护, 成本/毛利/复核由服务端计算
+	ent.StockUsed = 0
+	ent.CostPrice, ent.Margin = 0, 0
+	ent.NeedsReview, ent.ReviewReason = 0, ""
 	return ent, nil
 }
 
@@ -271,3 +499,15 @@ func GetChannel(s string) string {
 	// 其他情况返回 "ytjb.cc"
 	return "ytjb.cc"
 }
+
+// MarginIssue 毛利报表中的一行
+type MarginIssue struct {
+	PublicCode   string  `json:"publicCode"`
+	ProductName  string  `json:"productName"`
+	Status       int64   `json:"status"`
+	SalePrice    float64 `json:"salePrice"`
+	CostPrice    float64 `json:"costPrice"`
+	Margin       float64 `json:"margin"`
+	CommissionMF float64 `json:"commissionMF"`
+	Reason       string  `json:"reason"`
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -24,12 +26,18 @@ type GncEntity struct {
 	IsShelve     int64    `gorm:"column:is_shelve;not null;default:0"     json:"isShelve"` // 0:下架, 1:上架
 	ProductDesc  string   `gorm:"column:product_desc;type:text"           json:"productDesc"`
 	ProductCover string   `gorm:"column:product_cover;size:255"           json:"productCover"`
-	ProductPics  []string `gorm:"-"                                       json:"productPics"` // 示例: 不直接存表，或另存 JSON
+	ProductPics  []string `gorm:"-"                                       json:"productPics"` // 不直接存表, 持久化到 PicsJSON
+	PicsJSON     string   `gorm:"column:product_pics_json;type:text"      json:"-"`
 
 	CallbackURL string `gorm:"column:callback_url;size:255" json:"callbackUrl"`
 	QueryURL    string `gorm:"column:query_url;size:255"    json:"queryUrl"`
 
-	OriginData string `gorm:"column:origin_data;type:text" json:"originData"`
+	OriginData   string     `gorm:"column:origin_data;type:text"  json:"originData"`  // 手工配置的福禄商品ID, 见 UpstreamProductId; 同步不会修改
+	UpstreamRaw  string     `gorm:"column:upstream_raw;type:text" json:"upstreamRaw"` // 最近一次同步时上游返回的原始条目(JSON), 手工录入为空
+	Source       string     `gorm:"column:source;size:20;index"  json:"source"`       // 同步来源 CatalogGift / CatalogCharge, 手工录入为空
+	LastSyncedAt *time.Time `gorm:"column:last_synced_at"        json:"lastSyncedAt"` // 最近一次由远程同步写入, nil 表示手工维护
+
+	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at;index" json:"deletedAt"` // 软删除, 非空即在回收站
 }
 
 // 实现 GiftBase 接口
@@ -39,14 +47,72 @@ func (f *GncEntity) GetQueryURL() string    { return f.QueryURL }
 func (f *GncEntity) GetStatus() int64       { return f.IsShelve }
 
 func (f *GncEntity) BeforeCreate(tx *gorm.DB) (err error) {
-	// you can do something before create
-	return nil
+	f.PicsJSON, err = MarshalStrings(f.ProductPics)
+	return err
 }
 func (f *GncEntity) BeforeUpdate(tx *gorm.DB) (err error) {
-	// you can do something before update
+	f.PicsJSON, err = MarshalStrings(f.ProductPics)
+	return err
+}
+func (f *GncEntity) AfterFind(tx *gorm.DB) (err error) {
+	f.ProductPics = []string{}
+	if f.PicsJSON != "" {
+		_ = json.Unmarshal([]byte(f.PicsJSON), &f.ProductPics)
+	}
 	return nil
 }
 
+// UpstreamProductId 手工配置的福禄商品ID, 未配置时返回空串 (走 VIP_GIFT)
+func (f *GncEntity) UpstreamProductId() string {
+	return strings.TrimSpace(f.OriginData)
+}
+
+// ------------------
+// 1.1 GncSyncRunEntity (远程同步记录)
+// ------------------
+const (
+	SyncTriggerManual   = "manual"
+	SyncTriggerSchedule = "schedule"
+
+	SyncRunRunning = "running"
+	SyncRunSuccess = "success"
+	SyncRunFailed  = "failed"
+)
+
+// 上游已不存在的 base 的处理方式
+const (
+	MissingActionIgnore   = "ignore"
+	MissingActionUnshelve = "unshelve" // 默认: IsShelve 置 0
+	MissingActionDelete   = "delete"
+)
+
+// ParseMissingAction 空串视为默认的 unshelve
+func ParseMissingAction(s string) (string, error) {
+	switch a := strings.ToLower(strings.TrimSpace(s)); a {
+	case "":
+		return MissingActionUnshelve, nil
+	case MissingActionIgnore, MissingActionUnshelve, MissingActionDelete:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown missing action: %s", s)
+	}
+}
+
+type GncSyncRunEntity struct {
+	ID         uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Trigger    string     `gorm:"size:20;not null"         json:"trigger"`
+	Source     string     `gorm:"size:20;not null;index"   json:"source"`
+	Status     string     `gorm:"size:20;not null;index"   json:"status"`
+	Created    int        `gorm:"not null;default:0"       json:"created"`
+	Updated    int        `gorm:"not null;default:0"       json:"updated"`
+	Unchanged  int        `gorm:"not null;default:0"       json:"unchanged"`
+	Missing    int        `gorm:"not null;default:0"       json:"missing"`
+	Failed     int        `gorm:"not null;default:0"       json:"failed"`
+	Error      string     `gorm:"type:text"                json:"error"`
+	StartedAt  time.Time  `gorm:"not null"                 json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+}
+
 // ------------------
 // 2. PubComposeEntity (组合项)
 // ------------------
@@ -54,9 +120,10 @@ type PubComposeEntity struct {
 	ID           uint64 `gorm:"primaryKey;autoIncrement" json:"id"`
 	GiftPublicID uint64 `gorm:"index"                    json:"giftPublicId"`
 
-	BaseCode string `gorm:"size:50;not null"   json:"baseCode"`
-	Strategy string `gorm:"size:100;not null"  json:"strategy"`
-	Snapshot string `gorm:"type:text;not null" json:"snapshot"`
+	BaseCode   string     `gorm:"size:50;not null"   json:"baseCode"`
+	Strategy   string     `gorm:"size:100;not null"  json:"strategy"`
+	Snapshot   string     `gorm:"type:text;not null" json:"snapshot"`   // 保存时 GncEntity 的 JSON 快照(GncSnapshot)
+	SnapshotAt *time.Time `gorm:"column:snapshot_at" json:"snapshotAt"` // 快照时间, nil 表示快照由客户端提供
 }
 
 // 实现 Composition 接口
@@ -87,6 +154,18 @@ type PubEntity struct {
 	Categories       []string           `gorm:"-"                       json:"categories,omitempty"` // 不直接存表
 	CategoriesJSON   string             `gorm:"column:categories_json;type:text"  json:"-"`          // 用于持久化 JSON
 	Fetched          bool               `gorm:"-" json:"fetched,omitempty"`
+	StockTotal       int64              `gorm:"column:stock_total;not null;default:0"     json:"stockTotal"`    // 总库存, 0=不限
+	StockUsed        int64              `gorm:"column:stock_used;not null;default:0"      json:"stockUsed"`     // 已占用库存
+	DailyStock       int64              `gorm:"column:daily_stock;not null;default:0"     json:"dailyStock"`    // 每日库存, 0=不限
+	LimitPerPhone    int64              `gorm:"column:limit_per_phone;not null;default:0" json:"limitPerPhone"` // 每个手机号限购, 0=不限
+	LimitPerUser     int64              `gorm:"column:limit_per_user;not null;default:0"  json:"limitPerUser"`  // 每个 userSn 限购, 0=不限
+	CostPrice        float64            `gorm:"column:cost_price;not null;default:0"      json:"costPrice"`     // 按组合策略估算的成本, base 变更时重算
+	Margin           float64            `gorm:"column:margin;not null;default:0"          json:"margin"`        // SalePrice - CostPrice
+	NeedsReview      int64              `gorm:"column:needs_review;not null;default:0"    json:"needsReview"`   // 1=base 变更后待人工复核
+	ReviewReason     string             `gorm:"column:review_reason;size:255"             json:"reviewReason"`
+	ScheduleOff      bool               `gorm:"column:schedule_off;not null;default:false" json:"-"` // status=0 由时间窗调度写入; 人工下架为 false, 调度不会自动上架
+
+	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at;index" json:"deletedAt"` // 软删除, 组合与订单引用保留, 见 PurgeByPublicCode
 }
 
 // 实现 GiftPublic 接口
@@ -103,28 +182,28 @@ func (d *PubEntity) GetCompositions() []Composition {
 }
 
 func (d *PubEntity) BeforeSave(tx *gorm.DB) (err error) {
-	if d.Pics != nil {
-		b, err := json.Marshal(d.Pics)
-		if err != nil {
-			return err
-		}
-		d.PicsJSON = string(b)
-	} else {
-		d.PicsJSON = "[]"
+	if d.PicsJSON, err = MarshalStrings(d.Pics); err != nil {
+		return err
 	}
 	// 2) 序列化 categories => categories_json
-	if d.Categories != nil {
-		b, err := json.Marshal(d.Categories)
-		if err != nil {
-			return err
-		}
-		d.CategoriesJSON = string(b)
-	} else {
-		d.CategoriesJSON = "[]"
+	if d.CategoriesJSON, err = MarshalStrings(d.Categories); err != nil {
+		return err
 	}
 	return nil
 }
 
+// MarshalStrings 把字符串切片序列化成 JSON 文本, nil 时返回 "[]"
+func MarshalStrings(arr []string) (string, error) {
+	if arr == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(arr)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
 func (d *PubEntity) AfterFind(tx *gorm.DB) (err error) {
 	// 1) 反序列化 pics
 	if d.PicsJSON == "" {
@@ -171,7 +250,9 @@ type OrderStatus int64
 const (
 	StatusInit           OrderStatus = 0   // 初始化
 	StatusPending        OrderStatus = 100 // 进行中
+	StatusParked         OrderStatus = 102 // 上游熔断/并发已满, 暂存待重新下单
 	StatusSuccess        OrderStatus = 200 // 成功
+	StatusPartial        OrderStatus = 206 // 部分成功(组合订单中有子单失败)
 	StatusDownstreamFail OrderStatus = 400 // 下游失败
 	StatusUpstreamFail   OrderStatus = 500 // 上游失败
 )
@@ -183,8 +264,12 @@ func parseStringToOrderStatus(s string) (OrderStatus, error) {
 		return StatusInit, nil
 	case "pending":
 		return StatusPending, nil
+	case "parked":
+		return StatusParked, nil
 	case "success":
 		return StatusSuccess, nil
+	case "partial":
+		return StatusPartial, nil
 	case "fail.downstream":
 		return StatusDownstreamFail, nil
 	case "fail.upstream":
@@ -201,8 +286,12 @@ func (s OrderStatus) String() string {
 		return "init"
 	case StatusPending:
 		return "pending"
+	case StatusParked:
+		return "parked"
 	case StatusSuccess:
 		return "success"
+	case StatusPartial:
+		return "partial"
 	case StatusDownstreamFail:
 		return "fail.downstream"
 	case StatusUpstreamFail:
@@ -219,8 +308,12 @@ func (s OrderStatus) Remark() string {
 		return "订单初始化"
 	case StatusPending:
 		return "订单进行中"
+	case StatusParked:
+		return "上游暂不可用, 等待重新下单"
 	case StatusSuccess:
 		return "订单成功"
+	case StatusPartial:
+		return "订单部分成功"
 	case StatusDownstreamFail:
 		return "订单失败(下游)"
 	case StatusUpstreamFail:
@@ -266,8 +359,12 @@ func (s *OrderStatus) UnmarshalJSON(data []byte) error {
 			*s = StatusInit
 		case "pending":
 			*s = StatusPending
+		case "parked":
+			*s = StatusParked
 		case "success":
 			*s = StatusSuccess
+		case "partial":
+			*s = StatusPartial
 		// 支持两种写法： "fail.downstream" 或 "downstream_fail"
 		case "fail.downstream", "downstream_fail":
 			*s = StatusDownstreamFail
@@ -302,7 +399,7 @@ type OrderEntity struct {
 	ParentSn          string      `gorm:"size:255"                  json:"parentSn"`         // 上级编号
 	DownstreamOrderId string      `gorm:"size:50;uniqueIndex"      json:"downstreamOrderId"` // 外部系统传入的订单ID
 	DataJSON          string      `gorm:"type:text"                json:"dataJSON"`          // 存放订单相关数据
-	Status            OrderStatus `gorm:"not null;default:100"     json:"status"`            // 默认=100 对应StatusPending
+	Status            OrderStatus `gorm:"not null;default:100;index" json:"status"`          // 默认=100 对应StatusPending
 	Remark            string      `gorm:"type:text"                json:"remark"`            // <-- 新增字段
 	CommissionSelf    float64     `gorm:"not null;default:0"       json:"commissionSelf"`    // <-- 自购佣金
 	CommissionParent  float64     `gorm:"not null;default:0"       json:"commissionParent"`  // <-- 上级佣金
@@ -312,6 +409,11 @@ type OrderEntity struct {
 	DeliveryStatus    int64       `gorm:"default:0" json:"deliveryStatus"`
 	SettlementStatus  int64       `gorm:"default:0" json:"settlementStatus"`
 	Channel           string      `gorm:"size:50" json:"channel"` // 渠道
+	PublicCode        string      `gorm:"size:50;index" json:"publicCode"`
+	SalePrice         float64     `gorm:"not null;default:0" json:"salePrice"`    // 下单时价格快照
+	ParValue          float64     `gorm:"not null;default:0" json:"parValue"`     // 下单时面值快照
+	CommissionMF      float64     `gorm:"not null;default:0" json:"commissionMF"` // 下单时佣金快照
+	ClaimedAt         *time.Time  `json:"claimedAt,omitempty"`                    // 暂存订单被重试领取的时间, 租约过期后可再次领取; 写入状态时清空
 	CreatedAt         time.Time   `gorm:"autoCreateTime" json:"createdAt"`
 	UpdatedAt         time.Time   `gorm:"autoUpdateTime" json:"updatedAt"`
 }
@@ -320,3 +422,413 @@ func (o OrderEntity) GetOrderId() string           { return o.OrderId }
 func (o OrderEntity) GetDownstreamOrderId() string { return o.DownstreamOrderId }
 func (o OrderEntity) GetDataJSON() string          { return o.DataJSON }
 func (o OrderEntity) GetStatus() OrderStatus       { return o.Status }
+
+// ------------------
+// 5. PubCategoryLogEntity (批量分类的撤销日志)
+// ------------------
+// 每次批量分类为每个命中的 pub 记录一条变更前/后的 tag 与 categories,
+// 同一批次共享 BatchId, 撤销时按 BatchId 还原旧值
+type PubCategoryLogEntity struct {
+	ID            uint64    `gorm:"primaryKey;autoIncrement"  json:"id"`
+	BatchId       string    `gorm:"size:50;index;not null"    json:"batchId"`
+	PublicCode    string    `gorm:"size:50;not null"          json:"publicCode"`
+	OldTag        string    `gorm:"size:255"                  json:"oldTag"`
+	OldCategories string    `gorm:"type:text"                 json:"oldCategories"` // JSON 数组
+	NewTag        string    `gorm:"size:255"                  json:"newTag"`
+	NewCategories string    `gorm:"type:text"                 json:"newCategories"` // JSON 数组
+	Reverted      bool      `gorm:"not null;default:false"    json:"reverted"`
+	CreatedAt     time.Time `gorm:"autoCreateTime"            json:"createdAt"`
+}
+
+// ------------------
+// 6. PubPriceHistoryEntity (价格变更历史)
+// ------------------
+const (
+	PriceSourceCreate   = "create"   // 新建产品时的初始价格
+	PriceSourceManual   = "manual"   // 通过更新接口修改
+	PriceSourceSchedule = "schedule" // 定时调价生效
+	PriceSourceImport   = "import"   // 批量导入覆盖
+)
+
+type PubPriceHistoryEntity struct {
+	ID              uint64    `gorm:"primaryKey;autoIncrement"      json:"id"`
+	PublicCode      string    `gorm:"size:50;index;not null"        json:"publicCode"`
+	OldSalePrice    float64   `gorm:"not null;default:0"            json:"oldSalePrice"`
+	OldParValue     float64   `gorm:"not null;default:0"            json:"oldParValue"`
+	OldCommissionMF float64   `gorm:"not null;default:0"            json:"oldCommissionMF"`
+	SalePrice       float64   `gorm:"not null;default:0"            json:"salePrice"`
+	ParValue        float64   `gorm:"not null;default:0"            json:"parValue"`
+	CommissionMF    float64   `gorm:"not null;default:0"            json:"commissionMF"`
+	Source          string    `gorm:"size:20;not null"              json:"source"`     // create / manual / schedule
+	ScheduleID      uint64    `gorm:"not null;default:0"            json:"scheduleId"` // Source=schedule 时对应的计划ID
+	ChangedAt       time.Time `gorm:"autoCreateTime;index"          json:"changedAt"`
+}
+
+// ------------------
+// 7. PubPriceScheduleEntity (定时调价计划)
+// ------------------
+type PriceScheduleStatus int64
+
+const (
+	PriceSchedulePending   PriceScheduleStatus = 0 // 待生效
+	PriceScheduleApplied   PriceScheduleStatus = 1 // 已生效
+	PriceScheduleCancelled PriceScheduleStatus = 2 // 已取消
+	PriceScheduleFailed    PriceScheduleStatus = 3 // 生效失败(如产品已删除)
+)
+
+// 价格字段为 nil 表示该字段不调整
+type PubPriceScheduleEntity struct {
+	ID           uint64              `gorm:"primaryKey;autoIncrement"      json:"id"`
+	PublicCode   string              `gorm:"size:50;index;not null"        json:"publicCode"`
+	SalePrice    *float64            `gorm:"column:sale_price"             json:"salePrice,omitempty"`
+	ParValue     *float64            `gorm:"column:par_value"              json:"parValue,omitempty"`
+	CommissionMF *float64            `gorm:"column:commission_mf"          json:"commissionMF,omitempty"`
+	EffectiveAt  time.Time           `gorm:"index;not null"                json:"effectiveAt"`
+	Status       PriceScheduleStatus `gorm:"not null;default:0;index"      json:"status"`
+	Remark       string              `gorm:"size:255"                      json:"remark"`
+	AppliedAt    *time.Time          `gorm:"column:applied_at"             json:"appliedAt,omitempty"`
+	CreatedAt    time.Time           `gorm:"autoCreateTime"                json:"createdAt"`
+}
+
+// ------------------
+// 8. PubAvailabilityEntity (上架时间窗)
+// ------------------
+// 一个 pub 可以有多个时间窗, 只要当前时间落在任一窗口内即视为可售;
+// 没有任何时间窗的 pub 不受调度影响, 仍由人工上下架
+const (
+	RecurrenceNone   = ""       // 仅 [StartAt, EndAt) 区间
+	RecurrenceDaily  = "daily"  // 区间内每天 DailyStart~DailyEnd
+	RecurrenceWeekly = "weekly" // 区间内每周 Weekdays 的 DailyStart~DailyEnd
+)
+
+type PubAvailabilityEntity struct {
+	ID         uint64     `gorm:"primaryKey;autoIncrement"  json:"id"`
+	PublicCode string     `gorm:"size:50;index;not null"    json:"publicCode"`
+	StartAt    *time.Time `gorm:"column:start_at"           json:"startAt,omitempty"` // nil 表示不限开始
+	EndAt      *time.Time `gorm:"column:end_at"             json:"endAt,omitempty"`   // nil 表示不限结束
+	Recurrence string     `gorm:"size:20"                   json:"recurrence"`        // "" / daily / weekly
+	DailyStart string     `gorm:"size:5"                    json:"dailyStart"`        // HH:MM, 本地时间; 与 DailyEnd 都不填表示全天
+	DailyEnd   string     `gorm:"size:5"                    json:"dailyEnd"`          // HH:MM, 小于 DailyStart 表示跨天
+	Weekdays   string     `gorm:"size:20"                   json:"weekdays"`          // 逗号分隔, 0=周日 ... 6=周六
+	Remark     string     `gorm:"size:255"                  json:"remark"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime"            json:"createdAt"`
+}
+
+// Contains 判断时间 t 是否落在该窗口内
+func (w *PubAvailabilityEntity) Contains(t time.Time) bool {
+	if w.StartAt != nil && t.Before(*w.StartAt) {
+		return false
+	}
+	if w.EndAt != nil && !t.Before(*w.EndAt) {
+		return false
+	}
+	switch w.Recurrence {
+	case RecurrenceDaily:
+		return inDailyRange(w.DailyStart, w.DailyEnd, t)
+	case RecurrenceWeekly:
+		if !containsWeekday(w.Weekdays, t.Weekday()) {
+			return false
+		}
+		return inDailyRange(w.DailyStart, w.DailyEnd, t)
+	default:
+		return true
+	}
+}
+
+// InAnyWindow 没有窗口时返回 true (不受限制)
+func InAnyWindow(windows []PubAvailabilityEntity, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for i := range windows {
+		if windows[i].Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseClock 把 "HH:MM" 解析成当天的分钟数
+func ParseClock(s string) (int, error) {
+	hm, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, NewValidationError(fmt.Sprintf("invalid clock %q, want HH:MM", s))
+	}
+	return hm.Hour()*60 + hm.Minute(), nil
+}
+
+// ValidateDailyRange 与 inDailyRange 的规则一致: 都不填表示全天; 只填一个或首尾相同视为无效
+func ValidateDailyRange(start, end string) error {
+	if start == "" && end == "" {
+		return nil
+	}
+	startMin, err := ParseClock(start)
+	if err != nil {
+		return err
+	}
+	endMin, err := ParseClock(end)
+	if err != nil {
+		return err
+	}
+	if startMin == endMin {
+		return NewValidationError("dailyStart and dailyEnd must differ, leave both empty for all day")
+	}
+	return nil
+}
+
+func inDailyRange(start, end string, t time.Time) bool {
+	// 不填表示全天
+	if start == "" && end == "" {
+		return true
+	}
+	startMin, err1 := ParseClock(start)
+	endMin, err2 := ParseClock(end)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	switch {
+	case startMin == endMin:
+		// 校验已拒绝; 历史数据按全天处理
+		return true
+	case startMin < endMin:
+		return now >= startMin && now < endMin
+	default:
+		// 跨天, 如 22:00 ~ 02:00
+		return now >= startMin || now < endMin
+	}
+}
+
+func containsWeekday(weekdays string, wd time.Weekday) bool {
+	for _, part := range strings.Split(weekdays, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err == nil && time.Weekday(n) == wd {
+			return true
+		}
+	}
+	return false
+}
+
+// ------------------
+// 9. PubReservationEntity / PubDailyStockEntity (库存与限购预占)
+// ------------------
+type ReservationStatus int64
+
+const (
+	ReservationReserved ReservationStatus = 1 // 已预占
+	ReservationReleased ReservationStatus = 2 // 订单失败, 已释放
+)
+
+// 每个订单一条预占记录, 用于限购计数和失败时释放
+type PubReservationEntity struct {
+	ID         uint64            `gorm:"primaryKey;autoIncrement" json:"id"`
+	OrderId    string            `gorm:"size:50;uniqueIndex"      json:"orderId"`
+	PublicCode string            `gorm:"size:50;index"            json:"publicCode"`
+	Phone      string            `gorm:"size:20;index"            json:"phone"`
+	UserSn     string            `gorm:"size:255;index"           json:"userSn"`
+	Day        string            `gorm:"size:10"                  json:"day"` // 预占当天 YYYY-MM-DD, 释放时回补当日库存
+	Status     ReservationStatus `gorm:"not null;default:1"       json:"status"`
+	CreatedAt  time.Time         `gorm:"autoCreateTime"           json:"createdAt"`
+	UpdatedAt  time.Time         `gorm:"autoUpdateTime"           json:"updatedAt"`
+}
+
+// 每个 pub 每天一条, 记录当日已占用数量
+type PubDailyStockEntity struct {
+	ID         uint64 `gorm:"primaryKey;autoIncrement"          json:"id"`
+	PublicCode string `gorm:"size:50;uniqueIndex:idx_pub_day"   json:"publicCode"`
+	Day        string `gorm:"size:10;uniqueIndex:idx_pub_day"   json:"day"`
+	Used       int64  `gorm:"not null;default:0"                json:"used"`
+}
+
+// ------------------
+// 10. OrderItemEntity (组合订单的子单)
+// ------------------
+// 策略为 all 的组合产品下单时, 每个 PubComposeEntity 对应一条子单,
+// 子单各自下发、各自查询, 父订单状态由子单汇总(见 RollupStatus);
+// 其它策略每次向一个 base 下发也记一条(Failover=true), 上游订单号各不相同, 父订单状态见 FailoverStatus
+type OrderItemEntity struct {
+	ID              uint64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	OrderId         string      `gorm:"size:50;index;not null"   json:"orderId"`         // 父订单 OrderId
+	CustomerOrderNo string      `gorm:"size:64;uniqueIndex"      json:"customerOrderNo"` // 下发给上游的订单号
+	BaseCode        string      `gorm:"size:50;not null"         json:"baseCode"`
+	ProductId       string      `gorm:"size:100"                 json:"productId"`
+	Source          string      `gorm:"size:20"                  json:"source"`
+	Status          OrderStatus `gorm:"not null;default:0"       json:"status"`
+	Remark          string      `gorm:"type:text"                json:"remark"`
+	DataJSON        string      `gorm:"type:text"                json:"dataJSON"` // 上游查询返回的原始数据
+	Failover        bool        `gorm:"not null;default:false"   json:"failover"` // 非组合订单的逐个下发尝试, 父订单状态取最后一次尝试
+	Sent            bool        `gorm:"not null;default:false"   json:"sent"`     // 已向上游发出(含结果未知); 未发出的才可以用同一订单号重发
+	CreatedAt       time.Time   `gorm:"autoCreateTime"           json:"createdAt"`
+	UpdatedAt       time.Time   `gorm:"autoUpdateTime"           json:"updatedAt"`
+}
+
+// IsFinal 子单是否已到终态
+func (s OrderStatus) IsFinal() bool {
+	switch s {
+	case StatusSuccess, StatusPartial, StatusDownstreamFail, StatusUpstreamFail:
+		return true
+	default:
+		return false
+	}
+}
+
+// FailoverStatus 逐个下发的订单取最后一次尝试的状态; 尚未发出的尝试视为进行中
+func FailoverStatus(attempts []OrderItemEntity) OrderStatus {
+	if len(attempts) == 0 {
+		return StatusInit
+	}
+	last := attempts[len(attempts)-1].Status
+	if last == StatusInit {
+		return StatusPending
+	}
+	return last
+}
+
+// RollupStatus 汇总子单状态:
+// 有未终态的 => pending; 全部成功 => success; 全部失败 => fail.upstream; 否则 => partial
+func RollupStatus(items []OrderItemEntity) OrderStatus {
+	if len(items) == 0 {
+		return StatusInit
+	}
+	success, failed := 0, 0
+	for _, it := range items {
+		switch {
+		case !it.Status.IsFinal():
+			return StatusPending
+		case it.Status == StatusSuccess:
+			success++
+		default:
+			failed++
+		}
+	}
+	switch {
+	case failed == 0:
+		return StatusSuccess
+	case success == 0:
+		return StatusUpstreamFail
+	default:
+		return StatusPartial
+	}
+}
+
+// ------------------
+// 11. PartnerKeyEntity (下游渠道的签名密钥)
+// ------------------
+// 下单请求用 AppKey 标识、用 Secret 做 HMAC 签名(见 SignPartnerRequest),
+// 订单的 UserSn 取自这里, ParentSn 取自 PartnerEntity 的层级, 不再信任请求头
+const (
+	PartnerKeyDisabled int64 = 0
+	PartnerKeyActive   int64 = 1
+)
+
+type PartnerKeyEntity struct {
+	ID         uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	AppKey     string     `gorm:"size:64;uniqueIndex"      json:"appKey"`
+	Secret     string     `gorm:"size:128;not null"        json:"-"`      // 只在签发时返回一次
+	UserSn     string     `gorm:"size:255;index;not null"  json:"userSn"` // PartnerEntity.UserSn
+	Status     int64      `gorm:"not null;default:1"       json:"status"` // 1=启用, 0=停用
+	Remark     string     `gorm:"size:255"                 json:"remark"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime"           json:"createdAt"`
+	UpdatedAt  time.Time  `gorm:"autoUpdateTime"           json:"updatedAt"`
+}
+
+// ------------------
+// 12. PartnerNonceEntity (签名请求防重放)
+// ------------------
+// 时间窗内每个 AppKey 的 nonce 只能用一次, 依赖唯一索引判重, 过期记录定时清理
+type PartnerNonceEntity struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement"          json:"id"`
+	AppKey    string    `gorm:"size:64;uniqueIndex:idx_key_nonce" json:"appKey"`
+	Nonce     string    `gorm:"size:64;uniqueIndex:idx_key_nonce" json:"nonce"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index"              json:"createdAt"`
+}
+
+// ------------------
+// 13. PartnerEntity (下游渠道账户)
+// ------------------
+// 渠道以 UserSn 标识, ParentSn 指向上级渠道形成层级;
+// 下单要求渠道为 active, 上级分佣按这里的层级与 CommissionShare 计算(见 SplitCommission)
+const (
+	PartnerActive    = "active"
+	PartnerSuspended = "suspended"
+
+	// DefaultCommissionShare 渠道自得的佣金比例, 其余归上级
+	DefaultCommissionShare = 0.8
+)
+
+type PartnerEntity struct {
+	ID              uint64    `gorm:"primaryKey;autoIncrement"          json:"id"`
+	UserSn          string    `gorm:"size:255;uniqueIndex;not null"     json:"userSn"`
+	Name            string    `gorm:"size:100;not null"                 json:"name"`
+	ParentSn        string    `gorm:"size:255;index"                    json:"parentSn"` // 空表示顶级渠道
+	Level           int64     `gorm:"not null;default:1"                json:"level"`    // 顶级为 1, 由层级推导
+	Status          string    `gorm:"size:20;not null;default:'active'" json:"status"`
+	AllowedChannels []string  `gorm:"-"                                 json:"allowedChannels"` // CatalogGift / CatalogCharge, 空表示不限
+	ChannelsJSON    string    `gorm:"column:channels_json;type:text"    json:"-"`
+	CommissionShare float64   `gorm:"not null;default:0"                json:"commissionShare"` // 自得比例 0~1, 新建默认 DefaultCommissionShare
+	RateLimit       int64     `gorm:"not null;default:0"                json:"rateLimit"`       // 每秒请求数, 0 用全局默认
+	DailyOrderQuota int64     `gorm:"not null;default:0"                json:"dailyOrderQuota"` // 每日订单数, 0=不限
+	DailyAmountCap  float64   `gorm:"not null;default:0"                json:"dailyAmountCap"`  // 每日订单金额(售价合计), 0=不限
+	ContactName     string    `gorm:"size:50"                           json:"contactName"`
+	ContactPhone    string    `gorm:"size:30"                           json:"contactPhone"`
+	ContactEmail    string    `gorm:"size:100"                          json:"contactEmail"`
+	Remark          string    `gorm:"size:255"                          json:"remark"`
+	CreatedAt       time.Time `gorm:"autoCreateTime"                    json:"createdAt"`
+	UpdatedAt       time.Time `gorm:"autoUpdateTime"                    json:"updatedAt"`
+}
+
+func (p *PartnerEntity) BeforeSave(tx *gorm.DB) (err error) {
+	p.ChannelsJSON, err = MarshalStrings(p.AllowedChannels)
+	return err
+}
+func (p *PartnerEntity) AfterFind(tx *gorm.DB) (err error) {
+	p.AllowedChannels = []string{}
+	if p.ChannelsJSON != "" {
+		_ = json.Unmarshal([]byte(p.ChannelsJSON), &p.AllowedChannels)
+	}
+	return nil
+}
+
+// AllowsChannel AllowedChannels 为空时不限制
+func (p *PartnerEntity) AllowsChannel(channel string) bool {
+	if len(p.AllowedChannels) == 0 {
+		return true
+	}
+	for _, c := range p.AllowedChannels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitCommission 按层级分佣: 渠道得 CommissionShare, 其余归上级;
+// 没有上级或上级未启用时上级部分不发放
+func (p *PartnerEntity) SplitCommission(commissionMF float64, parent *PartnerEntity) (self, toParent float64) {
+	self = commissionMF * p.CommissionShare
+	if parent != nil && parent.Status == PartnerActive {
+		toParent = commissionMF - self
+	}
+	return self, toParent
+}
+
+// ------------------
+// 14. 渠道限流 & 配额
+// ------------------
+// PartnerRateWindowEntity 每个渠道每秒一条计数, 多副本共享; 过期记录定时清理
+type PartnerRateWindowEntity struct {
+	ID       uint64 `gorm:"primaryKey;autoIncrement"           json:"id"`
+	UserSn   string `gorm:"size:255;uniqueIndex:idx_sn_window" json:"userSn"`
+	WindowAt int64  `gorm:"uniqueIndex:idx_sn_window;index"    json:"windowAt"` // unix 秒
+	Count    int64  `gorm:"not null;default:0"                 json:"count"`
+}
+
+// PartnerDailyUsageEntity 每个渠道每天一条, 下单时预占, 下单失败时退回
+type PartnerDailyUsageEntity struct {
+	ID     uint64  `gorm:"primaryKey;autoIncrement"        json:"id"`
+	UserSn string  `gorm:"size:255;uniqueIndex:idx_sn_day" json:"userSn"`
+	Day    string  `gorm:"size:10;uniqueIndex:idx_sn_day"  json:"day"`
+	Orders int64   `gorm:"not null;default:0"              json:"orders"`
+	Amount float64 `gorm:"not null;default:0"              json:"amount"`
+}
@@ -0,0 +1,38 @@
+// internal/types/errors.go
+package types
+
+import "errors"
+
+// 下单时库存/限购校验失败
+var (
+	ErrOutOfStock           = errors.New("product is out of stock")
+	ErrDailyStockExhausted  = errors.New("product daily stock is exhausted")
+	ErrPurchaseLimitReached = errors.New("purchase limit reached")
+)
+
+// PATCH 请求体不合法(未知字段/只读字段/类型不符)
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// pub 创建/修改时毛利校验失败, 可用 marginOverride 强制提交
+var ErrMarginViolation = errors.New("margin check failed")
+
+// 回收站: 同编码的商品仍在回收站 / 永久删除时仍被近期订单或 pub 组合引用
+var (
+	ErrInTrash            = errors.New("product is in trash, restore or purge it first")
+	ErrReferencedByOrders = errors.New("product is referenced by recent orders")
+	ErrReferencedByPubs   = errors.New("base is still composed by pubs")
+)
+
+// 下游签名请求校验失败
+var (
+	ErrInvalidSignature = errors.New("invalid request signature")
+	ErrReplayedRequest  = errors.New("request timestamp expired or nonce reused")
+	ErrPartnerDisabled  = errors.New("partner key is disabled")
+)
+
+// 下单渠道校验失败
+var (
+	ErrPartnerInactive     = errors.New("partner is not active")
+	ErrChannelNotAllowed   = errors.New("channel is not allowed for partner")
+	ErrPartnerHasRelations = errors.New("partner still has child partners or orders")
+)
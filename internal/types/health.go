@@ -0,0 +1,38 @@
+// internal/types/health.go
+package types
+
+import (
+	"context"
+	"time"
+)
+
+// HealthStatus 单项检查或整体的状态
+type HealthStatus string
+
+const (
+	HealthUp       HealthStatus = "up"
+	HealthDegraded HealthStatus = "degraded" // 仅非关键依赖异常, 仍可接流量
+	HealthDown     HealthStatus = "down"
+)
+
+// HealthCheck 一项依赖检查; Critical 的检查失败时整体为 down(就绪探针返回 503)
+type HealthCheck struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+// HealthCheckResult 单项检查结果
+type HealthCheckResult struct {
+	Status    HealthStatus `json:"status"`
+	Critical  bool         `json:"critical"`
+	Error     string       `json:"error,omitempty"`
+	LatencyMs int64        `json:"latencyMs"`
+}
+
+// HealthReport 探针响应
+type HealthReport struct {
+	Status    HealthStatus                 `json:"status"`
+	CheckedAt time.Time                    `json:"checkedAt"`
+	Checks    map[string]HealthCheckResult `json:"checks"`
+}
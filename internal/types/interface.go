@@ -3,6 +3,7 @@ package types
 
 import (
 	"context"
+	"encoding/json"
 
 	"10000hk.com/vip_gift/internal/sink"
 )
@@ -71,3 +72,15 @@ type OrderApi interface {
 	DoCreateOrder(ctx context.Context, dto *OrderDTO) (*sink.OrderCreateResp, error)
 	DoQueryOrder(ctx context.Context, ids []string) ([]sink.OrderQueryResp, error)
 }
+
+// CatalogSource 上游商品目录适配器 (实现见 proxy 包), 供 GncService 同步到 GncEntity
+type CatalogSource interface {
+	// Name 来源标识, 见 CatalogGift / CatalogCharge
+	Name() string
+	// FetchPage 拉取第 page 页的原始条目; 返回条数小于 size 表示最后一页
+	FetchPage(ctx context.Context, page, size int) ([]json.RawMessage, error)
+	// Map 按字段映射把单条原始条目转成 CatalogItem
+	Map(raw json.RawMessage) (CatalogItem, error)
+	// WithToken 未配置 token 时返回使用 token 鉴权的副本(手动同步沿用调用方 token), 已配置时返回自身
+	WithToken(token string) CatalogSource
+}
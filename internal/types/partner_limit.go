@@ -0,0 +1,48 @@
+// internal/types/partner_limit.go
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPartnerRateLimit 渠道未单独配置 RateLimit 时的每秒请求数
+const DefaultPartnerRateLimit = 20
+
+// ParsePartnerRateLimit 空串视为默认值
+func ParsePartnerRateLimit(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return DefaultPartnerRateLimit, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid partner rate limit: %s", s)
+	}
+	return n, nil
+}
+
+// 限流 / 配额耗尽, 通过 RetryAfterError 携带重试时间
+var (
+	ErrRateLimited   = errors.New("rate limit exceeded")
+	ErrQuotaExceeded = errors.New("daily quota exceeded")
+)
+
+// RetryAfterError 需要客户端稍后重试的错误, 对应 HTTP 429 + Retry-After
+type RetryAfterError struct {
+	Err        error
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	if e.Reason == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Reason
+}
+
+func (e *RetryAfterError) Unwrap() error { return e.Err }
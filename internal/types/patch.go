@@ -0,0 +1,135 @@
+// internal/types/patch.go
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Patch 一次部分更新, 支持两种写法:
+//  1. RFC 7396 merge-patch: body 中出现的字段即要修改的字段, null 表示清空
+//  2. updateMask: 显式列出字段(query ?updateMask=a,b 或 body "updateMask"), 列出但 body 中缺省/null 的字段清空
+//
+// 数组(如 compositions)整体替换; 未涉及的字段保持原值
+type Patch struct {
+	Fields         []string
+	Values         map[string]json.RawMessage
+	MarginOverride bool // 控制字段, 不写入实体
+}
+
+// PubPatchFields PubDTO 中允许 PATCH 的字段
+var PubPatchFields = map[string]bool{
+	"productName": true, "salePrice": true, "parValue": true, "commissionMF": true, "commissionRuleMF": true,
+	"cover": true, "desc": true, "pics": true, "categories": true, "tag": true, "status": true,
+	"originData": true, "compositions": true, "fetched": true,
+	"stockTotal": true, "dailyStock": true, "limitPerPhone": true, "limitPerUser": true,
+}
+
+// GncPatchFields GncDTO 中允许 PATCH 的字段
+var GncPatchFields = map[string]bool{
+	"productName": true, "productType": true, "parValue": true, "salePrice": true, "isShelve": true,
+	"productDesc": true, "productCover": true, "productPics": true,
+	"callbackUrl": true, "queryUrl": true, "originData": true,
+}
+
+// ParsePatch 解析 PATCH 请求体; maskParam 为 query 中的 updateMask(逗号分隔), 可为空
+func ParsePatch(body []byte, maskParam string) (*Patch, error) {
+	values := map[string]json.RawMessage{}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &values); err != nil {
+			return nil, fmt.Errorf("patch body must be a JSON object: %w", err)
+		}
+	}
+	p := &Patch{Values: values}
+
+	// 控制字段
+	if raw, ok := values["marginOverride"]; ok {
+		_ = json.Unmarshal(raw, &p.MarginOverride)
+		delete(values, "marginOverride")
+	}
+	var mask []string
+	if raw, ok := values["updateMask"]; ok {
+		delete(values, "updateMask")
+		if err := json.Unmarshal(raw, &mask); err != nil {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return nil, fmt.Errorf("updateMask must be an array or a comma separated string")
+			}
+			mask = strings.Split(s, ",")
+		}
+	}
+	if maskParam != "" {
+		mask = append(mask, strings.Split(maskParam, ",")...)
+	}
+
+	if len(mask) > 0 {
+		for _, f := range mask {
+			if f = strings.TrimSpace(f); f != "" {
+				p.Fields = append(p.Fields, f)
+			}
+		}
+	} else {
+		for f := range values {
+			p.Fields = append(p.Fields, f)
+		}
+	}
+	if len(p.Fields) == 0 {
+		return nil, fmt.Errorf("empty patch")
+	}
+	return p, nil
+}
+
+// Has 是否修改了 field
+func (p *Patch) Has(field string) bool {
+	for _, f := range p.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply 把 patch 应用到 dst(DTO 指针); 字段不在 allowed 中时报错, 不修改 dst
+func (p *Patch) Apply(dst interface{}, allowed map[string]bool) error {
+	for _, f := range p.Fields {
+		if !allowed[f] {
+			return fmt.Errorf("field %q cannot be patched", f)
+		}
+	}
+
+	// 1) 当前值 => map
+	cur, err := json.Marshal(dst)
+	if err != nil {
+		return err
+	}
+	m := map[string]json.RawMessage{}
+	if err := json.Unmarshal(cur, &m); err != nil {
+		return err
+	}
+
+	// 2) 覆盖/清空
+	for _, f := range p.Fields {
+		if v, ok := p.Values[f]; ok && string(v) != "null" {
+			m[f] = v
+		} else {
+			delete(m, f)
+		}
+	}
+
+	// 3) map => 清零后的 dst
+	merged, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(dst).Elem()
+	backup := reflect.New(rv.Type()).Elem()
+	backup.Set(rv)
+	rv.Set(reflect.Zero(rv.Type()))
+	if err := json.Unmarshal(merged, dst); err != nil {
+		rv.Set(backup)
+		return fmt.Errorf("invalid patch value: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// internal/types/propagation.go
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// base 变更后对依赖 pub 的处理策略
+const (
+	PropagationUnshelve = "unshelve" // base 不可用时自动下架 pub, 价格变化标记复核
+	PropagationReview   = "review"   // 只标记复核, 不改状态 (默认)
+	PropagationMargin   = "margin"   // 重算毛利, 毛利为负或不可售时标记复核
+)
+
+// ParsePropagationPolicy 空串视为默认的 review
+func ParsePropagationPolicy(s string) (string, error) {
+	switch p := strings.ToLower(strings.TrimSpace(s)); p {
+	case "":
+		return PropagationReview, nil
+	case PropagationUnshelve, PropagationReview, PropagationMargin:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown propagation policy: %s", s)
+	}
+}
+
+// BaseChange 一次 GncEntity 变更; Old=nil 表示新建, New=nil 表示删除
+type BaseChange struct {
+	BaseCode string
+	Old      *GncEntity
+	New      *GncEntity
+}
+
+// Relevant 只有上下架、价格变化或增删才需要联动
+func (c BaseChange) Relevant() bool {
+	if c.Old == nil || c.New == nil {
+		return true
+	}
+	return c.Old.IsShelve != c.New.IsShelve ||
+		c.Old.SalePrice != c.New.SalePrice ||
+		c.Old.ParValue != c.New.ParValue
+}
+
+// AffectsDependents 变更是否影响依赖它的 pub: 删除、上下架、售价/面值变化;
+// 新建的 base 不改变已有 pub 的售卖条件, 不需要复核
+func (c BaseChange) AffectsDependents() bool {
+	switch {
+	case c.New == nil:
+		return c.Old != nil
+	case c.Old == nil:
+		return false
+	}
+	return c.Relevant()
+}
+
+// Reason 给运营看的变更描述, 写入 PubEntity.ReviewReason
+func (c BaseChange) Reason() string {
+	switch {
+	case c.New == nil:
+		return fmt.Sprintf("base %s deleted", c.BaseCode)
+	case c.Old == nil:
+		return fmt.Sprintf("base %s created", c.BaseCode)
+	case c.Old.IsShelve != c.New.IsShelve && c.New.IsShelve == 0:
+		return fmt.Sprintf("base %s off shelve", c.BaseCode)
+	case c.Old.IsShelve != c.New.IsShelve:
+		return fmt.Sprintf("base %s on shelve", c.BaseCode)
+	case c.Old.SalePrice != c.New.SalePrice:
+		return fmt.Sprintf("base %s salePrice %.2f -> %.2f", c.BaseCode, c.Old.SalePrice, c.New.SalePrice)
+	default:
+		return fmt.Sprintf("base %s parValue %.2f -> %.2f", c.BaseCode, c.Old.ParValue, c.New.ParValue)
+	}
+}
@@ -0,0 +1,185 @@
+// internal/types/pub_csv.go
+package types
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// PubCSVHeader 导入/导出 CSV 的列; 多值列用 "|" 分隔, compositions 形如 "base1:failover|base2:weighted:30"
+var PubCSVHeader = []string{
+	"publicCode", "productName", "salePrice", "parValue", "commissionMF", "commissionRuleMF",
+	"status", "tag", "categories", "cover", "desc", "pics", "originData", "compositions",
+	"stockTotal", "dailyStock", "limitPerPhone", "limitPerUser", "marginOverride",
+}
+
+// ImportError 某一行的校验错误, Row 从 1 开始(CSV 不含表头)
+type ImportError struct {
+	Row        int    `json:"row"`
+	PublicCode string `json:"publicCode,omitempty"`
+	Message    string `json:"message"`
+}
+
+// 导入时每行的动作
+const (
+	ImportCreate    = "create"
+	ImportUpdate    = "update"
+	ImportUnchanged = "unchanged"
+)
+
+// ImportChange 某一行将产生的变化; update 时附字段级 diff
+type ImportChange struct {
+	Row        int         `json:"row"`
+	PublicCode string      `json:"publicCode"`
+	Action     string      `json:"action"`
+	Diffs      []FieldDiff `json:"diffs,omitempty"`
+}
+
+// ImportResult 导入结果; 有任何校验错误时不提交
+type ImportResult struct {
+	DryRun    bool           `json:"dryRun"`
+	Total     int            `json:"total"`
+	Creates   int            `json:"creates"`
+	Updates   int            `json:"updates"`
+	Unchanged int            `json:"unchanged"`
+	Committed int            `json:"committed"`
+	Errors    []ImportError  `json:"errors"`
+	Changes   []ImportChange `json:"changes"`
+}
+
+// ReadPubCSV 解析 CSV, 列按表头名匹配(顺序无关); 单元格格式错误收集到 errs, 不中断
+func ReadPubCSV(r io.Reader) ([]PubDTO, []ImportError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("empty csv")
+	}
+	col := make(map[string]int, len(records[0]))
+	for i, name := range records[0] {
+		// Excel 导出的 CSV 可能带 UTF-8 BOM
+		col[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	if _, ok := col["publicCode"]; !ok {
+		return nil, nil, fmt.Errorf("csv header must contain publicCode")
+	}
+
+	var dtos []PubDTO
+	var errs []ImportError
+	for i, rec := range records[1:] {
+		row := i + 1
+		get := func(name string) string {
+			if idx, ok := col[name]; ok && idx < len(rec) {
+				return strings.TrimSpace(rec[idx])
+			}
+			return ""
+		}
+		var bad []string
+		num := func(name string) float64 {
+			v := get(name)
+			if v == "" {
+				return 0
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				bad = append(bad, fmt.Sprintf("%s=%q is not a number", name, v))
+			}
+			return f
+		}
+
+		dto := PubDTO{
+			PublicCode:       get("publicCode"),
+			ProductName:      get("productName"),
+			SalePrice:        num("salePrice"),
+			ParValue:         num("parValue"),
+			CommissionMF:     num("commissionMF"),
+			CommissionRuleMF: get("commissionRuleMF"),
+			Status:           int64(num("status")),
+			Tag:              get("tag"),
+			Categories:       splitMulti(get("categories")),
+			Cover:            get("cover"),
+			Desc:             get("desc"),
+			Pics:             splitMulti(get("pics")),
+			OriginData:       get("originData"),
+			StockTotal:       intPtr(num("stockTotal")),
+			DailyStock:       intPtr(num("dailyStock")),
+			LimitPerPhone:    intPtr(num("limitPerPhone")),
+			LimitPerUser:     intPtr(num("limitPerUser")),
+			MarginOverride:   get("marginOverride") == "true" || get("marginOverride") == "1",
+		}
+		for _, c := range splitMulti(get("compositions")) {
+			baseCode, strategy, _ := strings.Cut(c, ":")
+			dto.Compositions = append(dto.Compositions, PubComposeDTO{
+				BaseCode: strings.TrimSpace(baseCode),
+				Strategy: strings.TrimSpace(strategy),
+			})
+		}
+		for _, msg := range bad {
+			errs = append(errs, ImportError{Row: row, PublicCode: dto.PublicCode, Message: msg})
+		}
+		dtos = append(dtos, dto)
+	}
+	return dtos, errs, nil
+}
+
+// WritePubCSV 按 PubCSVHeader 导出, 可直接再导入
+func WritePubCSV(w io.Writer, dtos []PubDTO) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(PubCSVHeader); err != nil {
+		return err
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	i := func(v int64) string { return strconv.FormatInt(v, 10) }
+	ip := func(v *int64) string {
+		if v == nil {
+			return "0"
+		}
+		return i(*v)
+	}
+	for _, d := range dtos {
+		comps := make([]string, len(d.Compositions))
+		for k, c := range d.Compositions {
+			comps[k] = c.BaseCode
+			if c.Strategy != "" {
+				comps[k] += ":" + c.Strategy
+			}
+		}
+		rec := []string{
+			d.PublicCode, d.ProductName, f(d.SalePrice), f(d.ParValue), f(d.CommissionMF), d.CommissionRuleMF,
+			i(d.Status), d.Tag, strings.Join(d.Categories, "|"), d.Cover, d.Desc, strings.Join(d.Pics, "|"),
+			d.OriginData, strings.Join(comps, "|"),
+			ip(d.StockTotal), ip(d.DailyStock), ip(d.LimitPerPhone), ip(d.LimitPerUser), "",
+		}
+		if err := writer.Write(rec); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func splitMulti(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, "|")
+	result := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// intPtr CSV 的库存/限购列整行导入, 空单元格即 0(不限)
+func intPtr(v float64) *int64 {
+	n := int64(v)
+	return &n
+}
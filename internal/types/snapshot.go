@@ -0,0 +1,92 @@
+// internal/types/snapshot.go
+package types
+
+import "encoding/json"
+
+// GncSnapshot 组合保存时记录的 base 关键字段, 用于之后检测漂移;
+// 只记归一化后的字段, 不含上游原始条目(同步每次都可能变化, 会造成误报)
+type GncSnapshot struct {
+	BaseCode    string  `json:"baseCode"`
+	ProductName string  `json:"productName"`
+	ProductType int64   `json:"productType"`
+	ParValue    float64 `json:"parValue"`
+	SalePrice   float64 `json:"salePrice"`
+	IsShelve    int64   `json:"isShelve"`
+}
+
+func NewGncSnapshot(g *GncEntity) GncSnapshot {
+	return GncSnapshot{
+		BaseCode:    g.BaseCode,
+		ProductName: g.ProductName,
+		ProductType: g.ProductType,
+		ParValue:    g.ParValue,
+		SalePrice:   g.SalePrice,
+		IsShelve:    g.IsShelve,
+	}
+}
+
+func (s GncSnapshot) JSON() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// ParseGncSnapshot 解析 PubComposeEntity.Snapshot; 旧数据可能是任意文本, 此时 ok=false
+func ParseGncSnapshot(raw string) (GncSnapshot, bool) {
+	var s GncSnapshot
+	if raw == "" || json.Unmarshal([]byte(raw), &s) != nil || s.BaseCode == "" {
+		return GncSnapshot{}, false
+	}
+	return s, true
+}
+
+// FieldDiff 单个字段的新旧值
+type FieldDiff struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// Diff 返回从 s(快照) 到 cur(当前) 发生变化的字段
+func (s GncSnapshot) Diff(cur GncSnapshot) []FieldDiff {
+	var diffs []FieldDiff
+	add := func(field string, old, new interface{}) {
+		diffs = append(diffs, FieldDiff{Field: field, Old: old, New: new})
+	}
+	if s.ProductName != cur.ProductName {
+		add("productName", s.ProductName, cur.ProductName)
+	}
+	if s.ProductType != cur.ProductType {
+		add("productType", s.ProductType, cur.ProductType)
+	}
+	if s.ParValue != cur.ParValue {
+		add("parValue", s.ParValue, cur.ParValue)
+	}
+	if s.SalePrice != cur.SalePrice {
+		add("salePrice", s.SalePrice, cur.SalePrice)
+	}
+	if s.IsShelve != cur.IsShelve {
+		add("isShelve", s.IsShelve, cur.IsShelve)
+	}
+	return diffs
+}
+
+// 漂移原因
+const (
+	DriftChanged     = "changed"      // base 字段与快照不一致
+	DriftMissingBase = "missing_base" // base 已不存在
+	DriftNoSnapshot  = "no_snapshot"  // 旧数据没有可解析的快照
+)
+
+// CompositionDrift 单个组合的漂移
+type CompositionDrift struct {
+	BaseCode string      `json:"baseCode"`
+	Reason   string      `json:"reason"`
+	Diffs    []FieldDiff `json:"diffs,omitempty"`
+}
+
+// PubDrift 一个 pub 下所有发生漂移的组合
+type PubDrift struct {
+	PublicCode   string             `json:"publicCode"`
+	ProductName  string             `json:"productName"`
+	Compositions []CompositionDrift `json:"compositions"`
+}
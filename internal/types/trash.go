@@ -0,0 +1,24 @@
+// internal/types/trash.go
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultPurgeGuardDays 永久删除前检查订单引用的回溯天数
+const DefaultPurgeGuardDays = 90
+
+// ParsePurgeGuardDays 空串视为默认值; 0 表示不检查
+func ParsePurgeGuardDays(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return DefaultPurgeGuardDays, nil
+	}
+	days, err := strconv.Atoi(s)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("invalid purge guard days: %s", s)
+	}
+	return days, nil
+}
@@ -0,0 +1,80 @@
+// internal/types/upstream_guard.go
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 上游熔断 / 并发隔离拒绝的请求, 未真正发到上游
+var (
+	ErrCircuitOpen  = errors.New("upstream circuit breaker is open")
+	ErrBulkheadFull = errors.New("upstream concurrency limit reached")
+)
+
+// IsUpstreamRejected 请求被熔断或并发上限挡住(上游本身未返回失败), 订单应暂存重试而不是判失败
+func IsUpstreamRejected(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrBulkheadFull)
+}
+
+// UpstreamGuardConfig 每个上游 provider 的熔断与并发上限, 由 UPSTREAM_GUARD(JSON)覆盖默认值
+type UpstreamGuardConfig struct {
+	FailureThreshold int   `json:"failureThreshold"` // 连续失败多少次后熔断
+	OpenSeconds      int64 `json:"openSeconds"`      // 熔断持续多久后放一个探测请求(半开)
+	MaxConcurrent    int   `json:"maxConcurrent"`    // 同时在途的请求数上限
+	MaxWaitMs        int64 `json:"maxWaitMs"`        // 并发已满时最多排队等待多久
+}
+
+var DefaultUpstreamGuardConfig = UpstreamGuardConfig{
+	FailureThreshold: 5,
+	OpenSeconds:      30,
+	MaxConcurrent:    20,
+	MaxWaitMs:        500,
+}
+
+func (c UpstreamGuardConfig) OpenDuration() time.Duration {
+	return time.Duration(c.OpenSeconds) * time.Second
+}
+
+func (c UpstreamGuardConfig) MaxWait() time.Duration {
+	return time.Duration(c.MaxWaitMs) * time.Millisecond
+}
+
+// ParseUpstreamGuardConfig 空串为默认值, 如 UPSTREAM_GUARD='{"failureThreshold":3,"maxConcurrent":10}'
+func ParseUpstreamGuardConfig(s string) (UpstreamGuardConfig, error) {
+	cfg := DefaultUpstreamGuardConfig
+	if s == "" {
+		return cfg, nil
+	}
+	if err := json.Unmarshal([]byte(s), &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid upstream guard config: %w", err)
+	}
+	if cfg.FailureThreshold <= 0 || cfg.OpenSeconds <= 0 || cfg.MaxConcurrent <= 0 || cfg.MaxWaitMs < 0 {
+		return cfg, fmt.Errorf("invalid upstream guard config: %s", s)
+	}
+	return cfg, nil
+}
+
+// BreakerState 熔断器状态
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// UpstreamGuardSnapshot 管理接口展示的 provider 状态
+type UpstreamGuardSnapshot struct {
+	Provider            string              `json:"provider"`
+	State               BreakerState        `json:"state"`
+	ConsecutiveFailures int                 `json:"consecutiveFailures"`
+	OpenedAt            *time.Time          `json:"openedAt,omitempty"`
+	RetryAt             *time.Time          `json:"retryAt,omitempty"` // 熔断中: 何时放探测请求
+	InFlight            int                 `json:"inFlight"`
+	RejectedOpen        int64               `json:"rejectedOpen"`     // 因熔断拒绝的累计请求数
+	RejectedBulkhead    int64               `json:"rejectedBulkhead"` // 因并发上限拒绝的累计请求数
+	Config              UpstreamGuardConfig `json:"config"`
+}
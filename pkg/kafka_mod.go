@@ -1,10 +1,14 @@
 package pkg
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
 )
 
 // InitKafkaWriter 创建并返回一个 Kafka Writer
@@ -25,3 +29,81 @@ func InitKafkaWriter(broker string) *kafka.Writer {
 	log.Printf("Kafka Writer init success: broker=%s, topic will assign when write\n", broker)
 	return w
 }
+
+// KafkaHeaders 由 ctx 生成消息头: 关联 ID + W3C trace context(traceparent),
+// 消费者据此把日志与链路串回原始 HTTP 请求
+func KafkaHeaders(ctx context.Context) []kafka.Header {
+	var headers []kafka.Header
+	if id := RequestID(ctx); id != "" {
+		headers = append(headers, kafka.Header{Key: HeaderRequestID, Value: []byte(id)})
+	}
+	otel.GetTextMapPropagator().Inject(ctx, (*kafkaHeaderCarrier)(&headers))
+	return headers
+}
+
+// KafkaContext 从消息头还原关联 ID 与上游 span; 旧消息没有关联 ID 时新生成一个, 保证同一条消息的日志仍可串联
+func KafkaContext(ctx context.Context, headers []kafka.Header) context.Context {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, (*kafkaHeaderCarrier)(&headers))
+	for _, h := range headers {
+		if h.Key == HeaderRequestID && len(h.Value) > 0 {
+			return WithLogAttrs(ctx, LogKeyRequestID, string(h.Value))
+		}
+	}
+	return WithLogAttrs(ctx, LogKeyRequestID, uuid.NewString())
+}
+
+// kafkaHeaderCarrier 让 otel propagator 读写 Kafka 消息头
+type kafkaHeaderCarrier []kafka.Header
+
+func (c *kafkaHeaderCarrier) Get(key string) string {
+	for _, h := range *c {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c *kafkaHeaderCarrier) Set(key, value string) {
+	for i, h := range *c {
+		if h.Key == key {
+			(*c)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c = append(*c, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c *kafkaHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c))
+	for _, h := range *c {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+// PingKafka 连接 broker 并确认各 topic 存在, 供健康检查使用
+func PingKafka(ctx context.Context, broker string, topics ...string) error {
+	conn, err := kafka.DialContext(ctx, "tcp", broker)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", broker, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	partitions, err := conn.ReadPartitions(topics...)
+	if err != nil {
+		return fmt.Errorf("read partitions: %w", err)
+	}
+	found := make(map[string]bool, len(topics))
+	for _, p := range partitions {
+		found[p.Topic] = true
+	}
+	for _, t := range topics {
+		if !found[t] {
+			return fmt.Errorf("topic %s not found", t)
+		}
+	}
+	return nil
+}
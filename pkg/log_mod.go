@@ -0,0 +1,199 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 日志关联字段: 一笔订单从 HTTP 请求 -> Kafka -> 消费者 -> 查单调度 -> 上游回调, 都带同样的字段
+const (
+	LogKeyRequestID         = "requestId"
+	LogKeyOrderID           = "orderId"
+	LogKeyDownstreamOrderID = "downstreamOrderId"
+)
+
+// HeaderRequestID 关联 ID 的 HTTP 请求头 / Kafka 消息头
+const HeaderRequestID = "X-Request-ID"
+
+type logAttrsKey struct{}
+
+// WithLogAttrs 在 ctx 上追加日志字段(键值对或 slog.Attr), 之后 slog.XxxContext(ctx, ...) 的每一行都会带上
+func WithLogAttrs(ctx context.Context, args ...any) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	prev, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	r := slog.Record{}
+	r.Add(args...)
+	attrs := make([]slog.Attr, 0, len(prev)+r.NumAttrs())
+	attrs = append(attrs, prev...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, logAttrsKey{}, attrs)
+}
+
+// LogAttr 取 ctx 上的某个日志字段, 不存在返回 ""
+func LogAttr(ctx context.Context, key string) string {
+	if ctx == nil {
+		return ""
+	}
+	attrs, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	// 后追加的覆盖先追加的
+	for i := len(attrs) - 1; i >= 0; i-- {
+		if attrs[i].Key == key {
+			return attrs[i].Value.String()
+		}
+	}
+	return ""
+}
+
+// WithOrderIDs 补上订单关联字段, ctx 上已有的(或传空串的)不重复追加
+func WithOrderIDs(ctx context.Context, orderId, downstreamOrderId string) context.Context {
+	var args []any
+	if orderId != "" && LogAttr(ctx, LogKeyOrderID) == "" {
+		args = append(args, LogKeyOrderID, orderId)
+	}
+	if downstreamOrderId != "" && LogAttr(ctx, LogKeyDownstreamOrderID) == "" {
+		args = append(args, LogKeyDownstreamOrderID, downstreamOrderId)
+	}
+	if len(args) == 0 {
+		return ctx
+	}
+	return WithLogAttrs(ctx, args...)
+}
+
+// RequestID ctx 上的关联 ID
+func RequestID(ctx context.Context) string {
+	return LogAttr(ctx, LogKeyRequestID)
+}
+
+// NewLogger 创建结构化日志: format 为 json(默认) / text, level 为 debug / info(默认) / warn / error;
+// 自动附加 ctx 上的关联字段, 并对手机号、token 等敏感信息脱敏
+func NewLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lv slog.Level
+	if strings.TrimSpace(level) != "" {
+		if err := lv.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+			return nil, fmt.Errorf("invalid log level: %s", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lv, ReplaceAttr: maskAttr}
+
+	var h slog.Handler
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format: %s", format)
+	}
+	return slog.New(&contextHandler{Handler: h}), nil
+}
+
+// contextHandler 把 WithLogAttrs 放进 ctx 的字段加到每条记录上
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+			r.AddAttrs(attrs...)
+		}
+		// 有 span 时带上 traceId, 日志可直接跳转到链路
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String("traceId", sc.TraceID().String()), slog.String("spanId", sc.SpanID().String()))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// ========== 脱敏 ==========
+
+var (
+	digitRun   = regexp.MustCompile(`[0-9]+`)
+	mobileNo   = regexp.MustCompile(`^1[3-9][0-9]{9}$`)
+	bearerAuth = regexp.MustCompile(`(?i)(bearer\s+)[^\s"',}]+`)
+	jwtLike    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)
+)
+
+// 字段名(不区分大小写)包含这些词时整体替换为 ***
+var secretKeys = []string{"token", "secret", "password", "authorization", "signature", "cookie"}
+
+// 字段名包含这些词时按手机号打码
+var phoneKeys = []string{"phone", "mobile"}
+
+// 关联 ID 不做值脱敏, 否则恰好含 11 位数字时会断链
+var idKeys = map[string]bool{
+	LogKeyRequestID: true, LogKeyOrderID: true, LogKeyDownstreamOrderID: true,
+	"traceId": true, "spanId": true,
+}
+
+func maskAttr(groups []string, a slog.Attr) slog.Attr {
+	// 1) 内置字段: 只处理 msg
+	if len(groups) == 0 {
+		switch a.Key {
+		case slog.TimeKey, slog.LevelKey, slog.SourceKey:
+			return a
+		case slog.MessageKey:
+			return slog.String(a.Key, MaskSensitive(a.Value.String()))
+		}
+	}
+	if idKeys[a.Key] {
+		return a
+	}
+
+	// 2) 按字段名
+	key := strings.ToLower(a.Key)
+	for _, k := range secretKeys {
+		if strings.Contains(key, k) {
+			return slog.String(a.Key, "***")
+		}
+	}
+	for _, k := range phoneKeys {
+		if strings.Contains(key, k) {
+			return slog.String(a.Key, MaskPhone(a.Value.String()))
+		}
+	}
+
+	// 3) 按值: 字符串、error 及 %+v 打印的结构体都转成字符串再扫描
+	switch a.Value.Kind() {
+	case slog.KindString, slog.KindAny:
+		s := a.Value.String()
+		if masked := MaskSensitive(s); masked != s || a.Value.Kind() == slog.KindAny {
+			return slog.String(a.Key, masked)
+		}
+	}
+	return a
+}
+
+// MaskPhone 13812345678 => 138****5678; 非手机号原样返回
+func MaskPhone(s string) string {
+	if !mobileNo.MatchString(s) {
+		return s
+	}
+	return s[:3] + "****" + s[7:]
+}
+
+// MaskSensitive 对自由文本中的手机号、Bearer token 与 JWT 打码
+func MaskSensitive(s string) string {
+	s = digitRun.ReplaceAllStringFunc(s, MaskPhone)
+	s = bearerAuth.ReplaceAllString(s, "${1}***")
+	return jwtLike.ReplaceAllString(s, "***")
+}
@@ -0,0 +1,148 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// OpenAPISchemas 按 Go 类型(json tag)生成 OpenAPI 3 schema;
+// 具名结构体登记到 components.schemas, 引用处返回 $ref
+type OpenAPISchemas struct {
+	defs  map[string]any
+	names map[reflect.Type]string
+}
+
+func NewOpenAPISchemas() *OpenAPISchemas {
+	return &OpenAPISchemas{defs: map[string]any{}, names: map[reflect.Type]string{}}
+}
+
+// Of 返回 v 的类型对应的 schema, v 为 nil 时返回 nil; 已是 map 的直接当作手写 schema
+func (s *OpenAPISchemas) Of(v any) map[string]any {
+	if v == nil {
+		return nil
+	}
+	if m, ok := v.(map[string]any); ok {
+		return m
+	}
+	return s.schema(reflect.TypeOf(v))
+}
+
+// Components 已登记的具名 schema
+func (s *OpenAPISchemas) Components() map[string]any {
+	return s.defs
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	rawMessageType  = reflect.TypeOf(json.RawMessage{})
+	unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_.]+`)
+)
+
+func (s *OpenAPISchemas) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// 1) 特殊类型
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]any{}
+	case t.PkgPath() == "gorm.io/gorm" && t.Name() == "DeletedAt":
+		return map[string]any{"type": "string", "format": "date-time", "nullable": true}
+	}
+
+	// 2) 基础类型
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return s.ref(t)
+	default:
+		// interface{} 等任意值
+		return map[string]any{}
+	}
+}
+
+// ref 具名结构体只展开一次, 递归引用也能终止
+func (s *OpenAPISchemas) ref(t reflect.Type) map[string]any {
+	name, ok := s.names[t]
+	if !ok {
+		name = s.uniqueName(t)
+		s.names[t] = name
+		s.defs[name] = map[string]any{} // 占位, 防止自引用无限展开
+		s.defs[name] = s.object(t)
+	}
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// uniqueName types.PubDTO => types.PubDTO, 泛型 sink.BizDataJSON[...] 去掉非法字符
+func (s *OpenAPISchemas) uniqueName(t reflect.Type) string {
+	base := unsafeNameChars.ReplaceAllString(t.String(), "_")
+	base = strings.Trim(base, "_")
+	name := base
+	for i := 2; ; i++ {
+		if _, taken := s.defs[name]; !taken {
+			return name
+		}
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+}
+
+func (s *OpenAPISchemas) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	s.collectFields(t, props)
+	return map[string]any{"type": "object", "properties": props}
+}
+
+// collectFields 按 encoding/json 的规则取字段: 跳过未导出与 json:"-", 匿名嵌入字段平铺
+func (s *OpenAPISchemas) collectFields(t reflect.Type, props map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.collectFields(ft, props)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = s.schema(f.Type)
+	}
+}
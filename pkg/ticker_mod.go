@@ -0,0 +1,29 @@
+package pkg
+
+import (
+	"log"
+	"time"
+)
+
+// StartTicker 在后台每隔 interval 执行一次 fn, 返回停止函数
+// fn 返回的 error 只记录日志, 不会中断后续执行
+func StartTicker(name string, interval time.Duration, fn func() error) func() {
+	stopCh := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		log.Printf("[%s] ticker started, interval=%s\n", name, interval)
+		for {
+			select {
+			case <-stopCh:
+				log.Printf("[%s] ticker stopped\n", name)
+				return
+			case <-ticker.C:
+				if err := fn(); err != nil {
+					log.Printf("[%s] run error: %v\n", name, err)
+				}
+			}
+		}
+	}()
+	return func() { close(stopCh) }
+}
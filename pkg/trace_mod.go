@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "10000hk.com/vip_gift"
+
+// Tracer 全局 tracer; InitTracer 之前(或未启用导出)返回 no-op 实现
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// InitTracer 初始化链路追踪, exporter 取值:
+//   - otlp:   OTLP/HTTP 导出, 地址等由标准的 OTEL_EXPORTER_OTLP_* 环境变量决定
+//   - stdout / console: 打印到标准输出, 本地调试用
+//   - 空 / none: 不导出, 但仍透传上游带来的 traceparent
+//
+// 采样由 OTEL_TRACES_SAMPLER 控制(默认全采样); 返回的 shutdown 在退出前调用, 把缓冲的 span 刷出去
+func InitTracer(ctx context.Context, exporter, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exp sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(strings.TrimSpace(exporter)) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exp, err = otlptracehttp.New(ctx)
+	case "stdout", "console":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("invalid traces exporter: %s", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create %s trace exporter: %w", exporter, err)
+	}
+
+	// OTEL_SERVICE_NAME / OTEL_RESOURCE_ATTRIBUTES 优先于传入的 serviceName
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("build trace resource: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// RecordSpanError 把错误记到 ctx 当前的 span 上, 没有 span 时为 no-op
+func RecordSpanError(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}